@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"github.com/Vilsol/transcoder-go/metrics"
+	"sync"
+)
+
+// Position in the batch, started counts the files that began transcoding
+var batchStarted int
+var batchTotal int
+var batchLock sync.Mutex
+
+// Counts the files that are going to be transcoded, so every file can be shown as X of N
+func countEligible(fileList []string) int {
+	total := 0
+
+	for _, fileName := range fileList {
+		if !isCompleted(fileName) && shouldTranscode(fileName) {
+			total++
+		}
+	}
+
+	addToBatch(total)
+
+	return total
+}
+
+func addToBatch(count int) {
+	batchLock.Lock()
+	defer batchLock.Unlock()
+
+	batchTotal += count
+
+	metrics.SetQueued(batchTotal - batchStarted)
+}
+
+// Returns the index of the next file and the size of the batch
+func nextBatchIndex() (int, int) {
+	batchLock.Lock()
+	defer batchLock.Unlock()
+
+	batchStarted++
+
+	// Files that showed up after counting still get a sensible position
+	if batchStarted > batchTotal {
+		batchTotal = batchStarted
+	}
+
+	metrics.SetQueued(batchTotal - batchStarted)
+
+	return batchStarted, batchTotal
+}
@@ -0,0 +1,252 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	_ "github.com/mattn/go-sqlite3"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bytes hashed from the start and the end of a file, enough to tell re-encoded sources apart without reading them
+const quickHashChunk = 1024 * 1024
+
+// Markers of the whole library keyed by absolute path, every write only touches its own row
+// so several instances can share one database file
+const databaseSchema = `CREATE TABLE IF NOT EXISTS markers (
+	path TEXT PRIMARY KEY,
+	marker TEXT NOT NULL,
+	updated_at INTEGER NOT NULL
+)`
+
+// How long a write waits for another instance holding the database lock
+const databaseBusyTimeout = 30 * time.Second
+
+// Opened from the db flag on first use
+var database *sql.DB
+var databaseLock sync.Mutex
+
+// Absolute path of the file the sidecar marker belongs to
+func databaseKey(processedFileName string) string {
+	key := filepath.Join(filepath.Dir(processedFileName), markedFileName(processedFileName))
+
+	if absolute, err := filepath.Abs(key); err == nil {
+		return absolute
+	}
+
+	return key
+}
+
+// Opens the database file, creating it and its table if missing
+func openDatabase(fileName string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_busy_timeout=%d", fileName, databaseBusyTimeout.Milliseconds()))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.Exec(databaseSchema); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// Opens the database once
+func loadDatabase() (*sql.DB, error) {
+	databaseLock.Lock()
+	defer databaseLock.Unlock()
+
+	if database != nil {
+		return database, nil
+	}
+
+	db, err := openDatabase(viper.GetString("db"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	database = db
+
+	return database, nil
+}
+
+func readDatabase(key string) ([]byte, bool, error) {
+	db, err := loadDatabase()
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	return selectMarker(db, key)
+}
+
+func writeDatabase(key string, data []byte) error {
+	db, err := loadDatabase()
+
+	if err != nil {
+		return err
+	}
+
+	return upsertMarker(db, key, data)
+}
+
+func deleteDatabase(key string) error {
+	db, err := loadDatabase()
+
+	if err != nil {
+		return err
+	}
+
+	return deleteRow(db, key)
+}
+
+// Collects the results recorded in the database for files inside the directory
+func readDatabaseMarkers(dir string) []reportEntry {
+	entries := make([]reportEntry, 0)
+
+	db, err := loadDatabase()
+
+	if err != nil {
+		log.Errorf("Error reading database %s: %s", viper.GetString("db"), err)
+		return entries
+	}
+
+	absolute, err := filepath.Abs(dir)
+
+	if err != nil {
+		absolute = dir
+	}
+
+	markers, err := selectMarkers(db, absolute)
+
+	if err != nil {
+		log.Errorf("Error reading database %s: %s", viper.GetString("db"), err)
+		return entries
+	}
+
+	for _, marker := range markers {
+		if entry, ok := markerEntry(marker.path, marker.data); ok {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
+
+type databaseMarker struct {
+	path string
+	data []byte
+}
+
+func selectMarker(db *sql.DB, key string) ([]byte, bool, error) {
+	var data string
+
+	err := db.QueryRow("SELECT marker FROM markers WHERE path = ?", key).Scan(&data)
+
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	return []byte(data), true, nil
+}
+
+func upsertMarker(db *sql.DB, key string, data []byte) error {
+	_, err := db.Exec(
+		"INSERT INTO markers (path, marker, updated_at) VALUES (?, ?, ?) "+
+			"ON CONFLICT (path) DO UPDATE SET marker = excluded.marker, updated_at = excluded.updated_at",
+		key, string(data), time.Now().Unix(),
+	)
+
+	return err
+}
+
+func deleteRow(db *sql.DB, key string) error {
+	_, err := db.Exec("DELETE FROM markers WHERE path = ?", key)
+	return err
+}
+
+// Markers of the directory itself and everything below it
+func selectMarkers(db *sql.DB, dir string) ([]databaseMarker, error) {
+	prefix := strings.TrimSuffix(dir, string(filepath.Separator)) + string(filepath.Separator)
+
+	rows, err := db.Query("SELECT path, marker FROM markers WHERE path = ? OR substr(path, 1, length(?)) = ?", dir, prefix, prefix)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	markers := make([]databaseMarker, 0)
+
+	for rows.Next() {
+		var marker databaseMarker
+		var data string
+
+		if err := rows.Scan(&marker.path, &data); err != nil {
+			return nil, err
+		}
+
+		marker.data = []byte(data)
+		markers = append(markers, marker)
+	}
+
+	return markers, rows.Err()
+}
+
+// Hashes the size and the first and last megabyte of the file
+func quickHash(fileName string) (string, error) {
+	file, err := os.Open(fileName)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer file.Close()
+
+	stat, err := file.Stat()
+
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+
+	_, _ = io.WriteString(hash, strconv.FormatInt(stat.Size(), 10))
+
+	if _, err := io.CopyN(hash, file, quickHashChunk); err != nil && err != io.EOF {
+		return "", err
+	}
+
+	if stat.Size() > 2*quickHashChunk {
+		if _, err := file.Seek(-quickHashChunk, io.SeekEnd); err != nil {
+			return "", err
+		}
+
+		if _, err := io.CopyN(hash, file, quickHashChunk); err != nil && err != io.EOF {
+			return "", err
+		}
+	} else if stat.Size() > quickHashChunk {
+		if _, err := io.Copy(hash, file); err != nil {
+			return "", err
+		}
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,187 @@
+package cmd
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func useDatabase(t *testing.T) string {
+	directory, err := ioutil.TempDir("", "transcoder")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	databaseFile := filepath.Join(directory, "library.db")
+	viper.Set("db", databaseFile)
+
+	t.Cleanup(func() {
+		databaseLock.Lock()
+		if database != nil {
+			_ = database.Close()
+			database = nil
+		}
+		databaseLock.Unlock()
+
+		viper.Set("db", nil)
+		os.RemoveAll(directory)
+	})
+
+	return databaseFile
+}
+
+func TestDatabaseRoundTrip(t *testing.T) {
+	useDatabase(t)
+
+	key := databaseKey("/media/show/.episode.mkv.processed")
+
+	if key != "/media/show/episode.mkv" {
+		t.Fatalf("expected key /media/show/episode.mkv, got %s", key)
+	}
+
+	if _, ok, err := readDatabase(key); err != nil || ok {
+		t.Fatalf("expected no marker, got %t %v", ok, err)
+	}
+
+	if err := writeDatabase(key, []byte(`{"result":"error"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeDatabase(key, []byte(`{"result":"replaced"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	data, ok, err := readDatabase(key)
+
+	if err != nil || !ok {
+		t.Fatalf("expected a marker, got %t %v", ok, err)
+	}
+
+	if string(data) != `{"result":"replaced"}` {
+		t.Errorf("expected the second write, got %s", data)
+	}
+
+	if err := deleteDatabase(key); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := deleteDatabase(key); err != nil {
+		t.Errorf("deleting a missing marker failed: %s", err)
+	}
+
+	if _, ok, err := readDatabase(key); err != nil || ok {
+		t.Errorf("expected the marker to be deleted, got %t %v", ok, err)
+	}
+}
+
+// Two instances on one database each only write their own rows
+func TestDatabaseInstancesKeepEachOthersMarkers(t *testing.T) {
+	databaseFile := useDatabase(t)
+
+	first, err := openDatabase(databaseFile)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer first.Close()
+
+	second, err := openDatabase(databaseFile)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer second.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+
+	for i := 0; i < 50; i++ {
+		for instance, db := range []*sql.DB{first, second} {
+			wg.Add(1)
+
+			go func(db *sql.DB, instance int, i int) {
+				defer wg.Done()
+				errs <- upsertMarker(db, fmt.Sprintf("/media/%d/%d.mkv", instance, i), []byte(`{"result":"replaced"}`))
+			}(db, instance, i)
+		}
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for instance := 0; instance < 2; instance++ {
+		markers, err := selectMarkers(first, fmt.Sprintf("/media/%d", instance))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(markers) != 50 {
+			t.Errorf("expected 50 markers of instance %d, got %d", instance, len(markers))
+		}
+	}
+}
+
+func TestSelectMarkers(t *testing.T) {
+	databaseFile := useDatabase(t)
+
+	db, err := openDatabase(databaseFile)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	for _, key := range []string{"/media/show", "/media/show/a.mkv", "/media/show/season/b.mkv", "/media/shows/c.mkv", "/media/other.mkv", "/media/show%/d.mkv"} {
+		if err := upsertMarker(db, key, []byte(`{}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		dir      string
+		expected []string
+	}{
+		{"/media/show", []string{"/media/show", "/media/show/a.mkv", "/media/show/season/b.mkv"}},
+		{"/media/show/", []string{"/media/show/a.mkv", "/media/show/season/b.mkv"}},
+		{"/media/show%", []string{"/media/show%/d.mkv"}},
+		{"/media/season", []string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.dir, func(t *testing.T) {
+			markers, err := selectMarkers(db, test.dir)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			paths := make([]string, 0)
+			for _, marker := range markers {
+				paths = append(paths, marker.path)
+			}
+
+			sort.Strings(paths)
+
+			if fmt.Sprint(paths) != fmt.Sprint(test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, paths)
+			}
+		})
+	}
+}
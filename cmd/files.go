@@ -0,0 +1,273 @@
+package cmd
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"github.com/Vilsol/transcoder-go/transcoder"
+	"github.com/Vilsol/transcoder-go/utils"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Directories passed as arguments, used to mirror relative paths into the output directory
+var sourceDirectories = make([]string, 0)
+
+// Expands directories into the files they contain when running recursively
+func expandPath(path string) []string {
+	if !viper.GetBool("recursive") {
+		return []string{path}
+	}
+
+	stat, err := os.Stat(path)
+
+	if err != nil || !stat.IsDir() {
+		return []string{path}
+	}
+
+	files := make([]string, 0)
+
+	// filepath.Walk does not follow symlinked directories, which avoids loops
+	err = filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			log.Errorf("Error reading %s: %s", file, err)
+			return nil
+		}
+
+		if info.Mode().IsRegular() && hasTranscodeExtension(file) {
+			files = append(files, file)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		log.Errorf("Error walking directory %s: %s", path, err)
+	}
+
+	log.Tracef("Found %s: %d", path, len(files))
+
+	return files
+}
+
+// Returns where the transcoded file should be written inside the output directory
+func outputPath(fileName string) string {
+	return mirrorPath(viper.GetString("output-dir"), fileName)
+}
+
+// Returns the path of the file inside the directory, keeping its path below the source directory
+func mirrorPath(outputDir string, fileName string) string {
+	absolute, err := filepath.Abs(fileName)
+
+	if err != nil {
+		return filepath.Join(outputDir, filepath.Base(fileName))
+	}
+
+	// Mirror the path relative to the deepest source directory containing the file
+	relative := filepath.Base(fileName)
+	longest := 0
+	for _, directory := range sourceDirectories {
+		root, err := filepath.Abs(directory)
+
+		if err != nil || len(root) <= longest {
+			continue
+		}
+
+		if strings.HasPrefix(absolute, root+string(filepath.Separator)) {
+			relative, _ = filepath.Rel(root, absolute)
+			longest = len(root)
+		}
+	}
+
+	return filepath.Join(outputDir, relative)
+}
+
+// Swaps the extension of the file for the one of the output container
+func transcodedFileName(fileName string) string {
+	return strings.TrimSuffix(fileName, filepath.Ext(fileName)) + transcoder.OutputExtension()
+}
+
+// Hidden file next to the output that records the size of the last transcoded file
+func processedFilePath(fileName string) string {
+	return filepath.Join(filepath.Dir(fileName), "."+filepath.Base(fileName)+".processed")
+}
+
+// Name of the transcode written next to a kept source, e.g. movie.h265.mkv
+func keepSourceFileName(fileName string) string {
+	ext := filepath.Ext(fileName)
+	return strings.TrimSuffix(fileName, ext) + viper.GetString("keep-source-suffix") + ext
+}
+
+// Whether the filesystem of the temp file has room for the configured free space
+func hasFreeSpace(fileName string, tempFileName string, originalSize int64) bool {
+	required := int64(viper.GetFloat64("min-free-space-mb") * 1000 * 1000)
+
+	if factor := viper.GetFloat64("min-free-space-factor"); factor > 0 {
+		if bySize := int64(factor * float64(originalSize)); bySize > required {
+			required = bySize
+		}
+	}
+
+	if required <= 0 {
+		return true
+	}
+
+	free, err := utils.FreeSpace(filepath.Dir(tempFileName))
+
+	if err != nil {
+		log.Warningf("Error reading free space of %s, transcoding anyway: %s", filepath.Dir(tempFileName), err)
+		return true
+	}
+
+	if free < required {
+		log.Warningf("Skipping %s: %s free on %s, %s required",
+			fileName,
+			utils.BytesHumanReadable(free),
+			filepath.Dir(tempFileName),
+			utils.BytesHumanReadable(required),
+		)
+		return false
+	}
+
+	return true
+}
+
+// Temp file of the transcode, next to the file unless a scratch directory is configured
+func tempFilePath(fileName string) string {
+	tempDir := viper.GetString("temp-dir")
+
+	if tempDir == "" {
+		return fileName + ".transcode-temp"
+	}
+
+	absolute, err := filepath.Abs(fileName)
+
+	if err != nil {
+		absolute = fileName
+	}
+
+	// Files with the same name from different directories must not share a temp file
+	hash := sha1.Sum([]byte(absolute))
+
+	return filepath.Join(tempDir, hex.EncodeToString(hash[:4])+"-"+filepath.Base(fileName)+".transcode-temp")
+}
+
+// Orders the queue according to the order flag, files that can not be read keep their place at the end
+func sortFiles(fileList []string) []string {
+	order := viper.GetString("order")
+
+	if order == "" {
+		return fileList
+	}
+
+	if order == "random" {
+		rand.Seed(time.Now().UnixNano())
+		rand.Shuffle(len(fileList), func(i, j int) {
+			fileList[i], fileList[j] = fileList[j], fileList[i]
+		})
+		return fileList
+	}
+
+	stats := make(map[string]os.FileInfo, len(fileList))
+	for _, fileName := range fileList {
+		if stat, err := os.Stat(fileName); err == nil {
+			stats[fileName] = stat
+		}
+	}
+
+	sort.SliceStable(fileList, func(i, j int) bool {
+		a, b := stats[fileList[i]], stats[fileList[j]]
+
+		if order == "name" {
+			return fileList[i] < fileList[j]
+		}
+
+		if a == nil || b == nil {
+			return b == nil && a != nil
+		}
+
+		switch order {
+		case "size-asc":
+			return a.Size() < b.Size()
+		case "size-desc":
+			return a.Size() > b.Size()
+		case "mtime":
+			return a.ModTime().Before(b.ModTime())
+		}
+
+		return false
+	})
+
+	return fileList
+}
+
+// Matches the exclude patterns against the full path and every trailing part of it,
+// so */trailers/* matches a trailers directory at any depth
+func isExcluded(fileName string) bool {
+	patterns := viper.GetStringSlice("exclude")
+
+	if len(patterns) == 0 {
+		return false
+	}
+
+	absolute, err := filepath.Abs(fileName)
+
+	if err != nil {
+		absolute = fileName
+	}
+
+	parts := strings.Split(absolute, string(filepath.Separator))
+
+	for _, pattern := range patterns {
+		for i := range parts {
+			if matched, _ := filepath.Match(pattern, strings.Join(parts[i:], string(filepath.Separator))); matched {
+				log.Debugf("Excluded by %s: %s", pattern, fileName)
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Whether the file is within min-size and max-size, both are validated on startup
+func isWithinSizeLimits(fileName string) bool {
+	minSize, _ := parseSizeFlag("min-size")
+	maxSize, _ := parseSizeFlag("max-size")
+
+	if minSize <= 0 && maxSize <= 0 {
+		return true
+	}
+
+	stat, err := os.Stat(fileName)
+
+	if err != nil {
+		log.Errorf("Error reading file %s: %s", fileName, err)
+		return false
+	}
+
+	if minSize > 0 && stat.Size() < minSize {
+		log.Debugf("Smaller than min-size: %s", fileName)
+		return false
+	}
+
+	if maxSize > 0 && stat.Size() > maxSize {
+		log.Debugf("Bigger than max-size: %s", fileName)
+		return false
+	}
+
+	return true
+}
+
+func parseSizeFlag(name string) (int64, error) {
+	if viper.GetString(name) == "" {
+		return 0, nil
+	}
+
+	return utils.ParseHumanReadable(viper.GetString(name))
+}
@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"github.com/spf13/viper"
+	"path/filepath"
+	"testing"
+)
+
+func TestDerivedFileNames(t *testing.T) {
+	viper.Set("container", "mp4")
+	viper.Set("keep-source-suffix", ".h265")
+
+	defer func() {
+		viper.Set("container", nil)
+		viper.Set("keep-source-suffix", nil)
+	}()
+
+	tests := []struct {
+		name       string
+		file       string
+		transcoded string
+		processed  string
+		keepSource string
+		markedFile string
+	}{
+		{
+			name:       "plain",
+			file:       "/media/movie.mkv",
+			transcoded: "/media/movie.mp4",
+			processed:  "/media/.movie.mp4.processed",
+			keepSource: "/media/movie.h265.mp4",
+			markedFile: "movie.mp4",
+		},
+		{
+			name:       "dotted",
+			file:       "/media/My.Show/My.Show.S01E01.mkv",
+			transcoded: "/media/My.Show/My.Show.S01E01.mp4",
+			processed:  "/media/My.Show/.My.Show.S01E01.mp4.processed",
+			keepSource: "/media/My.Show/My.Show.S01E01.h265.mp4",
+			markedFile: "My.Show.S01E01.mp4",
+		},
+		{
+			name:       "no extension in a dotted directory",
+			file:       "/media/My.Show/episode",
+			transcoded: "/media/My.Show/episode.mp4",
+			processed:  "/media/My.Show/.episode.mp4.processed",
+			keepSource: "/media/My.Show/episode.h265.mp4",
+			markedFile: "episode.mp4",
+		},
+		{
+			name:       "hidden",
+			file:       "/media/.movie.mkv",
+			transcoded: "/media/.movie.mp4",
+			processed:  "/media/..movie.mp4.processed",
+			keepSource: "/media/.movie.h265.mp4",
+			markedFile: ".movie.mp4",
+		},
+		{
+			name:       "name equals the extension",
+			file:       "/media/.mkv",
+			transcoded: "/media/.mp4",
+			processed:  "/media/..mp4.processed",
+			keepSource: "/media/.h265.mp4",
+			markedFile: ".mp4",
+		},
+		{
+			name:       "relative",
+			file:       "movie.mkv",
+			transcoded: "movie.mp4",
+			processed:  ".movie.mp4.processed",
+			keepSource: "movie.h265.mp4",
+			markedFile: "movie.mp4",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := filepath.FromSlash(test.file)
+
+			transcoded := transcodedFileName(file)
+			if transcoded != filepath.FromSlash(test.transcoded) {
+				t.Errorf("transcodedFileName = %q, want %q", transcoded, test.transcoded)
+			}
+
+			processed := processedFilePath(transcoded)
+			if processed != filepath.FromSlash(test.processed) {
+				t.Errorf("processedFilePath = %q, want %q", processed, test.processed)
+			}
+
+			if keepSource := keepSourceFileName(transcoded); keepSource != filepath.FromSlash(test.keepSource) {
+				t.Errorf("keepSourceFileName = %q, want %q", keepSource, test.keepSource)
+			}
+
+			if marked := markedFileName(processed); marked != test.markedFile {
+				t.Errorf("markedFileName = %q, want %q", marked, test.markedFile)
+			}
+		})
+	}
+}
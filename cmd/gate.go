@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/Vilsol/transcoder-go/utils"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"strings"
+	"sync"
+	"time"
+)
+
+const gatePollInterval = 30 * time.Second
+
+var loadAverageOnce sync.Once
+
+// Blocks until a new transcode is allowed to start, false if the context got cancelled while waiting
+func waitForStart(ctx context.Context, fileName string) bool {
+	logged := false
+
+	for {
+		reason := startBlockedReason()
+
+		if reason == "" {
+			return true
+		}
+
+		if !logged {
+			log.Infof("Waiting to transcode %s: %s", fileName, reason)
+			logged = true
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(gatePollInterval):
+		}
+	}
+}
+
+// Empty if nothing is stopping a new transcode
+func startBlockedReason() string {
+	if maxLoad := viper.GetFloat64("max-loadavg"); maxLoad > 0 {
+		load, err := utils.LoadAverage()
+
+		if err != nil {
+			loadAverageOnce.Do(func() {
+				log.Warningf("Load average unavailable, ignoring max-loadavg: %s", err)
+			})
+		} else if load >= maxLoad {
+			return "load average above max-loadavg"
+		}
+	}
+
+	if schedule := viper.GetString("schedule"); schedule != "" {
+		start, end, _ := parseSchedule(schedule)
+
+		if !inSchedule(time.Now(), start, end) {
+			return "outside of schedule " + schedule
+		}
+	}
+
+	return ""
+}
+
+// Parses a window like 22:00-06:00 into minutes since midnight
+func parseSchedule(schedule string) (int, int, error) {
+	parts := strings.Split(schedule, "-")
+
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("expected HH:MM-HH:MM: %s", schedule)
+	}
+
+	start, err := time.Parse("15:04", strings.TrimSpace(parts[0]))
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	end, err := time.Parse("15:04", strings.TrimSpace(parts[1]))
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return start.Hour()*60 + start.Minute(), end.Hour()*60 + end.Minute(), nil
+}
+
+func inSchedule(now time.Time, start int, end int) bool {
+	minute := now.Hour()*60 + now.Minute()
+
+	if start <= end {
+		return minute >= start && minute < end
+	}
+
+	// The window wraps past midnight
+	return minute >= start || minute < end
+}
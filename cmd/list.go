@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Reads a list of paths, one per line or NUL delimited like the output of find -print0.
+// Empty entries are skipped. NUL delimited paths are taken as is, lines only lose their
+// line ending and the quotes around quoted paths, so names may start or end with spaces.
+func readPathList(reader io.Reader) ([]string, error) {
+	data, err := ioutil.ReadAll(reader)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.IndexByte(data, 0) >= 0 {
+		paths := make([]string, 0)
+
+		for _, entry := range bytes.Split(data, []byte{0}) {
+			if len(entry) > 0 {
+				paths = append(paths, string(entry))
+			}
+		}
+
+		return paths, nil
+	}
+
+	paths := make([]string, 0)
+
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		path := strings.TrimSuffix(string(line), "\r")
+
+		if len(path) >= 2 && (path[0] == '"' || path[0] == '\'') && path[len(path)-1] == path[0] {
+			path = path[1 : len(path)-1]
+		}
+
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+
+	return paths, nil
+}
+
+// Reads the path list from the file, - for stdin
+func readPathListFile(fileName string) ([]string, error) {
+	if fileName == "-" {
+		return readPathList(os.Stdin)
+	}
+
+	file, err := os.Open(fileName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	return readPathList(file)
+}
@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadPathList(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{"lines", "a.mkv\nb.mkv\n", []string{"a.mkv", "b.mkv"}},
+		{"crlf", "a.mkv\r\nb.mkv\r\n", []string{"a.mkv", "b.mkv"}},
+		{"empty lines", "\na.mkv\n\n\nb.mkv", []string{"a.mkv", "b.mkv"}},
+		{"spaces kept in lines", " a.mkv\nb.mkv \n", []string{" a.mkv", "b.mkv "}},
+		{"quoted", "\"a b.mkv\"\n'c.mkv'\n", []string{"a b.mkv", "c.mkv"}},
+		{"nul", "a.mkv\x00b.mkv\x00", []string{"a.mkv", "b.mkv"}},
+		{"nul keeps whitespace", " a.mkv\x00b\n.mkv \x00", []string{" a.mkv", "b\n.mkv "}},
+		{"nul keeps quotes", "\"a.mkv\"\x00", []string{"\"a.mkv\""}},
+		{"empty", "", []string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := readPathList(strings.NewReader(test.input))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("readPathList(%q) = %q, want %q", test.input, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"context"
+	"github.com/Vilsol/transcoder-go/utils"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How often a waiting instance checks for a free slot, shortened by tests
+var slotPollInterval = 5 * time.Second
+
+// Lock next to the processed marker, keeps other instances from transcoding the same file
+func lockFilePath(processedFileName string) string {
+	return strings.TrimSuffix(processedFileName, ".processed") + ".lock"
+}
+
+// Returns the held lock of the file, nil if another instance is transcoding it
+func lockFile(processedFileName string) *os.File {
+	return acquireLock(lockFilePath(processedFileName))
+}
+
+// Locks are held on the open file, so the lock of an instance that is gone is free again
+func acquireLock(lockFileName string) *os.File {
+	lock, err := utils.TryLock(lockFileName)
+
+	if err != nil && err != utils.ErrLocked {
+		log.Errorf("Error locking file %s: %s", lockFileName, err)
+	}
+
+	return lock
+}
+
+func releaseLock(lock *os.File) {
+	if err := utils.Unlock(lock); err != nil {
+		log.Errorf("Error deleting file %s: %s", lock.Name(), err)
+	}
+}
+
+// Blocks until one of the concurrency slots shared by all instances using the lock dir is free
+// Returns the slot to release, nil if no lock dir is configured or the context got cancelled
+func acquireSlot(ctx context.Context, fileName string) (*os.File, bool) {
+	lockDir := viper.GetString("lock-dir")
+
+	if lockDir == "" {
+		return nil, true
+	}
+
+	slots := viper.GetInt("concurrency")
+	if slots < 1 {
+		slots = 1
+	}
+
+	logged := false
+
+	for {
+		for i := 0; i < slots; i++ {
+			if slot := acquireLock(filepath.Join(lockDir, "slot-"+strconv.Itoa(i)+".lock")); slot != nil {
+				return slot, true
+			}
+		}
+
+		if !logged {
+			log.Infof("Waiting for a free slot in %s: %s", lockDir, fileName)
+			logged = true
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, false
+		case <-time.After(slotPollInterval):
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"context"
+	"github.com/Vilsol/transcoder-go/transcoder/transcodertest"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func useLockDir(t *testing.T, concurrency int) string {
+	lockDir := transcodertest.TempDir(t)
+
+	viper.Set("lock-dir", lockDir)
+	viper.Set("concurrency", concurrency)
+	slotPollInterval = 10 * time.Millisecond
+
+	t.Cleanup(func() {
+		viper.Set("lock-dir", nil)
+		viper.Set("concurrency", nil)
+		slotPollInterval = 5 * time.Second
+	})
+
+	return lockDir
+}
+
+func TestAcquireSlot(t *testing.T) {
+	useLockDir(t, 2)
+
+	slots := make([]*os.File, 0)
+
+	for i := 0; i < 2; i++ {
+		slot, ok := acquireSlot(context.Background(), "video.mkv")
+
+		if !ok || slot == nil {
+			t.Fatalf("slot %d not acquired", i)
+		}
+
+		slots = append(slots, slot)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if slot, ok := acquireSlot(ctx, "video.mkv"); ok || slot != nil {
+		t.Fatalf("acquired a third slot %v", slot)
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		releaseLock(slots[0])
+	}()
+
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	slot, ok := acquireSlot(ctx, "video.mkv")
+
+	if !ok || slot == nil {
+		t.Fatal("released slot not acquired")
+	}
+
+	releaseLock(slot)
+	releaseLock(slots[1])
+}
+
+func TestAcquireSlotLeftBehind(t *testing.T) {
+	lockDir := useLockDir(t, 1)
+
+	// Left behind by an instance that crashed while running as PID 1 of a container
+	if err := ioutil.WriteFile(filepath.Join(lockDir, "slot-0.lock"), []byte("1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	slot, ok := acquireSlot(ctx, "video.mkv")
+
+	if !ok || slot == nil {
+		t.Fatal("left behind slot not acquired")
+	}
+
+	releaseLock(slot)
+}
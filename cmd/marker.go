@@ -0,0 +1,212 @@
+package cmd
+
+import (
+	"encoding/json"
+	"github.com/Vilsol/transcoder-go/utils"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Index of every marker in the directory, used by the central marker mode
+const centralIndexName = ".transcoder.processed.json"
+
+// Extended attribute holding the marker in the xattr marker mode
+const markerAttribute = "user.transcoder.processed"
+
+// Serializes the read-modify-write of central indexes between workers
+var centralIndexLock sync.Mutex
+
+// Returns where markers are stored: sidecar, central, xattr, db or none
+func markerMode() string {
+	if viper.GetBool("no-marker") {
+		return "none"
+	}
+
+	if viper.GetString("db") != "" {
+		return "db"
+	}
+
+	return viper.GetString("marker-mode")
+}
+
+// Name of the file the sidecar marker belongs to
+func markedFileName(processedFileName string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(filepath.Base(processedFileName), "."), ".processed")
+}
+
+// Reads the marker of the file, processedFileName is where its sidecar marker lives.
+// Extended attributes are read from the file itself.
+func readMarker(fileName string, processedFileName string) ([]byte, bool, error) {
+	switch markerMode() {
+	case "none":
+		return nil, false, nil
+	case "xattr":
+		data, err := utils.GetXattr(fileName, markerAttribute)
+		return data, data != nil, err
+	case "db":
+		return readDatabase(databaseKey(processedFileName))
+	case "central":
+		centralIndexLock.Lock()
+		defer centralIndexLock.Unlock()
+
+		index, err := readCentralIndex(filepath.Dir(processedFileName))
+
+		if err != nil {
+			return nil, false, err
+		}
+
+		data, ok := index[markedFileName(processedFileName)]
+		return data, ok, nil
+	}
+
+	data, err := ioutil.ReadFile(processedFileName)
+
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+
+	return data, err == nil, err
+}
+
+func writeMarker(fileName string, processedFileName string, data []byte) error {
+	switch markerMode() {
+	case "none":
+		return nil
+	case "xattr":
+		return utils.SetXattr(fileName, markerAttribute, data)
+	case "db":
+		return writeDatabase(databaseKey(processedFileName), data)
+	case "central":
+		centralIndexLock.Lock()
+		defer centralIndexLock.Unlock()
+
+		directory := filepath.Dir(processedFileName)
+		index, err := readCentralIndex(directory)
+
+		if err != nil {
+			return err
+		}
+
+		index[markedFileName(processedFileName)] = data
+
+		return writeCentralIndex(directory, index)
+	}
+
+	return writeFileAtomic(processedFileName, data)
+}
+
+func deleteMarker(fileName string, processedFileName string) error {
+	switch markerMode() {
+	case "none":
+		return nil
+	case "xattr":
+		err := utils.RemoveXattr(fileName, markerAttribute)
+
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	case "db":
+		return deleteDatabase(databaseKey(processedFileName))
+	case "central":
+		centralIndexLock.Lock()
+		defer centralIndexLock.Unlock()
+
+		directory := filepath.Dir(processedFileName)
+		index, err := readCentralIndex(directory)
+
+		if err != nil {
+			return err
+		}
+
+		if _, ok := index[markedFileName(processedFileName)]; !ok {
+			return nil
+		}
+
+		delete(index, markedFileName(processedFileName))
+
+		return writeCentralIndex(directory, index)
+	}
+
+	err := os.Remove(processedFileName)
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+// Reads the central index of the directory, empty if it has none
+func readCentralIndex(directory string) (map[string]json.RawMessage, error) {
+	return readIndex(filepath.Join(directory, centralIndexName))
+}
+
+// Removes the central index once it is empty, so folders without markers stay clean
+func writeCentralIndex(directory string, index map[string]json.RawMessage) error {
+	indexFileName := filepath.Join(directory, centralIndexName)
+
+	if len(index) == 0 {
+		err := os.Remove(indexFileName)
+
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	return writeIndex(indexFileName, index)
+}
+
+// Reads a JSON object of markers, empty if the file does not exist
+func readIndex(indexFileName string) (map[string]json.RawMessage, error) {
+	index := make(map[string]json.RawMessage)
+
+	data, err := ioutil.ReadFile(indexFileName)
+
+	if os.IsNotExist(err) {
+		return index, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, err
+	}
+
+	return index, nil
+}
+
+func writeIndex(indexFileName string, index map[string]json.RawMessage) error {
+	data, err := json.Marshal(index)
+
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(indexFileName, data)
+}
+
+// Writes next to the file and renames over it, so a crash never leaves a partial file
+func writeFileAtomic(fileName string, data []byte) error {
+	tempFileName := fileName + ".tmp"
+
+	if err := ioutil.WriteFile(tempFileName, data, 0644); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tempFileName, fileName); err != nil {
+		_ = os.Remove(tempFileName)
+		return err
+	}
+
+	return nil
+}
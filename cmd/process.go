@@ -0,0 +1,381 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Vilsol/transcoder-go/config"
+	"github.com/Vilsol/transcoder-go/metrics"
+	"github.com/Vilsol/transcoder-go/models"
+	"github.com/Vilsol/transcoder-go/notifications"
+	"github.com/Vilsol/transcoder-go/transcoder"
+	"github.com/Vilsol/transcoder-go/utils"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var claimedFiles = make(map[string]bool)
+var claimedFilesLock sync.Mutex
+
+var summaryLock sync.Mutex
+
+// Multiplied by the attempt, so every retry waits a bit longer
+const retryBackoff = 5 * time.Second
+
+// Transcodes the file and returns how it turned out.
+// The result is nil when the file was skipped, the error is set when it could not be transcoded.
+func processFile(ctx context.Context, fileName string) (*models.FileResult, error) {
+	if isCompleted(fileName) {
+		log.Debugf("Already completed according to state: %s", fileName)
+		return nil, nil
+	}
+
+	if !shouldTranscode(fileName) {
+		// File already processed
+		return nil, nil
+	}
+
+	extCorrectedOriginal := transcodedFileName(fileName)
+	processedFileName := processedFilePath(extCorrectedOriginal)
+
+	// Inputs sharing an output name share a marker, so claim the marker rather than the input
+	if !claimFile(processedFileName) {
+		log.Warningf("File is already being transcoded: %s", fileName)
+		return nil, nil
+	}
+
+	defer releaseFile(processedFileName)
+
+	metadata, err := transcoder.ReadFileMetadata(fileName)
+
+	if err != nil {
+		transcoder.ResultLog(fileName, models.ResultError, 0, 0).Errorf("Error reading metadata of %s: %s", fileName, err)
+		return &models.FileResult{File: fileName, Result: models.ResultError}, err
+	}
+
+	index, total := nextBatchIndex()
+
+	log.WithField("file", fileName).
+		WithField("original_size", metadata.Format.SizeInt()).
+		WithField("index", index).
+		WithField("total", total).
+		Infof("Transcoding (%d/%d): %s", index, total, fileName)
+
+	tempFileName := tempFilePath(fileName)
+
+	if viper.GetBool("dry-run") {
+		binary, flags, err := transcoder.DryRunCommand(fileName, tempFileName, metadata)
+
+		if err != nil {
+			log.Errorf("Invalid flags for %s: %s", fileName, err)
+			return nil, err
+		}
+
+		log.Infof("Would transcode %s (%s): %s",
+			fileName,
+			utils.BytesHumanReadable(metadata.Format.SizeInt()),
+			utils.ShellQuote(append([]string{binary}, flags...)),
+		)
+
+		return nil, nil
+	}
+
+	lock := lockFile(processedFileName)
+
+	if lock == nil {
+		log.Warningf("File is being transcoded by another instance: %s", fileName)
+		return nil, nil
+	}
+
+	defer releaseLock(lock)
+
+	_, err = os.Stat(tempFileName)
+
+	if err != nil && !os.IsNotExist(err) {
+		log.Errorf("Error reading file %s: %s", tempFileName, err)
+		return nil, err
+	}
+
+	if err == nil && viper.GetBool("force") {
+		// Holding the lock means no other live instance is writing it, so it was left behind by an interrupted run
+		log.Warningf("Deleting leftover temp file %s", tempFileName)
+
+		if err := os.Remove(tempFileName); err != nil && !os.IsNotExist(err) {
+			log.Errorf("Error deleting file %s: %s", tempFileName, err)
+			return nil, err
+		}
+	} else if err == nil {
+		log.Warningf("File is already being transcoded: %s", fileName)
+		return nil, nil
+	}
+
+	if result := skipLowBitrate(fileName, processedFileName, metadata); result != nil {
+		return result, nil
+	}
+
+	if !waitForStart(ctx, fileName) {
+		return nil, nil
+	}
+
+	slot, ok := acquireSlot(ctx, fileName)
+
+	if !ok {
+		return nil, nil
+	}
+
+	if slot != nil {
+		defer releaseLock(slot)
+	}
+
+	if !hasFreeSpace(fileName, tempFileName, metadata.Format.SizeInt()) {
+		return nil, nil
+	}
+
+	if viper.GetBool("estimate") || viper.GetBool("estimate-only") {
+		estimatedSize, err := transcoder.EstimateSize(ctx, fileName, tempFileName+".estimate", metadata)
+
+		if err != nil {
+			log.Errorf("Error estimating size of %s: %s", fileName, err)
+		} else {
+			savings := 0.0
+			if metadata.Format.SizeInt() > 0 {
+				savings = float64(metadata.Format.SizeInt()-estimatedSize) / float64(metadata.Format.SizeInt()) * 100
+			}
+
+			log.WithField("file", fileName).
+				WithField("original_size", metadata.Format.SizeInt()).
+				WithField("estimated_size", estimatedSize).
+				Infof("Estimated %s: %s --> %s (%.2f%% saved)",
+					fileName,
+					utils.BytesHumanReadable(metadata.Format.SizeInt()),
+					utils.BytesHumanReadable(estimatedSize),
+					savings,
+				)
+		}
+
+		if viper.GetBool("estimate-only") || ctx.Err() != nil {
+			return nil, nil
+		}
+
+		if !confirm(fmt.Sprintf("Transcode %s?", fileName)) {
+			log.Infof("Skipped %s", fileName)
+			return nil, nil
+		}
+	}
+
+	updateState(fileName, stateInProgress, "", nil)
+
+	job := notifications.NotifyStart(fileName, metadata, index, total)
+
+	keepSource := viper.GetString("output-dir") != "" || viper.GetBool("keep-source")
+	outputFileName := extCorrectedOriginal
+
+	if viper.GetBool("keep-source") {
+		outputFileName = keepSourceFileName(outputFileName)
+	}
+
+	if viper.GetString("output-dir") != "" {
+		outputFileName = outputPath(outputFileName)
+	}
+
+	progress := transcoder.NewCLIProgress(fileName, metadata, job)
+
+	opts := transcoder.Options{
+		Metadata:          metadata,
+		TempFileName:      tempFileName,
+		OutputFileName:    outputFileName,
+		KeepSource:        keepSource,
+		KeepOld:           config.GetBool(fileName, "keep-old"),
+		EarlyExit:         viper.GetBool("early-exit"),
+		KeepFailed:        viper.GetBool("keep-failed"),
+		MinVMAF:           config.GetFloat64(fileName, "min-vmaf"),
+		MinSavingsPercent: config.GetFloat64(fileName, "min-savings-percent"),
+		DurationTolerance: viper.GetFloat64("duration-tolerance"),
+		Timeout:           viper.GetDuration("timeout"),
+		Retries:           viper.GetInt("retries"),
+		RetryBackoff:      retryBackoff,
+		Thumbnail:         viper.GetBool("notify-thumbnail") && !viper.GetBool("summary-only"),
+		PreserveOwnership: viper.GetBool("preserve-ownership"),
+		OnProgress:        progress.Report,
+		ConfirmReplace: func(originalSize int64, newSize int64) bool {
+			return confirmReplace(fileName, originalSize, newSize)
+		},
+	}
+
+	if viper.GetString("trash-dir") != "" {
+		opts.RemoveOriginal = removeOriginal
+	}
+
+	result, err := transcoder.ProcessFile(ctx, fileName, opts)
+	progress.Finish()
+
+	if result.Reason != "" {
+		job.SetReason(result.Reason)
+	}
+
+	if result.Thumbnail != nil {
+		job.SetThumbnail(result.Thumbnail)
+	}
+
+	if err == transcoder.ErrInterrupted {
+		// Interrupted, so the file stays in progress for the next run
+		updateState(fileName, stateInProgress, "", result.LastReport)
+		job.NotifyEnd(nil, nil, models.ResultError)
+		return &result.FileResult, err
+	}
+
+	switch result.Result {
+	case models.ResultError:
+		job.NotifyEnd(nil, result.LastReport, models.ResultError)
+	case models.ResultKeepOriginal:
+		updateProcessedFile(fileName, processedFileName, newMarker(fileName, models.ResultKeepOriginal, result.OriginalSize, result.NewSize))
+
+		if result.NewMetadata == nil {
+			// Stopped early, only the last report tells how big it got
+			job.NotifyEnd(nil, result.LastReport, models.ResultKeepOriginal)
+		} else {
+			job.NotifyEnd(result.NewMetadata, nil, models.ResultKeepOriginal)
+		}
+	case models.ResultReplaced:
+		if keepSource {
+			// The original stays in place, so it is what has to match next time
+			updateProcessedFile(fileName, processedFileName, newMarker(fileName, models.ResultReplaced, result.OriginalSize, result.NewSize))
+
+			// The output can be inside a watched or later passed directory, so it needs a marker of its own
+			// The result stays on the marker of the original so it is only counted once
+			updateProcessedFile(result.OutputFileName, processedFilePath(result.OutputFileName), models.ProcessedMarker{})
+		} else {
+			updateProcessedFile(result.OutputFileName, processedFileName, newMarker(fileName, models.ResultReplaced, result.OriginalSize, result.NewSize))
+		}
+
+		job.NotifyEnd(result.NewMetadata, nil, models.ResultReplaced)
+	}
+
+	return &result.FileResult, err
+}
+
+// Marks files whose video is already below the skip-below-bitrate threshold as processed, nil if the file is worth transcoding
+func skipLowBitrate(fileName string, processedFileName string, metadata *models.FileMetadata) *models.FileResult {
+	threshold := config.GetInt64(fileName, "skip-below-bitrate")
+
+	if threshold <= 0 {
+		return nil
+	}
+
+	bitrate, err := transcoder.SampleVideoBitrate(fileName, metadata)
+
+	if err != nil {
+		// Transcoding a file that did not need it only costs time
+		log.Warningf("Error sampling bitrate of %s: %s", fileName, err)
+		return nil
+	}
+
+	if bitrate/1000 >= threshold {
+		return nil
+	}
+
+	transcoder.ResultLog(fileName, models.ResultKeepOriginal, metadata.Format.SizeInt(), metadata.Format.SizeInt()).
+		WithField("bitrate", bitrate).
+		Infof("Kept original %s: %d kbit/s < %d kbit/s", fileName, bitrate/1000, threshold)
+
+	updateProcessedFile(fileName, processedFileName, newMarker(fileName, models.ResultKeepOriginal, metadata.Format.SizeInt(), metadata.Format.SizeInt()))
+
+	return &models.FileResult{
+		File:         fileName,
+		Result:       models.ResultKeepOriginal,
+		OriginalSize: metadata.Format.SizeInt(),
+		NewSize:      metadata.Format.SizeInt(),
+	}
+}
+
+func newMarker(fileName string, result models.Result, originalSize int64, newSize int64) models.ProcessedMarker {
+	return models.ProcessedMarker{
+		Result:       result,
+		OriginalSize: originalSize,
+		NewSize:      newSize,
+		Container:    viper.GetString("container"),
+		Flags:        transcoder.BaseFlags(fileName),
+	}
+}
+
+// Adds the result to the summary and the metrics, and writes it to the state and stdout
+func recordResult(summary *models.BatchSummary, result *models.FileResult, err error) {
+	// Interrupted files stay in progress and only count towards the summary
+	completed := err != transcoder.ErrInterrupted
+
+	if completed && result.Result == models.ResultError {
+		// Failed and timed out files are tried again on the next run
+		updateState(result.File, stateFailed, result.Result, nil)
+	} else if completed {
+		updateState(result.File, stateCompleted, result.Result, nil)
+	}
+
+	summaryLock.Lock()
+	defer summaryLock.Unlock()
+
+	summary.Add(result.Result, result.OriginalSize, result.NewSize)
+	metrics.AddResult(result.Result, result.OriginalSize, result.NewSize)
+
+	if completed && viper.GetBool("json-results") {
+		if err := json.NewEncoder(os.Stdout).Encode(result); err != nil {
+			log.Errorf("Error writing result of %s: %s", result.File, err)
+		}
+	}
+}
+
+func claimFile(fileName string) bool {
+	absolute, err := filepath.Abs(fileName)
+
+	if err != nil {
+		absolute = fileName
+	}
+
+	claimedFilesLock.Lock()
+	defer claimedFilesLock.Unlock()
+
+	if claimedFiles[absolute] {
+		return false
+	}
+
+	claimedFiles[absolute] = true
+	return true
+}
+
+func releaseFile(fileName string) {
+	absolute, err := filepath.Abs(fileName)
+
+	if err != nil {
+		absolute = fileName
+	}
+
+	claimedFilesLock.Lock()
+	defer claimedFilesLock.Unlock()
+
+	delete(claimedFiles, absolute)
+}
+
+func uniqueFiles(fileList []string) []string {
+	seen := make(map[string]bool)
+	unique := make([]string, 0, len(fileList))
+
+	for _, fileName := range fileList {
+		absolute, err := filepath.Abs(fileName)
+
+		if err != nil {
+			absolute = fileName
+		}
+
+		if seen[absolute] {
+			continue
+		}
+
+		seen[absolute] = true
+		unique = append(unique, fileName)
+	}
+
+	return unique
+}
@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"context"
+	"github.com/Vilsol/transcoder-go/models"
+	"github.com/Vilsol/transcoder-go/transcoder"
+	"github.com/Vilsol/transcoder-go/transcoder/transcodertest"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Not a test, the fake ffmpeg and ffprobe run as this when the fake executor starts them
+func TestHelperProcess(t *testing.T) {
+	transcodertest.HelperProcess()
+}
+
+// A transcoded mp4 in a directory that is passed again must not be transcoded a second time
+func TestSecondPassSkipsTranscodedOutput(t *testing.T) {
+	tests := []struct {
+		name      string
+		outputDir bool
+		// Relative to the source directory, or the output directory if set
+		wantOutput string
+	}{
+		{name: "in place", wantOutput: "movie.mp4"},
+		{name: "output directory", outputDir: true, wantOutput: filepath.Join("season", "movie.mp4")},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			transcoder.SetExecutor(transcodertest.Executor{Scenario: transcodertest.Scenario{OutputSize: 400, Duration: "60"}})
+
+			source, err := ioutil.TempDir("", "transcoder")
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			output, err := ioutil.TempDir("", "transcoder")
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			viper.Set("recursive", true)
+			viper.Set("extensions", []string{".mkv", ".mp4"})
+			viper.Set("container", "mp4")
+			sourceDirectories = []string{source}
+
+			if test.outputDir {
+				viper.Set("output-dir", output)
+			}
+
+			t.Cleanup(func() {
+				transcoder.SetExecutor(nil)
+
+				for _, key := range []string{"recursive", "extensions", "container", "output-dir"} {
+					viper.Set(key, nil)
+				}
+
+				sourceDirectories = make([]string, 0)
+				os.RemoveAll(source)
+				os.RemoveAll(output)
+			})
+
+			if err := os.Mkdir(filepath.Join(source, "season"), 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			fileName := filepath.Join(source, "season", "movie.mkv")
+
+			if err := ioutil.WriteFile(fileName, make([]byte, 1000), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := processFile(context.Background(), fileName)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if result == nil || result.Result != models.ResultReplaced {
+				t.Fatalf("first pass result = %v, want %s", result, models.ResultReplaced)
+			}
+
+			outputFileName := filepath.Join(source, "season", test.wantOutput)
+			if test.outputDir {
+				outputFileName = filepath.Join(output, test.wantOutput)
+			}
+
+			if _, err := os.Stat(outputFileName); err != nil {
+				t.Fatalf("expected output %s: %s", outputFileName, err)
+			}
+
+			// The second pass also walks the output directory, as a later run over the library would
+			files := expandPath(source)
+			if test.outputDir {
+				files = append(files, expandPath(output)...)
+			}
+
+			found := false
+
+			for _, file := range files {
+				if file == outputFileName {
+					found = true
+				}
+
+				result, err := processFile(context.Background(), file)
+
+				if err != nil || result != nil {
+					t.Errorf("second pass transcoded %s: %v %v", file, result, err)
+				}
+			}
+
+			if !found {
+				t.Errorf("second pass did not see the output %s in %q", outputFileName, files)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/Vilsol/transcoder-go/utils"
+	"github.com/spf13/viper"
+	"os"
+	"strings"
+	"sync"
+)
+
+var promptLock sync.Mutex
+var promptReader = bufio.NewReader(os.Stdin)
+
+// Set once "always" was answered, nothing is asked for the rest of the run
+var replaceAlways bool
+
+// Asks a yes/no question on the terminal, always true when there is nobody to ask
+func confirm(question string) bool {
+	if !utils.IsTerminal(os.Stdin) {
+		return true
+	}
+
+	// Workers running in parallel would otherwise interleave their questions
+	promptLock.Lock()
+	defer promptLock.Unlock()
+
+	_, _ = fmt.Fprintf(os.Stderr, "%s [Y/n] ", question)
+
+	answer, err := promptReader.ReadString('\n')
+
+	if err != nil {
+		return false
+	}
+
+	answer = strings.ToLower(strings.TrimSpace(answer))
+
+	return answer == "" || answer == "y" || answer == "yes"
+}
+
+// Asks whether the original should be replaced by the transcode, always true unless interactive on a terminal.
+// Quitting keeps the original and stops the run like a termination signal.
+func confirmReplace(fileName string, originalSize int64, newSize int64) bool {
+	if !viper.GetBool("interactive") || !utils.IsTerminal(os.Stdin) || !utils.IsTerminal(os.Stdout) {
+		return true
+	}
+
+	promptLock.Lock()
+	defer promptLock.Unlock()
+
+	for !replaceAlways {
+		_, _ = fmt.Fprintf(os.Stderr, "Replace %s (%s --> %s)? [y/n/always/quit] ",
+			fileName,
+			utils.BytesHumanReadable(originalSize),
+			utils.BytesHumanReadable(newSize),
+		)
+
+		answer, err := promptReader.ReadString('\n')
+
+		if err != nil {
+			return false
+		}
+
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "y", "yes":
+			return true
+		case "n", "no":
+			return false
+		case "a", "always":
+			replaceAlways = true
+		case "q", "quit":
+			cancelRoot()
+			return false
+		}
+	}
+
+	return true
+}
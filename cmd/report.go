@@ -0,0 +1,249 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Vilsol/transcoder-go/models"
+	"github.com/Vilsol/transcoder-go/utils"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+const reportBiggestWins = 10
+
+type reportEntry struct {
+	File         string        `json:"file"`
+	Result       models.Result `json:"result"`
+	OriginalSize int64         `json:"original_size"`
+	NewSize      int64         `json:"new_size"`
+	BytesSaved   int64         `json:"bytes_saved"`
+}
+
+type savingsReport struct {
+	Files            int           `json:"files"`
+	Replaced         int           `json:"replaced"`
+	KeptOriginal     int           `json:"kept_original"`
+	OriginalSize     int64         `json:"original_size"`
+	BytesSaved       int64         `json:"bytes_saved"`
+	CompressionRatio float64       `json:"compression_ratio"`
+	BiggestWins      []reportEntry `json:"biggest_wins"`
+}
+
+var reportJSON bool
+
+var reportCmd = &cobra.Command{
+	Use:   "report <dir> ...",
+	Short: "Summarize the space saved according to the processed markers",
+	// Read-only, so none of the ffmpeg checks of the root command are needed
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		initializeLogging()
+	},
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errors.New("must supply at least a single directory")
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		entries := make([]reportEntry, 0)
+
+		for _, dir := range args {
+			if viper.GetString("db") != "" {
+				entries = append(entries, readDatabaseMarkers(dir)...)
+			} else {
+				entries = append(entries, readMarkers(dir)...)
+			}
+		}
+
+		report := buildReport(entries)
+
+		if reportJSON {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+
+			if err := encoder.Encode(report); err != nil {
+				log.Fatalf("Error writing report: %s", err)
+			}
+
+			return
+		}
+
+		printReport(report)
+	},
+}
+
+// Collects the results of every marker inside the directory, whichever marker mode wrote them
+func readMarkers(dir string) []reportEntry {
+	entries := make([]reportEntry, 0)
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		name := info.Name()
+
+		if name == centralIndexName {
+			index, err := readCentralIndex(filepath.Dir(path))
+
+			if err != nil {
+				log.Warningf("Error reading file %s: %s", path, err)
+				return nil
+			}
+
+			for markedName, data := range index {
+				if entry, ok := markerEntry(filepath.Join(filepath.Dir(path), markedName), data); ok {
+					entries = append(entries, entry)
+				}
+			}
+
+			return nil
+		}
+
+		if strings.HasPrefix(name, ".") && strings.HasSuffix(name, ".processed") {
+			data, err := ioutil.ReadFile(path)
+
+			if err != nil {
+				log.Warningf("Error reading file %s: %s", path, err)
+				return nil
+			}
+
+			if entry, ok := markerEntry(filepath.Join(filepath.Dir(path), markedFileName(path)), data); ok {
+				entries = append(entries, entry)
+			}
+
+			return nil
+		}
+
+		// Errors only mean the file or the platform has no extended attributes
+		if data, _ := utils.GetXattr(path, markerAttribute); data != nil {
+			if entry, ok := markerEntry(path, data); ok {
+				entries = append(entries, entry)
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		log.Errorf("Error walking %s: %s", dir, err)
+	}
+
+	return entries
+}
+
+// Parses a marker of the file, false if the marker has no result
+func markerEntry(file string, data []byte) (reportEntry, bool) {
+	var marker models.ProcessedMarker
+	if len(data) == 0 || data[0] != '{' || json.Unmarshal(data, &marker) != nil {
+		// Markers of older versions only contain the size
+		log.Debugf("Skipping marker without result: %s", file)
+		return reportEntry{}, false
+	}
+
+	if marker.Result == "" {
+		return reportEntry{}, false
+	}
+
+	entry := reportEntry{
+		File:         file,
+		Result:       marker.Result,
+		OriginalSize: marker.OriginalSize,
+		NewSize:      marker.NewSize,
+	}
+
+	if marker.Result == models.ResultReplaced {
+		entry.BytesSaved = marker.OriginalSize - marker.NewSize
+	}
+
+	return entry, true
+}
+
+func buildReport(entries []reportEntry) savingsReport {
+	report := savingsReport{}
+
+	replacedOriginalSize := int64(0)
+	replacedNewSize := int64(0)
+
+	for _, entry := range entries {
+		report.Files++
+		report.OriginalSize += entry.OriginalSize
+		report.BytesSaved += entry.BytesSaved
+
+		switch entry.Result {
+		case models.ResultReplaced:
+			report.Replaced++
+			replacedOriginalSize += entry.OriginalSize
+			replacedNewSize += entry.NewSize
+			break
+		case models.ResultKeepOriginal:
+			report.KeptOriginal++
+			break
+		}
+	}
+
+	if replacedNewSize > 0 {
+		report.CompressionRatio = float64(replacedOriginalSize) / float64(replacedNewSize)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].BytesSaved > entries[j].BytesSaved
+	})
+
+	report.BiggestWins = make([]reportEntry, 0, reportBiggestWins)
+
+	for _, entry := range entries {
+		if len(report.BiggestWins) >= reportBiggestWins || entry.BytesSaved <= 0 {
+			break
+		}
+
+		report.BiggestWins = append(report.BiggestWins, entry)
+	}
+
+	return report
+}
+
+func printReport(report savingsReport) {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	_, _ = fmt.Fprintf(writer, "Files:\t%d\n", report.Files)
+	_, _ = fmt.Fprintf(writer, "Replaced:\t%d\n", report.Replaced)
+	_, _ = fmt.Fprintf(writer, "Kept original:\t%d\n", report.KeptOriginal)
+	_, _ = fmt.Fprintf(writer, "Original size:\t%s\n", utils.BytesHumanReadable(report.OriginalSize))
+	_, _ = fmt.Fprintf(writer, "Saved:\t%s\n", utils.BytesHumanReadable(report.BytesSaved))
+	_, _ = fmt.Fprintf(writer, "Compression ratio:\t%.2fx\n", report.CompressionRatio)
+
+	if len(report.BiggestWins) > 0 {
+		_, _ = fmt.Fprintf(writer, "\nSaved\tOriginal\tNew\tFile\n")
+
+		for _, entry := range report.BiggestWins {
+			_, _ = fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n",
+				utils.BytesHumanReadable(entry.BytesSaved),
+				utils.BytesHumanReadable(entry.OriginalSize),
+				utils.BytesHumanReadable(entry.NewSize),
+				entry.File,
+			)
+		}
+	}
+
+	_ = writer.Flush()
+}
+
+func init() {
+	reportCmd.Flags().BoolVar(&reportJSON, "json", false, "Output the report as JSON")
+
+	rootCmd.AddCommand(reportCmd)
+}
@@ -1,8 +1,11 @@
 package cmd
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"github.com/Vilsol/transcoder-go/config"
+	"github.com/Vilsol/transcoder-go/metrics"
 	"github.com/Vilsol/transcoder-go/models"
 	"github.com/Vilsol/transcoder-go/notifications"
 	"github.com/Vilsol/transcoder-go/transcoder"
@@ -10,47 +13,170 @@ import (
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-	"io/ioutil"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"strconv"
-	"strings"
+	"sync"
 	"syscall"
+	"time"
 )
 
-// TODO Make Configurable
-const outputFileExtension = ".mkv"
+// Cancelled once the process receives a termination signal or the prompt quits
+var rootContext, cancelRoot = context.WithCancel(context.Background())
 
-var terminated bool
+// Whether no further files get transcoded
+func terminated() bool {
+	return rootContext.Err() != nil
+}
 
 var LogLevel string
+var LogFormat string
 var ForceColors bool
+var Quiet bool
 
 var rootCmd = &cobra.Command{
-	Use: "transcoder [flags] <path> ...",
+	Use: "transcoder [flags] <path|-> ...",
 
 	Short: "transcoder is an opinionated wrapper around ffmpeg",
+	Long: `transcoder is an opinionated wrapper around ffmpeg
+
+A .transcoder.yaml in a directory overrides the encoding settings (flags, extra-flags,
+audio-mode, audio-bitrate, keep-audio-langs, drop-untagged, loudnorm, subtitles,
+keep-subtitle-langs, keep-forced-subs, hdr, autocrop, deinterlace, pix-fmt, max-height,
+target-size-mb, copy-chapters, auto-chapters, auto-chapters-silence, copy-metadata,
+keep-old, min-vmaf, min-savings-percent, skip-below-bitrate)
+for every file in it and its subdirectories.
+
+loudnorm is two-pass: the audio of every file is decoded once to measure it, and the
+measured values normalize it linearly during the transcode. This pass only reads audio,
+there is no two-pass video encoding it would interact with. Normalized streams have to
+be re-encoded, so audio-mode copy encodes them and flags with -c:a copy can not be used.
+
+Paths are globs: * matches within a name, ? a single character, [a-z] a class,
+** any number of directories and {a,b} either alternative, e.g. media/**/*.{mkv,mp4}.
+Quote them so the shell does not expand them first. - reads a list of paths from stdin.
+
+Settings are resolved per file, highest precedence first:
+  1. Flags passed on the command line
+  2. The nearest .transcoder.yaml that sets the key
+  3. The selected profile
+  4. The config file
+  5. Flag defaults`,
 	PersistentPreRun: func(cmd *cobra.Command, args []string) {
-		level, err := log.ParseLevel(LogLevel)
+		initializeLogging()
 
-		if err != nil {
-			panic(err)
+		// Loading the config logs too, so the flag has to move the logs before it
+		if viper.GetBool("json-results") {
+			log.SetOutput(os.Stderr)
 		}
 
-		log.SetFormatter(&log.TextFormatter{
-			ForceColors: ForceColors,
-		})
-		log.SetOutput(os.Stdout)
-		log.SetLevel(level)
+		config.InitializeConfig(cmd.Flags())
+
+		if viper.GetBool("json-results") {
+			// Keep stdout clean for the results
+			log.SetOutput(os.Stderr)
+		}
+
+		if err := transcoder.CheckBinaries(); err != nil {
+			log.Fatalf("ffmpeg unavailable, install it or point --ffmpeg-path and --ffprobe-path at it: %s", err)
+		}
+
+		if err := transcoder.CheckFlags(); err != nil {
+			log.Fatalf("Invalid flags: %s", err)
+		}
+
+		if err := transcoder.CheckHardwareAcceleration(); err != nil {
+			log.Fatalf("Hardware acceleration unavailable: %s", err)
+		}
+
+		if err := transcoder.CheckPixelFormat(); err != nil {
+			log.Fatalf("Invalid pixel format: %s", err)
+		}
+
+		if err := transcoder.CheckTargetSize(); err != nil {
+			log.Fatalf("Invalid target size: %s", err)
+		}
+
+		if err := transcoder.CheckContainer(); err != nil {
+			log.Fatalf("Invalid container: %s", err)
+		}
+
+		if err := transcoder.CheckPriority(); err != nil {
+			log.Fatalf("Invalid priority: %s", err)
+		}
+
+		for _, key := range []string{"audio-mode", "subtitles", "hdr", "deinterlace"} {
+			if err := config.CheckMode(key, viper.GetString(key)); err != nil {
+				log.Fatalf("Invalid %s: %s", key, err)
+			}
+		}
+
+		if lockDir := viper.GetString("lock-dir"); lockDir != "" {
+			if err := os.MkdirAll(lockDir, 0755); err != nil {
+				log.Fatalf("Invalid lock dir: %s", err)
+			}
+		}
+
+		if tempDir := viper.GetString("temp-dir"); tempDir != "" {
+			if err := os.MkdirAll(tempDir, 0755); err != nil {
+				log.Fatalf("Invalid temp dir: %s", err)
+			}
+		}
+
+		if schedule := viper.GetString("schedule"); schedule != "" {
+			if _, _, err := parseSchedule(schedule); err != nil {
+				log.Fatalf("Invalid schedule: %s", err)
+			}
+		}
+
+		for _, name := range []string{"min-size", "max-size"} {
+			if _, err := parseSizeFlag(name); err != nil {
+				log.Fatalf("Invalid %s: %s", name, err)
+			}
+		}
+
+		for _, pattern := range viper.GetStringSlice("exclude") {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				log.Fatalf("Invalid exclude pattern %s: %s", pattern, err)
+			}
+		}
+
+		switch viper.GetString("order") {
+		case "", "name", "size-asc", "size-desc", "mtime", "random":
+			break
+		default:
+			log.Fatalf("Invalid order: %s", viper.GetString("order"))
+		}
+
+		switch viper.GetString("ffmpeg-loglevel") {
+		case "quiet", "panic", "fatal", "error", "warning", "info", "verbose", "debug", "trace":
+			break
+		default:
+			log.Fatalf("Invalid ffmpeg log level: %s", viper.GetString("ffmpeg-loglevel"))
+		}
+
+		switch viper.GetString("marker-mode") {
+		case "sidecar", "central", "xattr":
+			break
+		default:
+			log.Fatalf("Invalid marker mode: %s", viper.GetString("marker-mode"))
+		}
+
+		if viper.GetBool("force") {
+			log.Warning("Force mode: ignoring processed markers and deleting leftover temp files")
+		}
+
+		if err := notifications.CheckNotifyOn(); err != nil {
+			log.Fatalf("Invalid notify-on: %s", err)
+		}
 
-		config.InitializeConfig()
 		notifications.InitializeNotifications()
 	},
 	Args: func(cmd *cobra.Command, args []string) error {
-		if len(args) < 1 {
+		if len(args) < 1 && viper.GetString("from-file") == "" {
 			return errors.New("must supply at least a single path")
 		}
 
@@ -59,8 +185,22 @@ var rootCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		fileList := make([]string, 0)
 
+		// Listed paths are taken literally, only arguments are globs
+		paths := make([]string, 0)
+
 		for _, arg := range args {
-			files, err := filepath.Glob(arg)
+			if arg == "-" {
+				listed, err := readPathListFile(arg)
+
+				if err != nil {
+					log.Fatalf("Error reading paths from stdin: %s", err)
+				}
+
+				paths = append(paths, listed...)
+				continue
+			}
+
+			files, err := utils.Glob(arg)
 
 			if err != nil {
 				log.Fatal(err)
@@ -68,130 +208,126 @@ var rootCmd = &cobra.Command{
 
 			log.Tracef("Found %s: %d", arg, len(files))
 
-			fileList = append(fileList, files...)
+			paths = append(paths, files...)
 		}
 
-		for _, fileName := range fileList {
-			if terminated {
-				return
-			}
-
-			if !shouldTranscode(fileName) {
-				// File already processed
-				continue
-			}
-
-			log.Infof("Transcoding: %s", fileName)
-			metadata := transcoder.ReadFileMetadata(fileName)
-
-			tempFileName := fileName + ".transcode-temp"
+		if fromFile := viper.GetString("from-file"); fromFile != "" {
+			listed, err := readPathListFile(fromFile)
 
-			_, err := os.Stat(tempFileName)
-
-			if err != nil && !os.IsNotExist(err) {
-				log.Errorf("Error reading file %s: %s", tempFileName, err)
-				continue
+			if err != nil {
+				log.Fatalf("Error reading path list %s: %s", fromFile, err)
 			}
 
-			if err == nil {
-				log.Warningf("File is already being transcoded: %s", fileName)
-				continue
-			}
+			paths = append(paths, listed...)
+		}
 
-			killed, lastReport := transcoder.TranscodeFile(fileName, tempFileName, metadata)
+		for _, file := range paths {
+			fileList = append(fileList, expandPath(file)...)
 
-			if terminated {
-				notifications.NotifyEnd(nil, nil, models.ResultError)
-				continue
+			if stat, err := os.Stat(file); err == nil && stat.IsDir() {
+				sourceDirectories = append(sourceDirectories, file)
 			}
+		}
 
-			lastDot := strings.LastIndex(fileName, ".")
-			extCorrectedOriginal := fileName[:lastDot] + outputFileExtension
-			processedFileName := filepath.Dir(extCorrectedOriginal) + "/." + filepath.Base(extCorrectedOriginal) + ".processed"
-
-			updateProcessedFile(tempFileName, processedFileName)
-
-			if killed {
-				// Assume corrupted output file
-				err := os.Remove(tempFileName)
+		summary := models.BatchSummary{Started: time.Now()}
 
-				if err != nil && !os.IsNotExist(err) {
-					log.Errorf("Error deleting file %s: %s", tempFileName, err)
-					continue
-				}
+		workers := viper.GetInt("concurrency")
+		if workers < 1 {
+			workers = 1
+		}
 
-				if lastReport != nil {
-					if int64(lastReport.TotalSize) > metadata.Format.SizeInt() {
+		if addr := viper.GetString("metrics-addr"); addr != "" {
+			defer metrics.Serve(addr)()
+		}
 
-						log.Infof("Kept original %s: %s < %s",
-							fileName,
-							utils.BytesHumanReadable(metadata.Format.SizeInt()),
-							utils.BytesHumanReadable(int64(lastReport.TotalSize)),
-						)
+		queue := make(chan string)
 
-						notifications.NotifyEnd(nil, lastReport, models.ResultKeepOriginal)
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for fileName := range queue {
+					if result, err := processFile(rootContext, fileName); result != nil {
+						recordResult(&summary, result, err)
 					}
 				}
+			}()
+		}
 
-				continue
-			}
+		if !viper.GetBool("dry-run") {
+			purgeTrash()
+		}
 
-			resultMetadata := transcoder.ReadFileMetadata(tempFileName)
+		loadState()
 
-			if viper.GetBool("keep-old") && resultMetadata.Format.SizeInt() > metadata.Format.SizeInt() {
-				// Transcoded file is bigger than original
-				err := os.Remove(tempFileName)
+		fileList = sortFiles(uniqueFiles(fileList))
+		markQueued(fileList)
+		if countEligible(fileList) == 0 {
+			log.Warningf("No eligible files found (%d paths scanned)", len(fileList))
+		}
 
-				if err != nil {
-					log.Errorf("Error deleting file %s: %s", tempFileName, err)
-					continue
-				}
+		for _, fileName := range fileList {
+			if terminated() {
+				break
+			}
 
-				log.Infof("Kept original %s: %s < %s",
-					fileName,
-					utils.BytesHumanReadable(metadata.Format.SizeInt()),
-					utils.BytesHumanReadable(resultMetadata.Format.SizeInt()),
-				)
+			queue <- fileName
+		}
 
-				notifications.NotifyEnd(resultMetadata, nil, models.ResultKeepOriginal)
-			} else {
-				// Transcoded file is smaller than original
-				err := os.Remove(fileName)
+		if viper.GetBool("watch") && !terminated() {
+			watchDirectories(sourceDirectories, queue)
+		}
 
-				if err != nil {
-					log.Errorf("Error deleting file %s: %s", fileName, err)
-					continue
-				}
+		close(queue)
+		wg.Wait()
 
-				err = os.Rename(tempFileName, extCorrectedOriginal)
+		if summary.Files > 0 {
+			summary.Ended = time.Now()
+			notifications.NotifySummary(&summary)
+		} else if viper.GetBool("fail-on-empty") {
+			log.Fatalf("No files were processed")
+		}
+	},
+}
 
-				if err != nil {
-					log.Errorf("Error renaming file %s to %s: %s", tempFileName, extCorrectedOriginal, err)
-					continue
-				}
+func initializeLogging() {
+	level, err := log.ParseLevel(LogLevel)
 
-				log.Infof("Replaced %s with transcoded: %s < %s",
-					fileName,
-					utils.BytesHumanReadable(resultMetadata.Format.SizeInt()),
-					utils.BytesHumanReadable(metadata.Format.SizeInt()),
-				)
+	if err != nil {
+		panic(err)
+	}
 
-				notifications.NotifyEnd(resultMetadata, nil, models.ResultReplaced)
-			}
+	// Only lowers the level, so --log error stays error
+	if Quiet && level > log.WarnLevel {
+		level = log.WarnLevel
+	}
 
-		}
-	},
+	switch LogFormat {
+	case "text":
+		log.SetFormatter(&log.TextFormatter{
+			ForceColors: ForceColors,
+		})
+		break
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+		break
+	default:
+		log.Fatalf("Unknown log format %q, valid formats are text and json", LogFormat)
+	}
+	log.SetOutput(os.Stdout)
+	log.SetLevel(level)
 }
 
 func Execute() {
-	terminate := make(chan os.Signal)
+	terminate := make(chan os.Signal, 1)
 
 	go func() {
 		<-terminate
-		terminated = true
+		cancelRoot()
 	}()
 
-	signal.Notify(terminate, syscall.SIGINT, syscall.SIGTERM, syscall.SIGKILL)
+	signal.Notify(terminate, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
 	if err := rootCmd.Execute(); err != nil {
 		os.Exit(1)
@@ -204,91 +340,282 @@ func init() {
 	}()
 
 	rootCmd.PersistentFlags().StringVar(&LogLevel, "log", "info", "The log level to output")
+	rootCmd.PersistentFlags().StringVar(&LogFormat, "log-format", "text", "The log format to output (text, json)")
 	rootCmd.PersistentFlags().BoolVar(&ForceColors, "colors", false, "Force output with colors")
-
-	rootCmd.PersistentFlags().StringP("flags", "f", "-map 0 -c:v libx265 -preset ultrafast -x265-params crf=16 -c:a aac -strict -2 -b:a 256k", "The base flags used for all transcodes")
+	rootCmd.PersistentFlags().BoolVarP(&Quiet, "quiet", "q", false, "Only log warnings and errors, notifications and json-results are unaffected")
+
+	rootCmd.PersistentFlags().String("config", "", "Path to a YAML or TOML config file (default transcoder.yaml in . or $HOME/.config/transcoder)")
+	rootCmd.PersistentFlags().String("profile", "", "Named profile from the config file to use")
+	rootCmd.PersistentFlags().String("ffmpeg-path", "ffmpeg", "Path to the ffmpeg binary")
+	rootCmd.PersistentFlags().String("ffprobe-path", "ffprobe", "Path to the ffprobe binary")
+	rootCmd.PersistentFlags().StringP("flags", "f", transcoder.DefaultFlags, "The base flags used for all transcodes")
+	rootCmd.PersistentFlags().String("extra-flags", "", "Flags appended to the base flags right before the output, -vf filters are merged into the generated filter chain")
+	rootCmd.PersistentFlags().String("from-file", "", "Newline or NUL delimited list of paths to transcode, - for stdin")
+	rootCmd.PersistentFlags().Bool("fail-on-empty", false, "Exit with an error when no file was processed")
+	rootCmd.PersistentFlags().StringSlice("exclude", []string{}, "Glob patterns of paths to skip, e.g. *sample* or */trailers/*")
+	rootCmd.PersistentFlags().String("min-size", "", "Skip files smaller than this, e.g. 500MB")
+	rootCmd.PersistentFlags().String("max-size", "", "Skip files bigger than this, e.g. 20GB")
 	rootCmd.PersistentFlags().StringSliceP("extensions", "e", []string{".mp4", ".mkv", ".flv"}, "Transcoded file extensions")
 	rootCmd.PersistentFlags().Int("interval", 5, "How often to output transcoding status")
+	rootCmd.PersistentFlags().Bool("progress-bar", false, "Render a live progress bar instead of periodic status logs when attached to a terminal")
 	rootCmd.PersistentFlags().Bool("stderr", false, "Whether to output ffmpeg stderr stream")
+	rootCmd.PersistentFlags().String("ffmpeg-loglevel", "warning", "Log level of the ffmpeg output forwarded by stderr (quiet, panic, fatal, error, warning, info, verbose, debug, trace)")
 	rootCmd.PersistentFlags().Bool("keep-old", true, "Keep old version of video if transcoded version is larger")
 	rootCmd.PersistentFlags().Bool("early-exit", true, "Early exit if transcoded version is larger than original (requires keep-old)")
 	rootCmd.PersistentFlags().Bool("nice", true, "Whether to lower the priority of ffmpeg process")
+	rootCmd.PersistentFlags().Int("nice-level", 10, "Niceness of the ffmpeg process (requires nice)")
+	rootCmd.PersistentFlags().String("ionice-class", "", "IO scheduling class of the ffmpeg process (idle, best-effort, realtime), empty to leave it alone")
+	rootCmd.PersistentFlags().BoolP("recursive", "r", false, "Recursively transcode files inside directories")
+	rootCmd.PersistentFlags().String("temp-dir", "", "Write temp files into this directory instead of next to the originals")
+	rootCmd.PersistentFlags().String("output-dir", "", "Write transcoded files into this directory instead of replacing the originals")
+	rootCmd.PersistentFlags().Bool("interactive", false, "Ask before replacing each original when attached to a terminal (y/n/always/quit)")
+	rootCmd.PersistentFlags().String("trash-dir", "", "Move replaced originals into this directory instead of deleting them")
+	rootCmd.PersistentFlags().Int("trash-days", 0, "Delete originals that have been in the trash directory for more than this many days (0 to keep them)")
+	rootCmd.PersistentFlags().String("marker-mode", "sidecar", "Where to remember processed files (sidecar, central, xattr), central keeps a single "+centralIndexName+" per directory")
+	rootCmd.PersistentFlags().Bool("no-marker", false, "Do not remember processed files, every run checks every file again")
+	rootCmd.PersistentFlags().Bool("force", false, "Transcode files even if their marker says they were already processed, deleting temp files left behind by interrupted runs")
+	rootCmd.PersistentFlags().String("db", "", "Remember processed files of the whole library in this SQLite database file instead of markers next to them")
+	rootCmd.PersistentFlags().Bool("keep-source", false, "Keep the original and write the transcode next to it with keep-source-suffix")
+	rootCmd.PersistentFlags().String("keep-source-suffix", ".h265", "Suffix inserted before the extension of transcodes written next to a kept source")
+	rootCmd.PersistentFlags().Float64("min-savings-percent", 0, "Keep the original unless the transcode is at least this many percent smaller (0 to disable)")
+	rootCmd.PersistentFlags().Float64("duration-tolerance", 2, "Seconds the transcode may be shorter than the original before it counts as failed (0 to disable)")
+	rootCmd.PersistentFlags().Int64("skip-below-bitrate", 0, "Skip files whose sampled video bitrate is below this many kbit/s, marking them processed (0 to disable)")
+	rootCmd.PersistentFlags().Float64("min-vmaf", 0, "Keep the original if the VMAF score of the transcode is below this, files it can not be computed for fail (0 to disable)")
+	rootCmd.PersistentFlags().Bool("preserve-ownership", false, "Copy owner and permissions of the original onto the replacement")
+	rootCmd.PersistentFlags().Bool("keep-failed", false, "Keep the output of failed or killed transcodes as <file>.failed")
+	rootCmd.PersistentFlags().Int("retries", 0, "How often to retry a file when ffmpeg fails")
+	rootCmd.PersistentFlags().Duration("timeout", 0, "Kill ffmpeg if a single file takes longer than this (0 to disable)")
+	rootCmd.PersistentFlags().String("state-file", "", "JSON file tracking the queue, completed files are skipped on the next run")
+	rootCmd.PersistentFlags().String("metrics-addr", "", "Serve Prometheus metrics on this address, e.g. :9090")
+	rootCmd.PersistentFlags().Bool("json-results", false, "Print one JSON object per processed file to stdout, logs go to stderr")
+	rootCmd.PersistentFlags().Bool("estimate", false, "Estimate the output size from a test encode and ask before transcoding")
+	rootCmd.PersistentFlags().Bool("estimate-only", false, "Only log the estimated output size without transcoding")
+	rootCmd.PersistentFlags().Float64("estimate-seconds", 60, "Length of the test encode used for estimates")
+	rootCmd.PersistentFlags().Bool("dry-run", false, "Only log what would be transcoded without changing any files")
+	rootCmd.PersistentFlags().Bool("watch", false, "Keep running and transcode new files as they appear in the directories")
+	rootCmd.PersistentFlags().Duration("watch-settle", 5*time.Second, "How long a new file must stay the same size before it is transcoded")
+	rootCmd.PersistentFlags().Float64("max-loadavg", 0, "Wait with new files until the 1 minute load average is below this (0 to disable, linux only)")
+	rootCmd.PersistentFlags().Float64("min-free-space-mb", 0, "Skip files when the temp file's filesystem has less free space than this (0 to disable)")
+	rootCmd.PersistentFlags().Float64("min-free-space-factor", 0, "Skip files when there is less free space than this multiple of the file size (0 to disable)")
+	rootCmd.PersistentFlags().String("schedule", "", "Only start new files inside this daily window, e.g. 22:00-06:00")
+	rootCmd.PersistentFlags().Int("threads", 0, "Threads per ffmpeg worker, slower presets lose the most speed from a low limit (0 for ffmpeg's default)")
+	rootCmd.PersistentFlags().String("order", "", "Order of the queue (name, size-asc, size-desc, mtime, random), empty for argument order")
+	rootCmd.PersistentFlags().Int("concurrency", 1, "How many files to transcode in parallel")
+	rootCmd.PersistentFlags().String("lock-dir", "", "Directory shared with other instances so that together they stay within concurrency")
+	rootCmd.PersistentFlags().String("container", "mkv", "Output container (mkv, mp4, webm)")
+	rootCmd.PersistentFlags().String("audio-mode", "", "How to handle audio streams (copy, aac, opus), empty to use the base flags")
+	rootCmd.PersistentFlags().String("audio-bitrate", "256k", "Bitrate of re-encoded audio streams (requires audio-mode)")
+	rootCmd.PersistentFlags().StringSlice("keep-audio-langs", []string{}, "Only keep audio streams in these languages, e.g. en,ja (applies on top of the -map of the flags)")
+	rootCmd.PersistentFlags().Bool("drop-untagged", false, "Also drop audio and subtitle streams without a language tag when keep-audio-langs or keep-subtitle-langs is set")
+	rootCmd.PersistentFlags().Bool("loudnorm", false, "Normalize the loudness of every audio stream (EBU R128), measured in a separate pass before the transcode")
+	rootCmd.PersistentFlags().Float64("loudnorm-target", -23, "Integrated loudness in LUFS that loudnorm normalizes to")
+	rootCmd.PersistentFlags().String("subtitles", "copy", "How to handle subtitle streams (copy, convert, drop)")
+	rootCmd.PersistentFlags().StringSlice("keep-subtitle-langs", []string{}, "Only keep subtitle streams in these languages, e.g. en,ja (applies on top of the -map of the flags)")
+	rootCmd.PersistentFlags().Bool("keep-forced-subs", false, "Keep forced subtitle streams in any language when keep-subtitle-langs is set")
+	rootCmd.PersistentFlags().Bool("copy-chapters", true, "Copy chapters into the output, disable to strip them")
+	rootCmd.PersistentFlags().Bool("auto-chapters", false, "Add chapters at long silences to files without chapters, e.g. tracks of a concert recording")
+	rootCmd.PersistentFlags().Float64("auto-chapters-silence", 2, "Minimum length in seconds of a silence that starts a new chapter (requires auto-chapters)")
+	rootCmd.PersistentFlags().Bool("copy-metadata", true, "Copy global metadata into the output, disable to strip it")
+	rootCmd.PersistentFlags().Bool("faststart", false, "Put the index of mp4 outputs at the front for streaming over HTTP (does nothing for other containers)")
+	rootCmd.PersistentFlags().String("hdr", "passthrough", "How to handle HDR video (passthrough, off), passthrough carries the HDR10 metadata into libx265")
+	rootCmd.PersistentFlags().Int("max-height", 0, "Downscale videos taller than this (0 to disable)")
+	rootCmd.PersistentFlags().Bool("autocrop", false, "Detect and crop black bars")
+	rootCmd.PersistentFlags().String("deinterlace", "none", "Deinterlace video (auto, yadif, bwdif, none), auto runs idet on a sample and uses bwdif on interlaced files")
+	rootCmd.PersistentFlags().String("pix-fmt", "auto", "Pixel format of the output, e.g. yuv420p or yuv420p10le, auto keeps the format or at least the bit depth of the source")
+	rootCmd.PersistentFlags().Float64("target-size-mb", 0, "Encode with a bitrate that results in this output size instead of crf (0 to disable)")
+	rootCmd.PersistentFlags().String("hwaccel", "none", "Hardware acceleration to use (none, nvenc, qsv, vaapi)")
+	rootCmd.PersistentFlags().String("hwaccel-device", "/dev/dri/renderD128", "Device used for vaapi hardware acceleration")
+	rootCmd.PersistentFlags().Bool("summary-only", false, "Only send a single notification after all files are processed")
+	rootCmd.PersistentFlags().StringSlice("notify-on", []string{"error", "keep-original", "replaced"}, "Results that send end notifications (error, keep-original, replaced)")
+	rootCmd.PersistentFlags().Bool("notify-thumbnail", false, "Attach a screenshot of the transcoded file to Telegram and Discord notifications")
 
 	rootCmd.PersistentFlags().String("tg-bot-key", "", "Telegram Bot API Key")
 	rootCmd.PersistentFlags().Int64("tg-chat-id", 0, "Telegram Bot Chat ID")
 
+	rootCmd.PersistentFlags().String("discord-webhook", "", "Discord Webhook URL")
+	rootCmd.PersistentFlags().String("slack-webhook", "", "Slack Webhook URL")
+
+	rootCmd.PersistentFlags().String("ntfy-url", "https://ntfy.sh", "ntfy Server URL")
+	rootCmd.PersistentFlags().String("ntfy-topic", "", "ntfy Topic")
+
+	rootCmd.PersistentFlags().String("smtp-host", "", "SMTP Server Host")
+	rootCmd.PersistentFlags().Int("smtp-port", 587, "SMTP Server Port")
+	rootCmd.PersistentFlags().String("smtp-user", "", "SMTP Username")
+	rootCmd.PersistentFlags().String("smtp-pass", "", "SMTP Password")
+	rootCmd.PersistentFlags().String("smtp-from", "", "SMTP Sender Address (defaults to smtp-user)")
+	rootCmd.PersistentFlags().StringSlice("smtp-to", []string{}, "SMTP Recipient Addresses")
+	rootCmd.PersistentFlags().String("smtp-tls", "starttls", "SMTP encryption (none, starttls, tls)")
+	rootCmd.PersistentFlags().Bool("smtp-html", false, "Send HTML instead of plain-text emails")
+
+	rootCmd.PersistentFlags().String("gotify-url", "", "Gotify Server URL")
+	rootCmd.PersistentFlags().String("gotify-token", "", "Gotify Application Token")
+
+	rootCmd.PersistentFlags().String("pushover-token", "", "Pushover Application Token")
+	rootCmd.PersistentFlags().String("pushover-user", "", "Pushover User Key")
+
+	rootCmd.PersistentFlags().String("matrix-homeserver", "", "Matrix Homeserver URL")
+	rootCmd.PersistentFlags().String("matrix-token", "", "Matrix Access Token")
+	rootCmd.PersistentFlags().String("matrix-room", "", "Matrix Room ID")
+
+	rootCmd.PersistentFlags().String("webhook-url", "", "Webhook URL receiving a JSON POST per file")
+	rootCmd.PersistentFlags().String("webhook-template", "", "Go template rendering the webhook body with .File, .Result, .OldSize, .NewSize, .Savings, .Reason and .Duration (media length in seconds)")
+
+	_ = viper.BindPFlag("config", rootCmd.PersistentFlags().Lookup("config"))
+	_ = viper.BindPFlag("profile", rootCmd.PersistentFlags().Lookup("profile"))
+	_ = viper.BindPFlag("ffmpeg-path", rootCmd.PersistentFlags().Lookup("ffmpeg-path"))
+	_ = viper.BindPFlag("ffprobe-path", rootCmd.PersistentFlags().Lookup("ffprobe-path"))
 	_ = viper.BindPFlag("flags", rootCmd.PersistentFlags().Lookup("flags"))
+	_ = viper.BindPFlag("extra-flags", rootCmd.PersistentFlags().Lookup("extra-flags"))
+	_ = viper.BindPFlag("from-file", rootCmd.PersistentFlags().Lookup("from-file"))
+	_ = viper.BindPFlag("fail-on-empty", rootCmd.PersistentFlags().Lookup("fail-on-empty"))
+	_ = viper.BindPFlag("exclude", rootCmd.PersistentFlags().Lookup("exclude"))
+	_ = viper.BindPFlag("min-size", rootCmd.PersistentFlags().Lookup("min-size"))
+	_ = viper.BindPFlag("max-size", rootCmd.PersistentFlags().Lookup("max-size"))
 	_ = viper.BindPFlag("extensions", rootCmd.PersistentFlags().Lookup("extensions"))
 	_ = viper.BindPFlag("interval", rootCmd.PersistentFlags().Lookup("interval"))
+	_ = viper.BindPFlag("progress-bar", rootCmd.PersistentFlags().Lookup("progress-bar"))
 	_ = viper.BindPFlag("stderr", rootCmd.PersistentFlags().Lookup("stderr"))
+	_ = viper.BindPFlag("ffmpeg-loglevel", rootCmd.PersistentFlags().Lookup("ffmpeg-loglevel"))
 	_ = viper.BindPFlag("keep-old", rootCmd.PersistentFlags().Lookup("keep-old"))
 	_ = viper.BindPFlag("early-exit", rootCmd.PersistentFlags().Lookup("early-exit"))
 	_ = viper.BindPFlag("nice", rootCmd.PersistentFlags().Lookup("nice"))
+	_ = viper.BindPFlag("nice-level", rootCmd.PersistentFlags().Lookup("nice-level"))
+	_ = viper.BindPFlag("ionice-class", rootCmd.PersistentFlags().Lookup("ionice-class"))
+	_ = viper.BindPFlag("recursive", rootCmd.PersistentFlags().Lookup("recursive"))
+	_ = viper.BindPFlag("temp-dir", rootCmd.PersistentFlags().Lookup("temp-dir"))
+	_ = viper.BindPFlag("output-dir", rootCmd.PersistentFlags().Lookup("output-dir"))
+	_ = viper.BindPFlag("interactive", rootCmd.PersistentFlags().Lookup("interactive"))
+	_ = viper.BindPFlag("trash-dir", rootCmd.PersistentFlags().Lookup("trash-dir"))
+	_ = viper.BindPFlag("trash-days", rootCmd.PersistentFlags().Lookup("trash-days"))
+	_ = viper.BindPFlag("marker-mode", rootCmd.PersistentFlags().Lookup("marker-mode"))
+	_ = viper.BindPFlag("no-marker", rootCmd.PersistentFlags().Lookup("no-marker"))
+	_ = viper.BindPFlag("force", rootCmd.PersistentFlags().Lookup("force"))
+	_ = viper.BindPFlag("db", rootCmd.PersistentFlags().Lookup("db"))
+	_ = viper.BindPFlag("keep-source", rootCmd.PersistentFlags().Lookup("keep-source"))
+	_ = viper.BindPFlag("keep-source-suffix", rootCmd.PersistentFlags().Lookup("keep-source-suffix"))
+	_ = viper.BindPFlag("min-savings-percent", rootCmd.PersistentFlags().Lookup("min-savings-percent"))
+	_ = viper.BindPFlag("duration-tolerance", rootCmd.PersistentFlags().Lookup("duration-tolerance"))
+	_ = viper.BindPFlag("skip-below-bitrate", rootCmd.PersistentFlags().Lookup("skip-below-bitrate"))
+	_ = viper.BindPFlag("min-vmaf", rootCmd.PersistentFlags().Lookup("min-vmaf"))
+	_ = viper.BindPFlag("preserve-ownership", rootCmd.PersistentFlags().Lookup("preserve-ownership"))
+	_ = viper.BindPFlag("keep-failed", rootCmd.PersistentFlags().Lookup("keep-failed"))
+	_ = viper.BindPFlag("retries", rootCmd.PersistentFlags().Lookup("retries"))
+	_ = viper.BindPFlag("timeout", rootCmd.PersistentFlags().Lookup("timeout"))
+	_ = viper.BindPFlag("state-file", rootCmd.PersistentFlags().Lookup("state-file"))
+	_ = viper.BindPFlag("metrics-addr", rootCmd.PersistentFlags().Lookup("metrics-addr"))
+	_ = viper.BindPFlag("json-results", rootCmd.PersistentFlags().Lookup("json-results"))
+	_ = viper.BindPFlag("estimate", rootCmd.PersistentFlags().Lookup("estimate"))
+	_ = viper.BindPFlag("estimate-only", rootCmd.PersistentFlags().Lookup("estimate-only"))
+	_ = viper.BindPFlag("estimate-seconds", rootCmd.PersistentFlags().Lookup("estimate-seconds"))
+	_ = viper.BindPFlag("dry-run", rootCmd.PersistentFlags().Lookup("dry-run"))
+	_ = viper.BindPFlag("watch", rootCmd.PersistentFlags().Lookup("watch"))
+	_ = viper.BindPFlag("watch-settle", rootCmd.PersistentFlags().Lookup("watch-settle"))
+	_ = viper.BindPFlag("max-loadavg", rootCmd.PersistentFlags().Lookup("max-loadavg"))
+	_ = viper.BindPFlag("min-free-space-mb", rootCmd.PersistentFlags().Lookup("min-free-space-mb"))
+	_ = viper.BindPFlag("min-free-space-factor", rootCmd.PersistentFlags().Lookup("min-free-space-factor"))
+	_ = viper.BindPFlag("schedule", rootCmd.PersistentFlags().Lookup("schedule"))
+	_ = viper.BindPFlag("threads", rootCmd.PersistentFlags().Lookup("threads"))
+	_ = viper.BindPFlag("order", rootCmd.PersistentFlags().Lookup("order"))
+	_ = viper.BindPFlag("concurrency", rootCmd.PersistentFlags().Lookup("concurrency"))
+	_ = viper.BindPFlag("lock-dir", rootCmd.PersistentFlags().Lookup("lock-dir"))
+	_ = viper.BindPFlag("container", rootCmd.PersistentFlags().Lookup("container"))
+	_ = viper.BindPFlag("audio-mode", rootCmd.PersistentFlags().Lookup("audio-mode"))
+	_ = viper.BindPFlag("audio-bitrate", rootCmd.PersistentFlags().Lookup("audio-bitrate"))
+	_ = viper.BindPFlag("keep-audio-langs", rootCmd.PersistentFlags().Lookup("keep-audio-langs"))
+	_ = viper.BindPFlag("drop-untagged", rootCmd.PersistentFlags().Lookup("drop-untagged"))
+	_ = viper.BindPFlag("loudnorm", rootCmd.PersistentFlags().Lookup("loudnorm"))
+	_ = viper.BindPFlag("loudnorm-target", rootCmd.PersistentFlags().Lookup("loudnorm-target"))
+	_ = viper.BindPFlag("subtitles", rootCmd.PersistentFlags().Lookup("subtitles"))
+	_ = viper.BindPFlag("keep-subtitle-langs", rootCmd.PersistentFlags().Lookup("keep-subtitle-langs"))
+	_ = viper.BindPFlag("keep-forced-subs", rootCmd.PersistentFlags().Lookup("keep-forced-subs"))
+	_ = viper.BindPFlag("copy-chapters", rootCmd.PersistentFlags().Lookup("copy-chapters"))
+	_ = viper.BindPFlag("auto-chapters", rootCmd.PersistentFlags().Lookup("auto-chapters"))
+	_ = viper.BindPFlag("auto-chapters-silence", rootCmd.PersistentFlags().Lookup("auto-chapters-silence"))
+	_ = viper.BindPFlag("copy-metadata", rootCmd.PersistentFlags().Lookup("copy-metadata"))
+	_ = viper.BindPFlag("faststart", rootCmd.PersistentFlags().Lookup("faststart"))
+	_ = viper.BindPFlag("hdr", rootCmd.PersistentFlags().Lookup("hdr"))
+	_ = viper.BindPFlag("max-height", rootCmd.PersistentFlags().Lookup("max-height"))
+	_ = viper.BindPFlag("autocrop", rootCmd.PersistentFlags().Lookup("autocrop"))
+	_ = viper.BindPFlag("deinterlace", rootCmd.PersistentFlags().Lookup("deinterlace"))
+	_ = viper.BindPFlag("pix-fmt", rootCmd.PersistentFlags().Lookup("pix-fmt"))
+	_ = viper.BindPFlag("target-size-mb", rootCmd.PersistentFlags().Lookup("target-size-mb"))
+	_ = viper.BindPFlag("hwaccel", rootCmd.PersistentFlags().Lookup("hwaccel"))
+	_ = viper.BindPFlag("hwaccel-device", rootCmd.PersistentFlags().Lookup("hwaccel-device"))
+	_ = viper.BindPFlag("summary-only", rootCmd.PersistentFlags().Lookup("summary-only"))
+	_ = viper.BindPFlag("notify-on", rootCmd.PersistentFlags().Lookup("notify-on"))
+	_ = viper.BindPFlag("notify-thumbnail", rootCmd.PersistentFlags().Lookup("notify-thumbnail"))
 
 	_ = viper.BindPFlag("tg-bot-key", rootCmd.PersistentFlags().Lookup("tg-bot-key"))
 	_ = viper.BindPFlag("tg-chat-id", rootCmd.PersistentFlags().Lookup("tg-chat-id"))
-}
 
-func shouldTranscode(fileName string) bool {
-	if terminated {
-		return false
-	}
+	_ = viper.BindPFlag("discord-webhook", rootCmd.PersistentFlags().Lookup("discord-webhook"))
+	_ = viper.BindPFlag("slack-webhook", rootCmd.PersistentFlags().Lookup("slack-webhook"))
 
-	ext := filepath.Ext(fileName)
+	_ = viper.BindPFlag("ntfy-url", rootCmd.PersistentFlags().Lookup("ntfy-url"))
+	_ = viper.BindPFlag("ntfy-topic", rootCmd.PersistentFlags().Lookup("ntfy-topic"))
 
-	valid := false
-	for _, extension := range viper.GetStringSlice("extensions") {
-		if ext == extension {
-			valid = true
-			break
-		}
-	}
+	_ = viper.BindPFlag("smtp-host", rootCmd.PersistentFlags().Lookup("smtp-host"))
+	_ = viper.BindPFlag("smtp-port", rootCmd.PersistentFlags().Lookup("smtp-port"))
+	_ = viper.BindPFlag("smtp-user", rootCmd.PersistentFlags().Lookup("smtp-user"))
+	_ = viper.BindPFlag("smtp-pass", rootCmd.PersistentFlags().Lookup("smtp-pass"))
+	_ = viper.BindPFlag("smtp-from", rootCmd.PersistentFlags().Lookup("smtp-from"))
+	_ = viper.BindPFlag("smtp-to", rootCmd.PersistentFlags().Lookup("smtp-to"))
+	_ = viper.BindPFlag("smtp-tls", rootCmd.PersistentFlags().Lookup("smtp-tls"))
+	_ = viper.BindPFlag("smtp-html", rootCmd.PersistentFlags().Lookup("smtp-html"))
 
-	if !valid {
-		return false
-	}
+	_ = viper.BindPFlag("gotify-url", rootCmd.PersistentFlags().Lookup("gotify-url"))
+	_ = viper.BindPFlag("gotify-token", rootCmd.PersistentFlags().Lookup("gotify-token"))
 
-	lastDot := strings.LastIndex(fileName, ".")
-	extCorrectedOriginal := fileName[:lastDot] + outputFileExtension
-	processedFileName := filepath.Dir(extCorrectedOriginal) + "/." + filepath.Base(extCorrectedOriginal) + ".processed"
+	_ = viper.BindPFlag("pushover-token", rootCmd.PersistentFlags().Lookup("pushover-token"))
+	_ = viper.BindPFlag("pushover-user", rootCmd.PersistentFlags().Lookup("pushover-user"))
 
-	stat, err := os.Stat(processedFileName)
+	_ = viper.BindPFlag("matrix-homeserver", rootCmd.PersistentFlags().Lookup("matrix-homeserver"))
+	_ = viper.BindPFlag("matrix-token", rootCmd.PersistentFlags().Lookup("matrix-token"))
+	_ = viper.BindPFlag("matrix-room", rootCmd.PersistentFlags().Lookup("matrix-room"))
 
-	if err != nil && !os.IsNotExist(err) {
-		log.Errorf("Error reading file %s: %s", processedFileName, err)
+	_ = viper.BindPFlag("webhook-url", rootCmd.PersistentFlags().Lookup("webhook-url"))
+	_ = viper.BindPFlag("webhook-template", rootCmd.PersistentFlags().Lookup("webhook-template"))
+}
+
+func shouldTranscode(fileName string) bool {
+	if terminated() {
 		return false
 	}
 
-	if stat == nil {
-		// File not transcoded ever
-		return true
+	if !hasTranscodeExtension(fileName) || isExcluded(fileName) || isInTrash(fileName) || !isWithinSizeLimits(fileName) {
+		return false
 	}
 
-	if stat.Size() == 0 {
-		// File processed using old transcoder, update meta file and skip
-		log.Warningf("Updating processed file with file size from old transcoder: %s", fileName)
-		updateProcessedFile(fileName, processedFileName)
-		return false
+	if viper.GetBool("force") {
+		return true
 	}
 
-	processedData, err := ioutil.ReadFile(processedFileName)
+	extCorrectedOriginal := transcodedFileName(fileName)
+	processedFileName := processedFilePath(extCorrectedOriginal)
+
+	processedData, found, err := readMarker(fileName, processedFileName)
 
 	if err != nil {
-		log.Errorf("Error reading file %s: %s", processedFileName, err)
+		log.Errorf("Error reading marker of %s: %s", fileName, err)
 		return false
 	}
 
+	if !found {
+		// File not transcoded ever
+		return true
+	}
+
 	if len(processedData) == 0 {
 		// File processed using old transcoder, update meta file and skip
 		log.Warningf("Updating processed file with file size from old transcoder: %s", fileName)
-		updateProcessedFile(fileName, processedFileName)
+		updateProcessedFile(fileName, processedFileName, models.ProcessedMarker{})
 		return false
 	}
 
-	parsed, err := strconv.ParseInt(string(processedData), 10, 64)
+	marker, err := parseProcessedFile(processedData)
 
 	if err != nil {
-		log.Errorf("Error parsing %s: %s", string(processedData), err)
+		log.Errorf("Error parsing marker of %s: %s", fileName, err)
 		return false
 	}
 
@@ -299,19 +626,62 @@ func shouldTranscode(fileName string) bool {
 		return false
 	}
 
-	if parsed == originalStat.Size() {
+	if marker.Size == originalStat.Size() && !changedSince(fileName, originalStat, marker) {
 		return false
 	}
 
-	if !deleteProcessedFile(processedFileName) {
+	if !deleteProcessedFile(fileName, processedFileName) {
 		return false
 	}
 
 	return true
 }
 
-func updateProcessedFile(fileName string, processedFileName string) {
-	if !deleteProcessedFile(processedFileName) {
+func hasTranscodeExtension(fileName string) bool {
+	ext := filepath.Ext(fileName)
+
+	for _, extension := range viper.GetStringSlice("extensions") {
+		if ext == extension {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Parses a marker, markers of older versions only contain the size
+func parseProcessedFile(processedData []byte) (models.ProcessedMarker, error) {
+	var marker models.ProcessedMarker
+
+	if len(processedData) > 0 && processedData[0] != '{' {
+		size, err := strconv.ParseInt(string(processedData), 10, 64)
+		marker.Size = size
+		return marker, err
+	}
+
+	err := json.Unmarshal(processedData, &marker)
+
+	return marker, err
+}
+
+// Whether the file was modified since the marker was written, markers of older versions never are
+func changedSince(fileName string, stat os.FileInfo, marker models.ProcessedMarker) bool {
+	if marker.ModTime.IsZero() || marker.ModTime.Equal(stat.ModTime()) {
+		return false
+	}
+
+	if marker.Hash == "" {
+		return true
+	}
+
+	// Only touched, e.g. by copying the library with a tool that does not keep modification times
+	hash, err := quickHash(fileName)
+
+	return err != nil || hash != marker.Hash
+}
+
+func updateProcessedFile(fileName string, processedFileName string, marker models.ProcessedMarker) {
+	if viper.GetBool("dry-run") {
 		return
 	}
 
@@ -322,19 +692,39 @@ func updateProcessedFile(fileName string, processedFileName string) {
 		return
 	}
 
-	err = ioutil.WriteFile(processedFileName, []byte(strconv.FormatInt(originalStat.Size(), 10)), 0644)
+	marker.Version = models.ProcessedMarkerVersion
+	marker.Size = originalStat.Size()
+	marker.Timestamp = time.Now()
+	marker.ModTime = originalStat.ModTime()
+
+	// Hashing reads two megabytes of every file, only worth it for a database of the whole library
+	if markerMode() == "db" {
+		marker.Hash, err = quickHash(fileName)
+
+		if err != nil {
+			log.Warningf("Error hashing file %s: %s", fileName, err)
+		}
+	}
+
+	markerData, err := json.Marshal(marker)
 
 	if err != nil {
-		log.Errorf("Error writing file %s: %s", processedFileName, err)
+		log.Errorf("Error serializing marker of %s: %s", fileName, err)
 		return
 	}
+
+	if err := writeMarker(fileName, processedFileName, markerData); err != nil {
+		log.Errorf("Error writing marker of %s: %s", fileName, err)
+	}
 }
 
-func deleteProcessedFile(processedFileName string) bool {
-	err := os.Remove(processedFileName)
+func deleteProcessedFile(fileName string, processedFileName string) bool {
+	if viper.GetBool("dry-run") {
+		return true
+	}
 
-	if err != nil && !os.IsNotExist(err) {
-		log.Errorf("Error deleting file %s: %s", processedFileName, err)
+	if err := deleteMarker(fileName, processedFileName); err != nil {
+		log.Errorf("Error deleting marker of %s: %s", fileName, err)
 		return false
 	}
 
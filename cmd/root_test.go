@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"context"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Once the root context is cancelled, by a signal or the prompt quitting, no further file gets transcoded
+func TestShouldTranscodeAfterTermination(t *testing.T) {
+	directory, err := ioutil.TempDir("", "transcoder")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldContext, oldCancel := rootContext, cancelRoot
+	rootContext, cancelRoot = context.WithCancel(context.Background())
+
+	viper.Set("extensions", []string{".mkv"})
+
+	t.Cleanup(func() {
+		rootContext, cancelRoot = oldContext, oldCancel
+		viper.Set("extensions", nil)
+		os.RemoveAll(directory)
+	})
+
+	fileName := filepath.Join(directory, "movie.mkv")
+
+	if err := ioutil.WriteFile(fileName, make([]byte, 1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if !shouldTranscode(fileName) {
+		t.Fatal("expected the file to be transcoded before the termination")
+	}
+
+	done := make(chan bool)
+
+	// Cancelled from another goroutine, as the signal handler does
+	go func() {
+		cancelRoot()
+		close(done)
+	}()
+
+	<-done
+
+	if !terminated() {
+		t.Error("not terminated after the root context got cancelled")
+	}
+
+	if shouldTranscode(fileName) {
+		t.Error("file transcoded after the termination")
+	}
+}
@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"encoding/json"
+	"github.com/Vilsol/transcoder-go/models"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	stateQueued     = "queued"
+	stateInProgress = "in-progress"
+	stateCompleted  = "completed"
+	stateFailed     = "failed"
+)
+
+type fileState struct {
+	Status     string                 `json:"status"`
+	Result     models.Result          `json:"result,omitempty"`
+	LastReport *models.ProgressReport `json:"last_report,omitempty"`
+	Updated    time.Time              `json:"updated"`
+}
+
+type queueState struct {
+	Files map[string]*fileState `json:"files"`
+}
+
+var state = queueState{Files: make(map[string]*fileState)}
+var stateLock sync.Mutex
+
+func loadState() {
+	stateFile := viper.GetString("state-file")
+
+	if stateFile == "" {
+		return
+	}
+
+	data, err := ioutil.ReadFile(stateFile)
+
+	if os.IsNotExist(err) {
+		return
+	}
+
+	if err != nil {
+		log.Fatalf("Error reading state file %s: %s", stateFile, err)
+	}
+
+	err = json.Unmarshal(data, &state)
+
+	if err != nil {
+		log.Fatalf("Error parsing state file %s: %s", stateFile, err)
+	}
+
+	if state.Files == nil {
+		state.Files = make(map[string]*fileState)
+	}
+}
+
+// Whether the file was replaced or kept in an earlier run, failed files get retried.
+// Older state files recorded errors as completed, so the result is checked as well.
+func isCompleted(fileName string) bool {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+
+	file, ok := state.Files[stateKey(fileName)]
+	return ok && file.Status == stateCompleted && file.Result != models.ResultError
+}
+
+func markQueued(fileList []string) {
+	if viper.GetString("state-file") == "" || viper.GetBool("dry-run") {
+		return
+	}
+
+	stateLock.Lock()
+	defer stateLock.Unlock()
+
+	for _, fileName := range fileList {
+		if file, ok := state.Files[stateKey(fileName)]; ok && file.Status != stateQueued {
+			// Keep completed and failed files and the last report of interrupted ones
+			continue
+		}
+
+		state.Files[stateKey(fileName)] = &fileState{
+			Status:  stateQueued,
+			Updated: time.Now(),
+		}
+	}
+
+	saveState()
+}
+
+func updateState(fileName string, status string, result models.Result, report *models.ProgressReport) {
+	if viper.GetString("state-file") == "" || viper.GetBool("dry-run") {
+		return
+	}
+
+	stateLock.Lock()
+	defer stateLock.Unlock()
+
+	state.Files[stateKey(fileName)] = &fileState{
+		Status:     status,
+		Result:     result,
+		LastReport: report,
+		Updated:    time.Now(),
+	}
+
+	saveState()
+}
+
+// Writes the state to a temporary file first so an interruption never leaves a partial state file
+func saveState() {
+	stateFile := viper.GetString("state-file")
+
+	data, err := json.MarshalIndent(state, "", "  ")
+
+	if err != nil {
+		log.Errorf("Error serializing state: %s", err)
+		return
+	}
+
+	tempFile := stateFile + ".tmp"
+
+	err = ioutil.WriteFile(tempFile, data, 0644)
+
+	if err != nil {
+		log.Errorf("Error writing file %s: %s", tempFile, err)
+		return
+	}
+
+	err = os.Rename(tempFile, stateFile)
+
+	if err != nil {
+		log.Errorf("Error renaming file %s to %s: %s", tempFile, stateFile, err)
+	}
+}
+
+func stateKey(fileName string) string {
+	absolute, err := filepath.Abs(fileName)
+
+	if err != nil {
+		return fileName
+	}
+
+	return absolute
+}
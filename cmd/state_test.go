@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"github.com/Vilsol/transcoder-go/models"
+	"github.com/Vilsol/transcoder-go/transcoder"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func useStateFile(t *testing.T, content string) string {
+	directory, err := ioutil.TempDir("", "transcoder")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stateFile := filepath.Join(directory, "state.json")
+
+	if content != "" {
+		if err := ioutil.WriteFile(stateFile, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	viper.Set("state-file", stateFile)
+	state = queueState{Files: make(map[string]*fileState)}
+
+	t.Cleanup(func() {
+		viper.Set("state-file", nil)
+		state = queueState{Files: make(map[string]*fileState)}
+		os.RemoveAll(directory)
+	})
+
+	loadState()
+
+	return stateFile
+}
+
+func TestRecordResultState(t *testing.T) {
+	tests := []struct {
+		name          string
+		result        models.Result
+		err           error
+		wantStatus    string
+		wantCompleted bool
+	}{
+		{"replaced", models.ResultReplaced, nil, stateCompleted, true},
+		{"kept original", models.ResultKeepOriginal, nil, stateCompleted, true},
+		{"error", models.ResultError, os.ErrInvalid, stateFailed, false},
+		{"interrupted", models.ResultError, transcoder.ErrInterrupted, stateInProgress, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useStateFile(t, "")
+
+			fileName := "/videos/video.mkv"
+			markQueued([]string{fileName})
+			updateState(fileName, stateInProgress, "", nil)
+
+			recordResult(&models.BatchSummary{}, &models.FileResult{File: fileName, Result: test.result}, test.err)
+
+			// Read back like the next run would
+			state = queueState{Files: make(map[string]*fileState)}
+			loadState()
+
+			if status := state.Files[fileName].Status; status != test.wantStatus {
+				t.Errorf("status = %s, want %s", status, test.wantStatus)
+			}
+
+			if completed := isCompleted(fileName); completed != test.wantCompleted {
+				t.Errorf("isCompleted = %t, want %t", completed, test.wantCompleted)
+			}
+
+			markQueued([]string{fileName})
+
+			if status := state.Files[fileName].Status; status != test.wantStatus {
+				t.Errorf("status after queueing again = %s, want %s", status, test.wantStatus)
+			}
+		})
+	}
+}
+
+func TestIsCompletedRetriesOldErrors(t *testing.T) {
+	useStateFile(t, `{"files": {
+		"/videos/error.mkv": {"status": "completed", "result": "Error"},
+		"/videos/replaced.mkv": {"status": "completed", "result": "Replaced with new"}
+	}}`)
+
+	if isCompleted("/videos/error.mkv") {
+		t.Error("error recorded as completed counts as completed")
+	}
+
+	if !isCompleted("/videos/replaced.mkv") {
+		t.Error("replaced file does not count as completed")
+	}
+}
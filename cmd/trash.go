@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"github.com/Vilsol/transcoder-go/utils"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Originals are trashed into a directory per day, so purging only has to look at the directory names
+const trashDayLayout = "2006-01-02"
+
+// Moves the original into the trash directory, or deletes it without one
+func removeOriginal(fileName string) error {
+	trashDir := viper.GetString("trash-dir")
+
+	if trashDir == "" {
+		return os.Remove(fileName)
+	}
+
+	trashFileName := mirrorPath(filepath.Join(trashDir, time.Now().Format(trashDayLayout)), fileName)
+
+	// A file trashed twice on the same day must not replace the earlier copy
+	if _, err := os.Stat(trashFileName); err == nil {
+		trashFileName += "." + strconv.FormatInt(time.Now().Unix(), 10)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(trashFileName), 0755); err != nil {
+		return err
+	}
+
+	if err := utils.MoveFile(fileName, trashFileName); err != nil {
+		return err
+	}
+
+	log.Infof("Trashed %s: %s", fileName, trashFileName)
+
+	return nil
+}
+
+// Deletes the days in the trash directory older than trash-days
+func purgeTrash() {
+	trashDir := viper.GetString("trash-dir")
+	days := viper.GetInt("trash-days")
+
+	if trashDir == "" || days <= 0 {
+		return
+	}
+
+	entries, err := ioutil.ReadDir(trashDir)
+
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Errorf("Error reading directory %s: %s", trashDir, err)
+		}
+
+		return
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -days)
+
+	for _, entry := range entries {
+		day, err := time.ParseInLocation(trashDayLayout, entry.Name(), time.Local)
+
+		// Anything else in the directory was not put there by us
+		if err != nil || !entry.IsDir() || !day.Before(cutoff) {
+			continue
+		}
+
+		path := filepath.Join(trashDir, entry.Name())
+
+		if err := os.RemoveAll(path); err != nil {
+			log.Errorf("Error deleting directory %s: %s", path, err)
+			continue
+		}
+
+		log.Infof("Purged trash: %s", path)
+	}
+}
+
+// Whether the file is inside the trash directory, trashed originals must not be transcoded again
+func isInTrash(fileName string) bool {
+	trashDir := viper.GetString("trash-dir")
+
+	if trashDir == "" {
+		return false
+	}
+
+	root, err := filepath.Abs(trashDir)
+
+	if err != nil {
+		return false
+	}
+
+	absolute, err := filepath.Abs(fileName)
+
+	if err != nil {
+		return false
+	}
+
+	return strings.HasPrefix(absolute, root+string(filepath.Separator))
+}
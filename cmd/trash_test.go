@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"github.com/Vilsol/transcoder-go/transcoder/transcodertest"
+	"github.com/spf13/viper"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRemoveOriginal(t *testing.T) {
+	directory := transcodertest.TempDir(t)
+	trashDir := transcodertest.TempDir(t)
+	fileName := filepath.Join(directory, "video.mkv")
+
+	viper.Set("trash-dir", trashDir)
+	defer viper.Set("trash-dir", nil)
+
+	trashFileName := mirrorPath(filepath.Join(trashDir, time.Now().Format(trashDayLayout)), fileName)
+
+	transcodertest.WriteFile(t, fileName, "first")
+
+	if err := removeOriginal(fileName); err != nil {
+		t.Fatal(err)
+	}
+
+	transcodertest.AssertMissing(t, fileName)
+	transcodertest.AssertContent(t, trashFileName, "first")
+
+	// Trashed again on the same day, the first copy must stay
+	transcodertest.WriteFile(t, fileName, "second")
+
+	if err := removeOriginal(fileName); err != nil {
+		t.Fatal(err)
+	}
+
+	transcodertest.AssertMissing(t, fileName)
+	transcodertest.AssertContent(t, trashFileName, "first")
+
+	matches, err := filepath.Glob(trashFileName + ".*")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(matches) != 1 {
+		t.Fatalf("second copy not found next to %s: %q", trashFileName, matches)
+	}
+
+	transcodertest.AssertContent(t, matches[0], "second")
+}
+
+func TestRemoveOriginalFailureKeepsOriginal(t *testing.T) {
+	directory := transcodertest.TempDir(t)
+	fileName := filepath.Join(directory, "video.mkv")
+
+	// A file where the trash directory should be makes trashing fail
+	trashDir := filepath.Join(directory, "trash")
+	transcodertest.WriteFile(t, trashDir, "")
+
+	viper.Set("trash-dir", trashDir)
+	defer viper.Set("trash-dir", nil)
+
+	transcodertest.WriteFile(t, fileName, "original")
+
+	if err := removeOriginal(fileName); err == nil {
+		t.Fatal("removeOriginal did not fail")
+	}
+
+	transcodertest.AssertContent(t, fileName, "original")
+}
@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type pendingFile struct {
+	size    int64
+	changed time.Time
+}
+
+// Watches directories and queues new files once they are fully written
+func watchDirectories(directories []string, queue chan<- string) {
+	if len(directories) == 0 {
+		log.Warning("No directories to watch")
+		return
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+
+	if err != nil {
+		log.Errorf("Error creating watcher: %s", err)
+		return
+	}
+
+	defer watcher.Close()
+
+	for _, directory := range directories {
+		addWatch(watcher, directory)
+	}
+
+	pending := make(map[string]*pendingFile)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-rootContext.Done():
+			return
+		case event := <-watcher.Events:
+			if event.Op&(fsnotify.Create|fsnotify.Write) == 0 {
+				continue
+			}
+
+			stat, err := os.Stat(event.Name)
+
+			if err != nil {
+				continue
+			}
+
+			if stat.IsDir() {
+				if event.Op&fsnotify.Create != 0 && viper.GetBool("recursive") {
+					addWatch(watcher, event.Name)
+				}
+				continue
+			}
+
+			if hasTranscodeExtension(event.Name) {
+				pending[event.Name] = &pendingFile{
+					size:    stat.Size(),
+					changed: time.Now(),
+				}
+			}
+		case err := <-watcher.Errors:
+			log.Errorf("Error watching files: %s", err)
+		case <-ticker.C:
+			for fileName, file := range pending {
+				stat, err := os.Stat(fileName)
+
+				if err != nil {
+					delete(pending, fileName)
+					continue
+				}
+
+				if stat.Size() != file.size {
+					file.size = stat.Size()
+					file.changed = time.Now()
+					continue
+				}
+
+				if time.Since(file.changed) < viper.GetDuration("watch-settle") {
+					continue
+				}
+
+				delete(pending, fileName)
+
+				log.Debugf("New file: %s", fileName)
+
+				if shouldTranscode(fileName) {
+					addToBatch(1)
+				}
+
+				select {
+				case queue <- fileName:
+				case <-rootContext.Done():
+					return
+				}
+			}
+		}
+	}
+}
+
+func addWatch(watcher *fsnotify.Watcher, directory string) {
+	directories := []string{directory}
+
+	if viper.GetBool("recursive") {
+		directories = make([]string, 0)
+		_ = filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
+			if err == nil && info.IsDir() {
+				directories = append(directories, path)
+			}
+			return nil
+		})
+	}
+
+	for _, path := range directories {
+		err := watcher.Add(path)
+
+		if err != nil {
+			log.Errorf("Error watching directory %s: %s", path, err)
+			continue
+		}
+
+		log.Infof("Watching: %s", path)
+	}
+}
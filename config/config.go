@@ -2,15 +2,106 @@ package config
 
 import (
 	log "github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	"os"
+	"path/filepath"
+	"strings"
 )
 
-func InitializeConfig() {
-	viper.SetConfigName("config")
-	viper.AddConfigPath(".")
+// Keys in the config file that do not correspond to a flag
+var knownKeys = map[string]bool{
+	"profiles": true,
+}
+
+func InitializeConfig(flags *pflag.FlagSet) {
+	rootFlags = flags
+
 	viper.AutomaticEnv()
 
-	_ = viper.ReadInConfig()
+	if configFile := viper.GetString("config"); configFile != "" {
+		viper.SetConfigFile(configFile)
+
+		if err := viper.ReadInConfig(); err != nil {
+			log.Fatalf("Error reading config %s: %s", configFile, err)
+		}
+	} else {
+		viper.SetConfigName("transcoder")
+		viper.AddConfigPath(".")
+
+		if home, err := os.UserHomeDir(); err == nil {
+			viper.AddConfigPath(filepath.Join(home, ".config", "transcoder"))
+		}
+
+		err := viper.ReadInConfig()
+
+		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
+			// Fall back to the config name used by older versions
+			viper.SetConfigName("config")
+			err = viper.ReadInConfig()
+		}
+
+		if _, ok := err.(viper.ConfigFileNotFoundError); !ok && err != nil {
+			log.Fatalf("Error reading config: %s", err)
+		}
+	}
+
+	if viper.ConfigFileUsed() != "" {
+		validateConfig(flags)
+	}
+
+	applyProfile(flags)
+
+	log.Infof("Config initialized: %s", viper.ConfigFileUsed())
+}
+
+// Warns about keys in the config file that would otherwise silently do nothing
+func validateConfig(flags *pflag.FlagSet) {
+	fileConfig := viper.New()
+	fileConfig.SetConfigFile(viper.ConfigFileUsed())
+
+	if err := fileConfig.ReadInConfig(); err != nil {
+		return
+	}
+
+	for _, key := range fileConfig.AllKeys() {
+		parts := strings.Split(key, ".")
+
+		name := parts[0]
+		if name == "profiles" && len(parts) > 2 {
+			// Profiles contain the same keys as the top level
+			name = parts[2]
+		}
+
+		if knownKeys[name] || flags.Lookup(name) != nil {
+			continue
+		}
+
+		log.Warningf("Unknown config key: %s", key)
+	}
+}
+
+// Merges the selected profile over the config, flags passed on the command line still take precedence
+func applyProfile(flags *pflag.FlagSet) {
+	name := viper.GetString("profile")
+
+	if name == "" {
+		return
+	}
+
+	profile := viper.GetStringMap("profiles." + name)
+
+	if len(profile) == 0 {
+		log.Fatalf("Profile does not exist: %s", name)
+	}
+
+	for key, value := range profile {
+		if flag := flags.Lookup(key); flag != nil && flag.Changed {
+			continue
+		}
+
+		viper.Set(key, value)
+	}
 
-	log.Info("Config initialized")
+	log.Infof("Using profile: %s", name)
 }
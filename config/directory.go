@@ -0,0 +1,232 @@
+package config
+
+import (
+	"fmt"
+	"github.com/Vilsol/transcoder-go/utils"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Name of the config file that overrides settings for the files in its directory and subdirectories
+const DirectoryConfigName = ".transcoder.yaml"
+
+// Keys a directory config can override, everything else applies to the whole run
+var directoryKeys = map[string]bool{
+	"flags":                 true,
+	"extra-flags":           true,
+	"audio-mode":            true,
+	"audio-bitrate":         true,
+	"subtitles":             true,
+	"hdr":                   true,
+	"autocrop":              true,
+	"deinterlace":           true,
+	"pix-fmt":               true,
+	"max-height":            true,
+	"target-size-mb":        true,
+	"copy-chapters":         true,
+	"copy-metadata":         true,
+	"keep-old":              true,
+	"min-vmaf":              true,
+	"min-savings-percent":   true,
+	"skip-below-bitrate":    true,
+	"keep-audio-langs":      true,
+	"drop-untagged":         true,
+	"keep-subtitle-langs":   true,
+	"keep-forced-subs":      true,
+	"loudnorm":              true,
+	"auto-chapters":         true,
+	"auto-chapters-silence": true,
+}
+
+// Values accepted by the keys that select a mode, for the config and every directory config
+var modes = map[string][]string{
+	"audio-mode":  {"", "copy", "aac", "opus"},
+	"subtitles":   {"copy", "convert", "drop"},
+	"hdr":         {"passthrough", "off"},
+	"deinterlace": {"auto", "yadif", "bwdif", "none"},
+}
+
+// Checks that need more than the config package, like the encoders of the flags
+var directoryChecks []func(directoryConfig *viper.Viper) error
+
+var rootFlags *pflag.FlagSet
+
+// Parsed directory configs by directory, nil if the directory has none
+var directoryConfigs = make(map[string]*viper.Viper)
+var directoryConfigsLock sync.Mutex
+
+// Returns the config that decides the key for the file.
+//
+// Precedence, highest first: flags passed on the command line, the nearest
+// directory config setting the key, then the config file and profile.
+func configFor(fileName string, key string) *viper.Viper {
+	if rootFlags != nil {
+		if flag := rootFlags.Lookup(key); flag != nil && flag.Changed {
+			return viper.GetViper()
+		}
+	}
+
+	directory, err := filepath.Abs(filepath.Dir(fileName))
+
+	if err != nil {
+		return viper.GetViper()
+	}
+
+	for {
+		if directoryConfig := readDirectoryConfig(directory); directoryConfig != nil && directoryConfig.IsSet(key) {
+			return directoryConfig
+		}
+
+		parent := filepath.Dir(directory)
+
+		if parent == directory {
+			return viper.GetViper()
+		}
+
+		directory = parent
+	}
+}
+
+// Reads the config of the directory once, later lookups are served from the cache
+func readDirectoryConfig(directory string) *viper.Viper {
+	directoryConfigsLock.Lock()
+	defer directoryConfigsLock.Unlock()
+
+	if directoryConfig, ok := directoryConfigs[directory]; ok {
+		return directoryConfig
+	}
+
+	directoryConfigs[directory] = nil
+
+	configFile := filepath.Join(directory, DirectoryConfigName)
+
+	if _, err := os.Stat(configFile); err != nil {
+		if !os.IsNotExist(err) {
+			log.Warningf("Error reading config %s: %s", configFile, err)
+		}
+
+		return nil
+	}
+
+	directoryConfig := viper.New()
+	directoryConfig.SetConfigFile(configFile)
+
+	if err := directoryConfig.ReadInConfig(); err != nil {
+		log.Errorf("Error reading config %s: %s", configFile, err)
+		return nil
+	}
+
+	if err := checkDirectoryConfig(directoryConfig); err != nil {
+		log.Errorf("Error reading config %s: %s", configFile, err)
+		return nil
+	}
+
+	for _, key := range directoryConfig.AllKeys() {
+		if name := rootKey(key); !directoryKeys[name] {
+			log.Warningf("Config key can not be set per directory, ignoring %s in %s", key, configFile)
+		}
+	}
+
+	log.Infof("Directory config loaded: %s", configFile)
+
+	directoryConfigs[directory] = directoryConfig
+
+	return directoryConfig
+}
+
+// Fails if flags or extra-flags, including the per extension flags, can not be tokenized,
+// or a mode or anything else of the registered checks is invalid
+func checkDirectoryConfig(directoryConfig *viper.Viper) error {
+	for _, key := range directoryConfig.AllKeys() {
+		name := rootKey(key)
+
+		if _, ok := modes[name]; ok {
+			if err := CheckMode(name, directoryConfig.GetString(key)); err != nil {
+				return fmt.Errorf("invalid %s: %s", key, err)
+			}
+		}
+
+		if name != "flags" && name != "extra-flags" {
+			continue
+		}
+
+		if _, err := utils.SplitArguments(directoryConfig.GetString(key)); err != nil {
+			return fmt.Errorf("invalid %s: %s: %s", key, err, directoryConfig.GetString(key))
+		}
+	}
+
+	for _, check := range directoryChecks {
+		if err := check(directoryConfig); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Fails if the value is not one of the modes of the key
+func CheckMode(key string, value string) error {
+	valid := make([]string, 0, len(modes[key]))
+
+	for _, mode := range modes[key] {
+		if value == mode {
+			return nil
+		}
+
+		if mode != "" {
+			valid = append(valid, mode)
+		}
+	}
+
+	return fmt.Errorf("unknown mode %s, expected one of %s", value, strings.Join(valid, ", "))
+}
+
+// Registers a check every directory config has to pass before it is used
+func AddDirectoryCheck(check func(directoryConfig *viper.Viper) error) {
+	directoryChecks = append(directoryChecks, check)
+}
+
+// Returns the top level key of a nested key like flags.mkv
+func rootKey(key string) string {
+	for i := range key {
+		if key[i] == '.' {
+			return key[:i]
+		}
+	}
+
+	return key
+}
+
+// Returns the value of the key for the file, directory configs included
+func Get(fileName string, key string) interface{} {
+	return configFor(fileName, key).Get(key)
+}
+
+func GetString(fileName string, key string) string {
+	return configFor(fileName, key).GetString(key)
+}
+
+func GetBool(fileName string, key string) bool {
+	return configFor(fileName, key).GetBool(key)
+}
+
+func GetInt(fileName string, key string) int {
+	return configFor(fileName, key).GetInt(key)
+}
+
+func GetInt64(fileName string, key string) int64 {
+	return configFor(fileName, key).GetInt64(key)
+}
+
+func GetFloat64(fileName string, key string) float64 {
+	return configFor(fileName, key).GetFloat64(key)
+}
+
+func GetStringSlice(fileName string, key string) []string {
+	return configFor(fileName, key).GetStringSlice(key)
+}
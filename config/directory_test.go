@@ -0,0 +1,115 @@
+package config
+
+import (
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeDirectoryConfig(t *testing.T, content string) string {
+	directory, err := ioutil.TempDir("", "transcoder")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		os.RemoveAll(directory)
+	})
+
+	if err := ioutil.WriteFile(filepath.Join(directory, DirectoryConfigName), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return directory
+}
+
+func TestDirectoryConfigFlags(t *testing.T) {
+	viper.Set("extra-flags", "-tune film")
+	defer viper.Set("extra-flags", nil)
+
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"quoted filtergraph", "extra-flags: -vf \"scale=1280:-2, fps=30\"\n", `-vf "scale=1280:-2, fps=30"`},
+		{"broken extra-flags", "extra-flags: -vf \"scale=1280:-2\n", "-tune film"},
+		{"broken extension flags", "flags:\n  mkv: -c:v libx265 -vf 'fps\nextra-flags: -tune grain\n", "-tune film"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			directory := writeDirectoryConfig(t, test.content)
+
+			if got := GetString(filepath.Join(directory, "video.mkv"), "extra-flags"); got != test.want {
+				t.Errorf("extra-flags = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDirectoryConfigModes(t *testing.T) {
+	viper.Set("audio-mode", "aac")
+	viper.Set("subtitles", "copy")
+	viper.Set("hdr", "passthrough")
+	viper.Set("deinterlace", "none")
+
+	defer func() {
+		for _, key := range []string{"audio-mode", "subtitles", "hdr", "deinterlace"} {
+			viper.Set(key, nil)
+		}
+	}()
+
+	tests := []struct {
+		name    string
+		content string
+		key     string
+		want    string
+	}{
+		{"valid audio mode", "audio-mode: opus\n", "audio-mode", "opus"},
+		{"invalid audio mode", "audio-mode: mp3\n", "audio-mode", "aac"},
+		{"invalid subtitle mode", "subtitles: burn\n", "subtitles", "copy"},
+		{"invalid HDR mode", "hdr: tonemap\n", "hdr", "passthrough"},
+		{"invalid deinterlace mode", "deinterlace: always\n", "deinterlace", "none"},
+		{"invalid mode ignores the whole file", "audio-mode: opus\nhdr: tonemap\n", "audio-mode", "aac"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			directory := writeDirectoryConfig(t, test.content)
+
+			if got := GetString(filepath.Join(directory, "video.mkv"), test.key); got != test.want {
+				t.Errorf("%s = %q, want %q", test.key, got, test.want)
+			}
+		})
+	}
+}
+
+func TestCheckMode(t *testing.T) {
+	tests := []struct {
+		key     string
+		value   string
+		wantErr bool
+	}{
+		{"audio-mode", "", false},
+		{"audio-mode", "copy", false},
+		{"audio-mode", "mp3", true},
+		{"subtitles", "drop", false},
+		{"subtitles", "", true},
+		{"hdr", "off", false},
+		{"hdr", "Off", true},
+		{"deinterlace", "bwdif", false},
+		{"deinterlace", "always", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.key+"="+test.value, func(t *testing.T) {
+			if err := CheckMode(test.key, test.value); (err != nil) != test.wantErr {
+				t.Errorf("CheckMode(%q, %q) error = %v, want error %t", test.key, test.value, err, test.wantErr)
+			}
+		})
+	}
+}
@@ -3,9 +3,12 @@ module github.com/Vilsol/transcoder-go
 go 1.14
 
 require (
+	github.com/fsnotify/fsnotify v1.4.7
 	github.com/go-telegram-bot-api/telegram-bot-api v4.6.4+incompatible
+	github.com/mattn/go-sqlite3 v1.14.6
 	github.com/sirupsen/logrus v1.2.0
 	github.com/spf13/cobra v0.0.6
+	github.com/spf13/pflag v1.0.3
 	github.com/spf13/viper v1.6.2
 	github.com/technoweenie/multipartstreamer v1.0.1 // indirect
 )
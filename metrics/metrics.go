@@ -0,0 +1,144 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"github.com/Vilsol/transcoder-go/models"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics in the Prometheus text format, the handful of values does not warrant a client library
+type state struct {
+	sync.Mutex
+
+	files      map[models.Result]int64
+	bytesSaved int64
+	queued     int
+
+	// Latest progress of every file being transcoded
+	progress map[string]*models.ProgressReport
+}
+
+var current = &state{
+	files:    make(map[models.Result]int64),
+	progress: make(map[string]*models.ProgressReport),
+}
+
+// Metric label of every result
+var resultLabels = map[models.Result]string{
+	models.ResultReplaced:     "replaced",
+	models.ResultKeepOriginal: "keep-original",
+	models.ResultError:        "error",
+}
+
+// Label values only escape backslashes, quotes and newlines, unlike Go quoting
+var labelEscaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n")
+
+func AddResult(result models.Result, originalSize int64, newSize int64) {
+	current.Lock()
+	defer current.Unlock()
+
+	current.files[result]++
+
+	if result == models.ResultReplaced {
+		current.bytesSaved += originalSize - newSize
+	}
+}
+
+func SetProgress(fileName string, report *models.ProgressReport) {
+	current.Lock()
+	defer current.Unlock()
+
+	current.progress[fileName] = report
+}
+
+func RemoveProgress(fileName string) {
+	current.Lock()
+	defer current.Unlock()
+
+	delete(current.progress, fileName)
+}
+
+// Sets how many files are waiting to be transcoded
+func SetQueued(queued int) {
+	current.Lock()
+	defer current.Unlock()
+
+	current.queued = queued
+}
+
+func handler(w http.ResponseWriter, _ *http.Request) {
+	current.Lock()
+	defer current.Unlock()
+
+	var out strings.Builder
+
+	out.WriteString("# HELP transcoder_files_total Files processed by result.\n")
+	out.WriteString("# TYPE transcoder_files_total counter\n")
+	for _, result := range []models.Result{models.ResultReplaced, models.ResultKeepOriginal, models.ResultError} {
+		_, _ = fmt.Fprintf(&out, "transcoder_files_total{result=\"%s\"} %d\n", resultLabels[result], current.files[result])
+	}
+
+	out.WriteString("# HELP transcoder_bytes_saved_total Bytes saved by replaced files.\n")
+	out.WriteString("# TYPE transcoder_bytes_saved_total counter\n")
+	_, _ = fmt.Fprintf(&out, "transcoder_bytes_saved_total %d\n", current.bytesSaved)
+
+	out.WriteString("# HELP transcoder_queue_length Files waiting to be transcoded.\n")
+	out.WriteString("# TYPE transcoder_queue_length gauge\n")
+	_, _ = fmt.Fprintf(&out, "transcoder_queue_length %d\n", current.queued)
+
+	out.WriteString("# HELP transcoder_active_transcodes Files being transcoded.\n")
+	out.WriteString("# TYPE transcoder_active_transcodes gauge\n")
+	_, _ = fmt.Fprintf(&out, "transcoder_active_transcodes %d\n", len(current.progress))
+
+	fileNames := make([]string, 0, len(current.progress))
+	for fileName := range current.progress {
+		fileNames = append(fileNames, fileName)
+	}
+	sort.Strings(fileNames)
+
+	out.WriteString("# HELP transcoder_speed Encode speed relative to realtime.\n")
+	out.WriteString("# TYPE transcoder_speed gauge\n")
+	for _, fileName := range fileNames {
+		_, _ = fmt.Fprintf(&out, "transcoder_speed{file=\"%s\"} %g\n", labelEscaper.Replace(fileName), current.progress[fileName].Speed)
+	}
+
+	out.WriteString("# HELP transcoder_fps Encoded frames per second.\n")
+	out.WriteString("# TYPE transcoder_fps gauge\n")
+	for _, fileName := range fileNames {
+		_, _ = fmt.Fprintf(&out, "transcoder_fps{file=\"%s\"} %g\n", labelEscaper.Replace(fileName), current.progress[fileName].FPS)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, _ = w.Write([]byte(out.String()))
+}
+
+// Serves the metrics on /metrics until the returned function gets called
+func Serve(addr string) func() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", handler)
+
+	server := &http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Errorf("Error serving metrics on %s: %s", addr, err)
+		}
+	}()
+
+	log.Infof("Serving metrics on %s/metrics", addr)
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := server.Shutdown(ctx); err != nil {
+			log.Errorf("Error shutting down metrics server: %s", err)
+		}
+	}
+}
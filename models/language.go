@@ -0,0 +1,68 @@
+package models
+
+import "strings"
+
+// ISO 639-2/T codes of the ISO 639-1 codes, so en and eng match the same streams
+var languageCodes = map[string]string{
+	"ar": "ara",
+	"bg": "bul",
+	"cs": "ces",
+	"da": "dan",
+	"de": "deu",
+	"el": "ell",
+	"en": "eng",
+	"es": "spa",
+	"fa": "fas",
+	"fi": "fin",
+	"fr": "fra",
+	"he": "heb",
+	"hi": "hin",
+	"hr": "hrv",
+	"hu": "hun",
+	"id": "ind",
+	"it": "ita",
+	"ja": "jpn",
+	"ko": "kor",
+	"nl": "nld",
+	"no": "nor",
+	"pl": "pol",
+	"pt": "por",
+	"ro": "ron",
+	"ru": "rus",
+	"sk": "slk",
+	"sr": "srp",
+	"sv": "swe",
+	"th": "tha",
+	"tr": "tur",
+	"uk": "ukr",
+	"vi": "vie",
+	"zh": "zho",
+}
+
+// ISO 639-2/B codes that differ from their ISO 639-2/T code
+var bibliographicLanguageCodes = map[string]string{
+	"chi": "zho",
+	"cze": "ces",
+	"dut": "nld",
+	"fre": "fra",
+	"ger": "deu",
+	"gre": "ell",
+	"per": "fas",
+	"rum": "ron",
+	"slo": "slk",
+}
+
+// Returns the ISO 639-2/T code of a language code, unknown codes are only lower cased
+func NormalizeLanguage(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+
+	if normalized, ok := languageCodes[code]; ok {
+		return normalized
+	}
+
+	if normalized, ok := bibliographicLanguageCodes[code]; ok {
+		return normalized
+	}
+
+	return code
+}
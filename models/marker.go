@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+const ProcessedMarkerVersion = 1
+
+// Contents of the .processed file written next to every transcoded file
+type ProcessedMarker struct {
+	Version int `json:"version"`
+
+	// Size of the file the marker belongs to, a different size means the file changed since
+	Size int64 `json:"size"`
+
+	Result       Result    `json:"result,omitempty"`
+	OriginalSize int64     `json:"original_size,omitempty"`
+	NewSize      int64     `json:"new_size,omitempty"`
+	Container    string    `json:"container,omitempty"`
+	Flags        string    `json:"flags,omitempty"`
+	Timestamp    time.Time `json:"timestamp"`
+
+	// A different modification time also means the file changed, unless the hash recorded in a database still matches
+	ModTime time.Time `json:"mod_time,omitempty"`
+	Hash    string    `json:"hash,omitempty"`
+}
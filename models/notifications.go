@@ -1,17 +1,41 @@
 package models
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 type NotificationData struct {
 	Started time.Time
 
+	Path           string
 	Filename       string
 	OriginalFrames int
 	OriginalSize   int
+	Duration       time.Duration
 
 	CurrentFrame int
 	CurrentSize  int
 	FPS          float64
 	Bitrate      float64
 	Speed        float64
+	ETA          time.Duration
+
+	Reason string
+
+	// Position of the file in the batch, zero when unknown
+	Index int
+	Total int
+
+	// JPEG screenshot of the transcoded file, nil unless notify-thumbnail is enabled
+	Thumbnail []byte `json:"-"`
+}
+
+// Filename with the position in the batch
+func (data *NotificationData) Title() string {
+	if data.Total == 0 {
+		return data.Filename
+	}
+
+	return fmt.Sprintf("%s (%d/%d)", data.Filename, data.Index, data.Total)
 }
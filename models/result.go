@@ -0,0 +1,10 @@
+package models
+
+type FileResult struct {
+	File         string   `json:"file"`
+	Result       Result   `json:"result"`
+	OriginalSize int64    `json:"original_size"`
+	NewSize      int64    `json:"new_size"`
+	Duration     float64  `json:"duration"`
+	VMAF         *float64 `json:"vmaf,omitempty"`
+}
@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+type BatchSummary struct {
+	Started time.Time
+	Ended   time.Time
+
+	Files        int
+	Replaced     int
+	KeptOriginal int
+	Errors       int
+
+	OriginalSize int64
+	BytesSaved   int64
+}
+
+func (summary *BatchSummary) Add(result Result, originalSize int64, newSize int64) {
+	summary.Files++
+	summary.OriginalSize += originalSize
+
+	switch result {
+	case ResultReplaced:
+		summary.Replaced++
+		summary.BytesSaved += originalSize - newSize
+		break
+	case ResultKeepOriginal:
+		summary.KeptOriginal++
+		break
+	case ResultError:
+		summary.Errors++
+		break
+	}
+}
+
+func (summary *BatchSummary) Duration() time.Duration {
+	return summary.Ended.Sub(summary.Started)
+}
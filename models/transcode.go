@@ -4,42 +4,84 @@ import (
 	log "github.com/sirupsen/logrus"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type FileMetadata struct {
-	Streams []Stream `json:"streams"`
-	Format  Format   `json:"format"`
+	Streams  []Stream  `json:"streams"`
+	Format   Format    `json:"format"`
+	Chapters []Chapter `json:"chapters"`
 }
 
 type Stream struct {
-	CodecName      string  `json:"codec_name"`
-	CodecType      string  `json:"codec_type"`
-	PixelFormat    *string `json:"pix_fmt"`
-	Level          int     `json:"level"`
-	ColorRange     *string `json:"color_range"`
-	ColorSpace     *string `json:"color_space"`
-	ColorTransfer  *string `json:"color_transfer"`
-	ColorPrimaries *string `json:"color_primaries"`
-	NumberFrames   string  `json:"nb_frames"`
-	RFrameRate     *string `json:"r_frame_rate"`
-	AvgFrameRate   *string `json:"avg_frame_rate"`
+	Index          int               `json:"index"`
+	CodecName      string            `json:"codec_name"`
+	CodecType      string            `json:"codec_type"`
+	Profile        string            `json:"profile"`
+	PixelFormat    *string           `json:"pix_fmt"`
+	Level          int               `json:"level"`
+	Width          int               `json:"width"`
+	Height         int               `json:"height"`
+	ColorRange     *string           `json:"color_range"`
+	ColorSpace     *string           `json:"color_space"`
+	ColorTransfer  *string           `json:"color_transfer"`
+	ColorPrimaries *string           `json:"color_primaries"`
+	NumberFrames   string            `json:"nb_frames"`
+	RFrameRate     *string           `json:"r_frame_rate"`
+	AvgFrameRate   *string           `json:"avg_frame_rate"`
+	BitRate        string            `json:"bit_rate"`
+	SampleRate     string            `json:"sample_rate"`
+	Channels       int               `json:"channels"`
+	ChannelLayout  string            `json:"channel_layout"`
+	Disposition    map[string]int    `json:"disposition"`
+	Tags           map[string]string `json:"tags"`
+	SideDataList   []SideData        `json:"side_data_list"`
+}
+
+// Stream or frame side data, only the HDR fields are parsed
+type SideData struct {
+	SideDataType string `json:"side_data_type"`
+	// Mastering display chromaticities and luminance as rationals, e.g. 35400/50000
+	RedX         string `json:"red_x"`
+	RedY         string `json:"red_y"`
+	GreenX       string `json:"green_x"`
+	GreenY       string `json:"green_y"`
+	BlueX        string `json:"blue_x"`
+	BlueY        string `json:"blue_y"`
+	WhitePointX  string `json:"white_point_x"`
+	WhitePointY  string `json:"white_point_y"`
+	MinLuminance string `json:"min_luminance"`
+	MaxLuminance string `json:"max_luminance"`
+	// Content light level
+	MaxContent int `json:"max_content"`
+	MaxAverage int `json:"max_average"`
 }
 
 type Format struct {
-	Filename   string `json:"filename"`
-	FormatName string `json:"format_name"`
-	Duration   string `json:"duration"`
-	Size       string `json:"size"`
-	BitRate    string `json:"bit_rate"`
+	Filename   string            `json:"filename"`
+	FormatName string            `json:"format_name"`
+	Duration   string            `json:"duration"`
+	Size       string            `json:"size"`
+	BitRate    string            `json:"bit_rate"`
+	Tags       map[string]string `json:"tags"`
+}
+
+type Chapter struct {
+	ID        int               `json:"id"`
+	StartTime string            `json:"start_time"`
+	EndTime   string            `json:"end_time"`
+	Tags      map[string]string `json:"tags"`
 }
 
 type ProgressReport struct {
-	Frame     int
-	FPS       float64
-	Bitrate   float64
-	TotalSize int
-	Speed     float64
-	Progress  string
+	Frame     int           `json:"frame"`
+	FPS       float64       `json:"fps"`
+	Bitrate   float64       `json:"bitrate"`
+	TotalSize int           `json:"total_size"`
+	Speed     float64       `json:"speed"`
+	OutTime   float64       `json:"out_time"`
+	ETA       time.Duration `json:"eta"`
+	Progress  string        `json:"progress"`
 }
 
 type Result string
@@ -50,11 +92,112 @@ const (
 	ResultError        = Result("Error")
 )
 
+// How a single ffmpeg run ended
+type Outcome string
+
+const (
+	OutcomeFinished = Outcome("Finished")
+	// Stopped early because the output got bigger than the original
+	OutcomeStopped = Outcome("Stopped")
+	// The context got cancelled by a termination signal or a timeout
+	OutcomeTerminated = Outcome("Terminated")
+	// ffmpeg exited with an error of its own
+	OutcomeFailed = Outcome("Failed")
+)
+
 func (format Format) SizeInt() int64 {
 	i, _ := strconv.Atoi(format.Size)
 	return int64(i)
 }
 
+func (metadata *FileMetadata) StreamsOfType(codecType string) []Stream {
+	streams := make([]Stream, 0)
+
+	for _, stream := range metadata.Streams {
+		if stream.CodecType == codecType {
+			streams = append(streams, stream)
+		}
+	}
+
+	return streams
+}
+
+func (metadata *FileMetadata) VideoStreams() []Stream {
+	return metadata.StreamsOfType("video")
+}
+
+func (metadata *FileMetadata) AudioStreams() []Stream {
+	return metadata.StreamsOfType("audio")
+}
+
+func (metadata *FileMetadata) SubtitleStreams() []Stream {
+	return metadata.StreamsOfType("subtitle")
+}
+
+// Returns the first video stream, nil if there is none
+func (metadata *FileMetadata) VideoStream() *Stream {
+	for i := range metadata.Streams {
+		if metadata.Streams[i].CodecType == "video" {
+			return &metadata.Streams[i]
+		}
+	}
+
+	return nil
+}
+
+func (stream Stream) BitRateInt() int64 {
+	i, _ := strconv.ParseInt(stream.BitRate, 10, 64)
+	return i
+}
+
+// Returns the language tag of the stream, empty if it has none
+func (stream Stream) Language() string {
+	language := stream.Tags["language"]
+
+	if language == "und" {
+		return ""
+	}
+
+	return language
+}
+
+// Returns the normalized language of the stream, see NormalizeLanguage
+func (stream Stream) LanguageCode() string {
+	return NormalizeLanguage(stream.Language())
+}
+
+func (stream Stream) Title() string {
+	return stream.Tags["title"]
+}
+
+// Whether the stream uses a PQ or HLG transfer or BT.2020 primaries
+func (stream Stream) IsHDR() bool {
+	if stream.ColorTransfer != nil && (*stream.ColorTransfer == "smpte2084" || *stream.ColorTransfer == "arib-std-b67") {
+		return true
+	}
+
+	return stream.ColorPrimaries != nil && *stream.ColorPrimaries == "bt2020"
+}
+
+// Returns the side data of the given type, nil if the stream has none
+func (stream Stream) SideData(sideDataType string) *SideData {
+	for i := range stream.SideDataList {
+		if stream.SideDataList[i].SideDataType == sideDataType {
+			return &stream.SideDataList[i]
+		}
+	}
+
+	return nil
+}
+
+func (stream Stream) IsDefault() bool {
+	return stream.Disposition["default"] == 1
+}
+
+func (stream Stream) IsForced() bool {
+	return stream.Disposition["forced"] == 1
+}
+
 func (stream Stream) FrameRate() float64 {
 	rate := ""
 
@@ -79,10 +222,12 @@ func (stream Stream) FrameRate() float64 {
 }
 
 func (report *ProgressReport) Log(filename string) {
-	log.WithField("frame", report.Frame).
+	log.WithField("file", filename).
+		WithField("frame", report.Frame).
 		WithField("fps", report.FPS).
 		WithField("bitrate", report.Bitrate).
 		WithField("total_size", report.TotalSize).
 		WithField("speed", report.Speed).
+		WithField("eta", report.ETA.Truncate(time.Second).String()).
 		Infof("Progress: %s", filename)
 }
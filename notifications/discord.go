@@ -0,0 +1,137 @@
+package notifications
+
+import (
+	"fmt"
+	"github.com/Vilsol/transcoder-go/models"
+	"github.com/Vilsol/transcoder-go/utils"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"strconv"
+	"time"
+)
+
+const (
+	discordColorGreen  = 0x2ecc71
+	discordColorYellow = 0xf1c40f
+	discordColorRed    = 0xe74c3c
+)
+
+type discordWebhook struct {
+	Embeds []discordEmbed `json:"embeds"`
+}
+
+type discordEmbed struct {
+	Title  string              `json:"title"`
+	Color  int                 `json:"color"`
+	Fields []discordEmbedField `json:"fields"`
+	Image  *discordEmbedImage  `json:"image,omitempty"`
+}
+
+type discordEmbedImage struct {
+	URL string `json:"url"`
+}
+
+type discordEmbedField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+func init() {
+	initialize = append(initialize, func() {
+		if viper.GetString("discord-webhook") == "" {
+			return
+		}
+
+		log.Info("Discord webhook configured")
+
+		end = append(end, func(data *models.NotificationData, result models.Result) {
+			embed := generateDiscordEmbed(data, result)
+
+			var err error
+			if data.Thumbnail != nil {
+				// Attachments are referenced by their file name from the embed
+				embed.Image = &discordEmbedImage{URL: "attachment://thumbnail.jpg"}
+				err = postMultipartJSON(viper.GetString("discord-webhook"), discordWebhook{
+					Embeds: []discordEmbed{embed},
+				}, "file", "thumbnail.jpg", data.Thumbnail)
+			} else {
+				err = postJSON(viper.GetString("discord-webhook"), discordWebhook{
+					Embeds: []discordEmbed{embed},
+				})
+			}
+
+			if err != nil {
+				log.Errorf("Error sending discord message: %s", err)
+			}
+		})
+
+		summary = append(summary, func(batchSummary *models.BatchSummary) {
+			err := postJSON(viper.GetString("discord-webhook"), discordWebhook{
+				Embeds: []discordEmbed{generateDiscordSummaryEmbed(batchSummary)},
+			})
+
+			if err != nil {
+				log.Errorf("Error sending discord message: %s", err)
+			}
+		})
+	})
+}
+
+func generateDiscordEmbed(data *models.NotificationData, result models.Result) discordEmbed {
+	embed := discordEmbed{
+		Title: data.Title(),
+		Color: discordColorGreen,
+	}
+
+	switch result {
+	case models.ResultKeepOriginal:
+		embed.Color = discordColorYellow
+		break
+	case models.ResultError:
+		embed.Color = discordColorRed
+		break
+	}
+
+	if result != models.ResultError {
+		diff := (float64(data.CurrentSize) / float64(data.OriginalSize)) * 100
+
+		embed.Fields = append(embed.Fields, discordEmbedField{
+			Name:  "Size",
+			Value: fmt.Sprintf("%s --> %s (%.2f%%)", utils.BytesHumanReadable(int64(data.OriginalSize)), utils.BytesHumanReadable(int64(data.CurrentSize)), diff),
+		})
+	}
+
+	embed.Fields = append(embed.Fields, discordEmbedField{
+		Name:  "Status",
+		Value: string(result),
+	})
+
+	if data.Reason != "" {
+		embed.Fields = append(embed.Fields, discordEmbedField{
+			Name:  "Reason",
+			Value: data.Reason,
+		})
+	}
+
+	return embed
+}
+
+func generateDiscordSummaryEmbed(summary *models.BatchSummary) discordEmbed {
+	embed := discordEmbed{
+		Title: fmt.Sprintf("Transcoded %d files in %s", summary.Files, summary.Duration().Truncate(time.Second)),
+		Color: discordColorGreen,
+		Fields: []discordEmbedField{
+			{Name: "Replaced", Value: strconv.Itoa(summary.Replaced), Inline: true},
+			{Name: "Kept Original", Value: strconv.Itoa(summary.KeptOriginal), Inline: true},
+			{Name: "Errors", Value: strconv.Itoa(summary.Errors), Inline: true},
+			{Name: "Saved", Value: utils.BytesHumanReadable(summary.BytesSaved)},
+		},
+	}
+
+	if summary.Errors > 0 {
+		embed.Color = discordColorRed
+	}
+
+	return embed
+}
@@ -0,0 +1,71 @@
+package notifications
+
+import (
+	"github.com/Vilsol/transcoder-go/models"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"net/url"
+	"strings"
+)
+
+type gotifyMessage struct {
+	Title    string `json:"title"`
+	Message  string `json:"message"`
+	Priority int    `json:"priority"`
+}
+
+func init() {
+	initialize = append(initialize, func() {
+		if viper.GetString("gotify-url") == "" || viper.GetString("gotify-token") == "" {
+			return
+		}
+
+		log.Infof("Gotify configured: %s", viper.GetString("gotify-url"))
+
+		end = append(end, func(data *models.NotificationData, result models.Result) {
+			err := sendGotify(gotifyMessage{
+				Title:    data.Title(),
+				Message:  generatePlainText(data, result),
+				Priority: gotifyPriority(result),
+			})
+
+			if err != nil {
+				log.Errorf("Error sending gotify message: %s", err)
+			}
+		})
+
+		summary = append(summary, func(batchSummary *models.BatchSummary) {
+			err := sendGotify(gotifyMessage{
+				Title:    "Transcoding finished",
+				Message:  generatePlainSummaryText(batchSummary),
+				Priority: 4,
+			})
+
+			if err != nil {
+				log.Errorf("Error sending gotify message: %s", err)
+			}
+		})
+	})
+}
+
+func gotifyPriority(result models.Result) int {
+	if result == models.ResultError {
+		return 8
+	}
+
+	return 4
+}
+
+func sendGotify(message gotifyMessage) error {
+	endpoint := strings.TrimSuffix(viper.GetString("gotify-url"), "/") + "/message?token=" + url.QueryEscape(viper.GetString("gotify-token"))
+
+	err := postJSON(endpoint, message)
+
+	// Retry once if gotify had a transient server error
+	if statusErr, ok := err.(*httpStatusError); ok && statusErr.StatusCode >= 500 {
+		log.Warningf("Retrying gotify message: %s", err)
+		err = postJSON(endpoint, message)
+	}
+
+	return err
+}
@@ -0,0 +1,109 @@
+package notifications
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"time"
+)
+
+// Keeps a hung API from stalling the queue
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+type httpStatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (err *httpStatusError) Error() string {
+	return fmt.Sprintf("unexpected status: %s", err.Status)
+}
+
+func postJSON(url string, payload interface{}) error {
+	return sendJSON(http.MethodPost, url, payload, nil)
+}
+
+func sendJSON(method string, url string, payload interface{}, header http.Header) error {
+	body, err := json.Marshal(payload)
+
+	if err != nil {
+		return err
+	}
+
+	return sendBody(method, url, body, header)
+}
+
+// Posts the payload as payload_json of a multipart form, along with a single file
+func postMultipartJSON(url string, payload interface{}, fieldName string, fileName string, file []byte) error {
+	payloadJSON, err := json.Marshal(payload)
+
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	if err := writer.WriteField("payload_json", string(payloadJSON)); err != nil {
+		return err
+	}
+
+	part, err := writer.CreateFormFile(fieldName, fileName)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := part.Write(file); err != nil {
+		return err
+	}
+
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	response, err := httpClient.Post(url, writer.FormDataContentType(), &body)
+
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return &httpStatusError{StatusCode: response.StatusCode, Status: response.Status}
+	}
+
+	return nil
+}
+
+// Sends an already serialized JSON body
+func sendBody(method string, url string, body []byte, header http.Header) error {
+	request, err := http.NewRequest(method, url, bytes.NewReader(body))
+
+	if err != nil {
+		return err
+	}
+
+	for key, values := range header {
+		request.Header[key] = values
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := httpClient.Do(request)
+
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return &httpStatusError{StatusCode: response.StatusCode, Status: response.Status}
+	}
+
+	return nil
+}
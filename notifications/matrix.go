@@ -0,0 +1,86 @@
+package notifications
+
+import (
+	"fmt"
+	"github.com/Vilsol/transcoder-go/models"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type matrixMessage struct {
+	MessageType   string `json:"msgtype"`
+	Body          string `json:"body"`
+	Format        string `json:"format"`
+	FormattedBody string `json:"formatted_body"`
+}
+
+var matrixTransaction int64
+
+func init() {
+	initialize = append(initialize, func() {
+		if viper.GetString("matrix-homeserver") == "" || viper.GetString("matrix-token") == "" || viper.GetString("matrix-room") == "" {
+			return
+		}
+
+		log.Infof("Matrix configured: %s", viper.GetString("matrix-homeserver"))
+
+		end = append(end, func(data *models.NotificationData, result models.Result) {
+			logMatrixError(sendMatrix(generatePlainText(data, result)))
+		})
+
+		summary = append(summary, func(batchSummary *models.BatchSummary) {
+			logMatrixError(sendMatrix(generatePlainSummaryText(batchSummary)))
+		})
+	})
+}
+
+func logMatrixError(err error) {
+	if err == nil {
+		return
+	}
+
+	if statusErr, ok := err.(*httpStatusError); ok && (statusErr.StatusCode == http.StatusUnauthorized || statusErr.StatusCode == http.StatusForbidden) {
+		log.Errorf("Matrix rejected the access token: %s", err)
+		return
+	}
+
+	log.Errorf("Error sending matrix message: %s", err)
+}
+
+func sendMatrix(text string) error {
+	// Transaction IDs only have to be unique per access token
+	transaction := fmt.Sprintf("transcoder-%d-%d", time.Now().UnixNano(), atomic.AddInt64(&matrixTransaction, 1))
+
+	endpoint := strings.TrimSuffix(viper.GetString("matrix-homeserver"), "/") +
+		"/_matrix/client/r0/rooms/" + url.PathEscape(viper.GetString("matrix-room")) +
+		"/send/m.room.message/" + transaction
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+viper.GetString("matrix-token"))
+
+	return sendJSON(http.MethodPut, endpoint, matrixMessage{
+		MessageType:   "m.text",
+		Body:          text,
+		Format:        "org.matrix.custom.html",
+		FormattedBody: generateMatrixHTML(text),
+	}, header)
+}
+
+// Bolds the first line of the plain text and keeps the line breaks
+func generateMatrixHTML(text string) string {
+	lines := strings.Split(text, "\n")
+
+	for i := range lines {
+		lines[i] = html.EscapeString(lines[i])
+	}
+
+	lines[0] = "<b>" + lines[0] + "</b>"
+
+	return strings.Join(lines, "<br>")
+}
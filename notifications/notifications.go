@@ -1,9 +1,12 @@
 package notifications
 
 import (
+	"fmt"
 	"github.com/Vilsol/transcoder-go/models"
+	"github.com/spf13/viper"
 	"path/filepath"
 	"strconv"
+	"sync"
 	"time"
 )
 
@@ -12,40 +15,159 @@ type Start func(*models.NotificationData)
 type ProgressStatus func(*models.NotificationData)
 type End func(*models.NotificationData, models.Result)
 
+// Ends the job of a file when notify-on filters out its result, so providers that track jobs can clean them up
+type Discard func(*models.NotificationData, models.Result)
+type Summary func(*models.BatchSummary)
+
 var initialize []Initialize
 var start []Start
 var progressStatus []ProgressStatus
 var end []End
+var discard []Discard
+var summary []Summary
+
+// Job tracks the notification state of a single file being transcoded
+type Job struct {
+	started   time.Time
+	fileName  string
+	metadata  *models.FileMetadata
+	reason    string
+	thumbnail []byte
+	index     int
+	total     int
+}
+
+// Names of the results accepted by notify-on
+var notifyOnResults = map[string]models.Result{
+	"error":         models.ResultError,
+	"keep-original": models.ResultKeepOriginal,
+	"replaced":      models.ResultReplaced,
+}
+
+// Fails if notify-on contains an unknown result
+func CheckNotifyOn() error {
+	for _, name := range viper.GetStringSlice("notify-on") {
+		if _, ok := notifyOnResults[name]; !ok {
+			return fmt.Errorf("unknown result %s, expected one of error, keep-original, replaced", name)
+		}
+	}
+
+	return nil
+}
 
-var started time.Time
-var currentFileMetadata *models.FileMetadata
+// Whether end notifications are sent for the result
+func notifiesOn(result models.Result) bool {
+	for _, name := range viper.GetStringSlice("notify-on") {
+		if notifyOnResults[name] == result {
+			return true
+		}
+	}
+
+	return false
+}
 
 func InitializeNotifications() {
 	for _, f := range initialize {
+		startCount, progressStatusCount, endCount, discardCount, summaryCount := len(start), len(progressStatus), len(end), len(discard), len(summary)
+
 		f()
+
+		if startCount == len(start) && progressStatusCount == len(progressStatus) && endCount == len(end) && discardCount == len(discard) && summaryCount == len(summary) {
+			// Provider is not configured
+			continue
+		}
+
+		// Everything the provider registered shares its queue
+		q := newQueue()
+
+		for i := startCount; i < len(start); i++ {
+			f := start[i]
+			start[i] = func(data *models.NotificationData) {
+				q.do(func() { f(data) })
+			}
+		}
+
+		for i := progressStatusCount; i < len(progressStatus); i++ {
+			f := progressStatus[i]
+			progressStatus[i] = func(data *models.NotificationData) {
+				q.do(func() { f(data) })
+			}
+		}
+
+		for i := endCount; i < len(end); i++ {
+			f := end[i]
+			end[i] = func(data *models.NotificationData, result models.Result) {
+				q.do(func() { f(data, result) })
+			}
+		}
+
+		for i := discardCount; i < len(discard); i++ {
+			f := discard[i]
+			discard[i] = func(data *models.NotificationData, result models.Result) {
+				q.do(func() { f(data, result) })
+			}
+		}
+
+		for i := summaryCount; i < len(summary); i++ {
+			f := summary[i]
+			summary[i] = func(batchSummary *models.BatchSummary) {
+				q.do(func() { f(batchSummary) })
+			}
+		}
 	}
 }
 
-func NotifyStart(metadata *models.FileMetadata) {
-	currentFileMetadata = metadata
-	started = time.Now()
+// Index and total are the position of the file in the batch
+func NotifyStart(fileName string, metadata *models.FileMetadata, index int, total int) *Job {
+	job := &Job{
+		started:  time.Now(),
+		fileName: fileName,
+		metadata: metadata,
+		index:    index,
+		total:    total,
+	}
+
+	if viper.GetBool("summary-only") {
+		return job
+	}
 
-	notificationData := generateUpdatedNotificationData(nil)
+	notificationData := job.generateUpdatedNotificationData(nil)
 
 	for _, f := range start {
 		f(notificationData)
 	}
+
+	return job
+}
+
+// Sets why the file ended up with its result, included in the end notification
+func (job *Job) SetReason(reason string) {
+	job.reason = reason
+}
+
+// Sets the screenshot attached to the end notification
+func (job *Job) SetThumbnail(thumbnail []byte) {
+	job.thumbnail = thumbnail
 }
 
-func NotifyProgressStatus(report *models.ProgressReport) {
-	notificationData := generateUpdatedNotificationData(report)
+func (job *Job) NotifyProgressStatus(report *models.ProgressReport) {
+	if viper.GetBool("summary-only") {
+		return
+	}
+
+	notificationData := job.generateUpdatedNotificationData(report)
 	for _, f := range progressStatus {
 		f(notificationData)
 	}
 }
 
-func NotifyEnd(finalMeta *models.FileMetadata, lastReport *models.ProgressReport, result models.Result) {
-	notificationData := generateUpdatedNotificationData(lastReport)
+func (job *Job) NotifyEnd(finalMeta *models.FileMetadata, lastReport *models.ProgressReport, result models.Result) {
+	if viper.GetBool("summary-only") {
+		return
+	}
+
+	notificationData := job.generateUpdatedNotificationData(lastReport)
+	notificationData.Thumbnail = job.thumbnail
 
 	if finalMeta != nil {
 		notificationData.CurrentSize, _ = strconv.Atoi(finalMeta.Format.Size)
@@ -65,21 +187,59 @@ func NotifyEnd(finalMeta *models.FileMetadata, lastReport *models.ProgressReport
 		}
 	}
 
-	for _, f := range end {
-		f(notificationData, result)
+	callbacks := make([]func(*models.NotificationData, models.Result), 0)
+	if notifiesOn(result) {
+		for _, f := range end {
+			callbacks = append(callbacks, f)
+		}
+	} else {
+		for _, f := range discard {
+			callbacks = append(callbacks, f)
+		}
 	}
+
+	// Providers are independent, so a slow or failing one must not hold up the rest
+	var wg sync.WaitGroup
+	for _, f := range callbacks {
+		wg.Add(1)
+		go func(f func(*models.NotificationData, models.Result)) {
+			defer wg.Done()
+			f(notificationData, result)
+		}(f)
+	}
+	wg.Wait()
+}
+
+func NotifySummary(batchSummary *models.BatchSummary) {
+	var wg sync.WaitGroup
+	for _, f := range summary {
+		wg.Add(1)
+		go func(f Summary) {
+			defer wg.Done()
+			f(batchSummary)
+		}(f)
+	}
+	wg.Wait()
 }
 
-func generateUpdatedNotificationData(report *models.ProgressReport) *models.NotificationData {
+func (job *Job) generateUpdatedNotificationData(report *models.ProgressReport) *models.NotificationData {
 	data := models.NotificationData{
-		Started:  started,
-		Filename: filepath.Base(currentFileMetadata.Format.Filename),
+		Started:  job.started,
+		Path:     job.fileName,
+		Filename: filepath.Base(job.fileName),
+		Reason:   job.reason,
+		Index:    job.index,
+		Total:    job.total,
 	}
 
-	data.OriginalSize, _ = strconv.Atoi(currentFileMetadata.Format.Size)
+	data.OriginalSize, _ = strconv.Atoi(job.metadata.Format.Size)
+
+	duration, _ := strconv.ParseFloat(job.metadata.Format.Duration, 64)
+	data.Duration = time.Duration(duration * float64(time.Second))
+
 	framerate := float64(0)
 
-	for _, stream := range currentFileMetadata.Streams {
+	for _, stream := range job.metadata.Streams {
 		if stream.CodecType == "video" {
 			data.OriginalFrames, _ = strconv.Atoi(stream.NumberFrames)
 			framerate = stream.FrameRate()
@@ -88,12 +248,12 @@ func generateUpdatedNotificationData(report *models.ProgressReport) *models.Noti
 	}
 
 	if data.OriginalFrames == 0 && framerate > 0 {
-		duration, _ := strconv.ParseFloat(currentFileMetadata.Format.Duration, 64)
 		data.OriginalFrames = int(framerate * duration)
 	}
 
 	if report != nil {
 		data.Speed = report.Speed
+		data.ETA = report.ETA
 		data.Bitrate = report.Bitrate
 		data.FPS = report.FPS
 		data.CurrentFrame = report.Frame
@@ -0,0 +1,72 @@
+package notifications
+
+import (
+	"fmt"
+	"github.com/Vilsol/transcoder-go/models"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"net/http"
+	"strings"
+)
+
+func init() {
+	initialize = append(initialize, func() {
+		if viper.GetString("ntfy-topic") == "" {
+			return
+		}
+
+		log.Info("ntfy configured")
+
+		end = append(end, func(data *models.NotificationData, result models.Result) {
+			err := sendNtfy(data.Title(), generatePlainText(data, result), ntfyPriority(result))
+
+			if err != nil {
+				log.Warningf("Error sending ntfy message: %s", err)
+			}
+		})
+
+		summary = append(summary, func(batchSummary *models.BatchSummary) {
+			err := sendNtfy("Transcoding finished", generatePlainSummaryText(batchSummary), "default")
+
+			if err != nil {
+				log.Warningf("Error sending ntfy message: %s", err)
+			}
+		})
+	})
+}
+
+func ntfyPriority(result models.Result) string {
+	if result == models.ResultError {
+		return "high"
+	}
+
+	return "default"
+}
+
+func sendNtfy(title string, message string, priority string) error {
+	url := strings.TrimSuffix(viper.GetString("ntfy-url"), "/") + "/" + viper.GetString("ntfy-topic")
+
+	request, err := http.NewRequest(http.MethodPost, url, strings.NewReader(message))
+
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "text/plain")
+	request.Header.Set("Title", title)
+	request.Header.Set("Priority", priority)
+
+	response, err := httpClient.Do(request)
+
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("unexpected status: %s", response.Status)
+	}
+
+	return nil
+}
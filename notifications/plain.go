@@ -0,0 +1,46 @@
+package notifications
+
+import (
+	"fmt"
+	"github.com/Vilsol/transcoder-go/models"
+	"github.com/Vilsol/transcoder-go/utils"
+	"time"
+)
+
+func generatePlainText(data *models.NotificationData, result models.Result) string {
+	reason := ""
+	if data.Reason != "" {
+		reason = "\nReason: " + data.Reason
+	}
+
+	if result == models.ResultError {
+		return fmt.Sprintf("%s\nStatus: %s%s", data.Title(), string(result), reason)
+	}
+
+	diff := (float64(data.CurrentSize) / float64(data.OriginalSize)) * 100
+
+	return fmt.Sprintf(
+		"%s"+
+			"\nSize: %s --> %s (%.2f%%)"+
+			"\nStatus: %s%s",
+		data.Title(),
+		utils.BytesHumanReadable(int64(data.OriginalSize)), utils.BytesHumanReadable(int64(data.CurrentSize)), diff,
+		string(result),
+		reason,
+	)
+}
+
+func generatePlainSummaryText(summary *models.BatchSummary) string {
+	return fmt.Sprintf(
+		"Transcoded %d files in %s"+
+			"\nReplaced: %d"+
+			"\nKept original: %d"+
+			"\nErrors: %d"+
+			"\nSaved: %s",
+		summary.Files, summary.Duration().Truncate(time.Second),
+		summary.Replaced,
+		summary.KeptOriginal,
+		summary.Errors,
+		utils.BytesHumanReadable(summary.BytesSaved),
+	)
+}
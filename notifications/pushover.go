@@ -0,0 +1,61 @@
+package notifications
+
+import (
+	"github.com/Vilsol/transcoder-go/models"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const pushoverEndpoint = "https://api.pushover.net/1/messages.json"
+
+type pushoverMessage struct {
+	Token    string `json:"token"`
+	User     string `json:"user"`
+	Title    string `json:"title"`
+	Message  string `json:"message"`
+	Priority int    `json:"priority"`
+}
+
+func init() {
+	initialize = append(initialize, func() {
+		if viper.GetString("pushover-token") == "" || viper.GetString("pushover-user") == "" {
+			return
+		}
+
+		log.Info("Pushover configured")
+
+		end = append(end, func(data *models.NotificationData, result models.Result) {
+			err := sendPushover(data.Title(), generatePlainText(data, result), pushoverPriority(result))
+
+			if err != nil {
+				log.Errorf("Error sending pushover message: %s", err)
+			}
+		})
+
+		summary = append(summary, func(batchSummary *models.BatchSummary) {
+			err := sendPushover("Transcoding finished", generatePlainSummaryText(batchSummary), 0)
+
+			if err != nil {
+				log.Errorf("Error sending pushover message: %s", err)
+			}
+		})
+	})
+}
+
+func pushoverPriority(result models.Result) int {
+	if result == models.ResultError {
+		return 1
+	}
+
+	return 0
+}
+
+func sendPushover(title string, message string, priority int) error {
+	return postJSON(pushoverEndpoint, pushoverMessage{
+		Token:    viper.GetString("pushover-token"),
+		User:     viper.GetString("pushover-user"),
+		Title:    title,
+		Message:  message,
+		Priority: priority,
+	})
+}
@@ -0,0 +1,35 @@
+package notifications
+
+// Runs the sends of a single provider one at a time, in the order they were queued.
+// Workers notify concurrently, so without it messages of a provider could interleave or hit rate limits.
+type queue struct {
+	tasks chan func()
+}
+
+func newQueue() *queue {
+	q := &queue{
+		tasks: make(chan func()),
+	}
+
+	go q.run()
+
+	return q
+}
+
+func (q *queue) run() {
+	for task := range q.tasks {
+		task()
+	}
+}
+
+// Runs the task after every task queued before it and waits for it to finish
+func (q *queue) do(task func()) {
+	done := make(chan bool)
+
+	q.tasks <- func() {
+		defer close(done)
+		task()
+	}
+
+	<-done
+}
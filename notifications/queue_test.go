@@ -0,0 +1,173 @@
+package notifications
+
+import (
+	"fmt"
+	"github.com/Vilsol/transcoder-go/models"
+	"github.com/spf13/viper"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Records the end notifications it gets and whether two of them ever ran at once
+type recordingProvider struct {
+	delay    time.Duration
+	running  int32
+	overlaps int32
+
+	lock      sync.Mutex
+	files     []string
+	discarded []string
+}
+
+func (p *recordingProvider) end(data *models.NotificationData, result models.Result) {
+	if atomic.AddInt32(&p.running, 1) > 1 {
+		atomic.AddInt32(&p.overlaps, 1)
+	}
+
+	time.Sleep(p.delay)
+
+	p.lock.Lock()
+	p.files = append(p.files, data.Path)
+	p.lock.Unlock()
+
+	atomic.AddInt32(&p.running, -1)
+}
+
+func (p *recordingProvider) discard(data *models.NotificationData, result models.Result) {
+	p.lock.Lock()
+	p.discarded = append(p.discarded, data.Path)
+	p.lock.Unlock()
+}
+
+// Registers the providers as the only ones for the rest of the test
+func useProviders(t *testing.T, providers ...*recordingProvider) {
+	oldInitialize, oldStart, oldProgressStatus, oldEnd, oldDiscard, oldSummary := initialize, start, progressStatus, end, discard, summary
+	initialize, start, progressStatus, end, discard, summary = nil, nil, nil, nil, nil, nil
+
+	viper.Set("notify-on", []string{"replaced"})
+
+	t.Cleanup(func() {
+		initialize, start, progressStatus, end, discard, summary = oldInitialize, oldStart, oldProgressStatus, oldEnd, oldDiscard, oldSummary
+		viper.Set("notify-on", nil)
+	})
+
+	for _, provider := range providers {
+		provider := provider
+		initialize = append(initialize, func() {
+			end = append(end, provider.end)
+			discard = append(discard, provider.discard)
+		})
+	}
+
+	InitializeNotifications()
+}
+
+func TestConcurrentNotifyEnd(t *testing.T) {
+	const workers = 8
+	const filesPerWorker = 25
+
+	slow := &recordingProvider{delay: time.Millisecond}
+	fast := &recordingProvider{}
+	useProviders(t, slow, fast)
+
+	var wg sync.WaitGroup
+
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+
+		go func(worker int) {
+			defer wg.Done()
+
+			for i := 0; i < filesPerWorker; i++ {
+				job := &Job{
+					fileName: fmt.Sprintf("%d/%d.mkv", worker, i),
+					metadata: &models.FileMetadata{},
+				}
+
+				job.NotifyEnd(nil, nil, models.ResultReplaced)
+			}
+		}(worker)
+	}
+
+	wg.Wait()
+
+	for name, provider := range map[string]*recordingProvider{"slow": slow, "fast": fast} {
+		if provider.overlaps > 0 {
+			t.Errorf("%s provider ran %d notifications at once", name, provider.overlaps)
+		}
+
+		if len(provider.files) != workers*filesPerWorker {
+			t.Errorf("%s provider got %d notifications, want %d", name, len(provider.files), workers*filesPerWorker)
+		}
+
+		// Every worker waits for its notification, so its files arrive in the order it sent them
+		next := make(map[int]int)
+
+		for _, file := range provider.files {
+			var worker, i int
+
+			if _, err := fmt.Sscanf(file, "%d/%d.mkv", &worker, &i); err != nil {
+				t.Fatal(err)
+			}
+
+			if i != next[worker] {
+				t.Errorf("%s provider got %s, want %d/%d.mkv", name, file, worker, next[worker])
+			}
+
+			next[worker] = i + 1
+		}
+	}
+}
+
+// The end notification of a file waits for all providers, so the next file is only notified after it
+func TestNotifyEndOrder(t *testing.T) {
+	slow := &recordingProvider{delay: 5 * time.Millisecond}
+	fast := &recordingProvider{}
+	useProviders(t, slow, fast)
+
+	for i := 0; i < 5; i++ {
+		job := &Job{
+			fileName: fmt.Sprintf("%d.mkv", i),
+			metadata: &models.FileMetadata{},
+		}
+
+		job.NotifyEnd(nil, nil, models.ResultReplaced)
+	}
+
+	for name, provider := range map[string]*recordingProvider{"slow": slow, "fast": fast} {
+		if fmt.Sprint(provider.files) != "[0.mkv 1.mkv 2.mkv 3.mkv 4.mkv]" {
+			t.Errorf("%s provider got %v", name, provider.files)
+		}
+	}
+}
+
+// Results filtered out by notify-on are not sent, but still reach the providers to end their jobs
+func TestNotifyEndFiltered(t *testing.T) {
+	provider := &recordingProvider{}
+	useProviders(t, provider)
+
+	results := map[string]models.Result{
+		"replaced.mkv":      models.ResultReplaced,
+		"keep-original.mkv": models.ResultKeepOriginal,
+		"error.mkv":         models.ResultError,
+	}
+
+	for _, fileName := range []string{"replaced.mkv", "keep-original.mkv", "error.mkv"} {
+		job := &Job{
+			fileName: fileName,
+			metadata: &models.FileMetadata{},
+		}
+
+		job.NotifyEnd(nil, nil, results[fileName])
+	}
+
+	if fmt.Sprint(provider.files) != "[replaced.mkv]" {
+		t.Errorf("sent %v, want [replaced.mkv]", provider.files)
+	}
+
+	if fmt.Sprint(provider.discarded) != "[keep-original.mkv error.mkv]" {
+		t.Errorf("discarded %v, want [keep-original.mkv error.mkv]", provider.discarded)
+	}
+}
@@ -0,0 +1,104 @@
+package notifications
+
+import (
+	"fmt"
+	"github.com/Vilsol/transcoder-go/models"
+	"github.com/Vilsol/transcoder-go/utils"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"time"
+)
+
+type slackMessage struct {
+	Text   string       `json:"text"`
+	Blocks []slackBlock `json:"blocks"`
+}
+
+type slackBlock struct {
+	Type   string      `json:"type"`
+	Text   *slackText  `json:"text,omitempty"`
+	Fields []slackText `json:"fields,omitempty"`
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+func init() {
+	initialize = append(initialize, func() {
+		if viper.GetString("slack-webhook") == "" {
+			return
+		}
+
+		log.Info("Slack webhook configured")
+
+		end = append(end, func(data *models.NotificationData, result models.Result) {
+			err := postJSON(viper.GetString("slack-webhook"), generateSlackMessage(data, result))
+
+			if err != nil {
+				log.Errorf("Error sending slack message: %s", err)
+			}
+		})
+
+		summary = append(summary, func(batchSummary *models.BatchSummary) {
+			err := postJSON(viper.GetString("slack-webhook"), generateSlackSummaryMessage(batchSummary))
+
+			if err != nil {
+				log.Errorf("Error sending slack message: %s", err)
+			}
+		})
+	})
+}
+
+func generateSlackMessage(data *models.NotificationData, result models.Result) slackMessage {
+	fields := []slackText{
+		{Type: "mrkdwn", Text: fmt.Sprintf("*Status:*\n%s", string(result))},
+	}
+
+	if result != models.ResultError {
+		fields = append(fields,
+			slackText{Type: "mrkdwn", Text: fmt.Sprintf("*Original Size:*\n%s", utils.BytesHumanReadable(int64(data.OriginalSize)))},
+			slackText{Type: "mrkdwn", Text: fmt.Sprintf("*New Size:*\n%s", utils.BytesHumanReadable(int64(data.CurrentSize)))},
+		)
+	}
+
+	if data.Reason != "" {
+		fields = append(fields, slackText{Type: "mrkdwn", Text: fmt.Sprintf("*Reason:*\n%s", data.Reason)})
+	}
+
+	return slackMessage{
+		Text: fmt.Sprintf("%s: %s", data.Title(), string(result)),
+		Blocks: []slackBlock{
+			{
+				Type: "section",
+				Text: &slackText{Type: "mrkdwn", Text: fmt.Sprintf("*%s*", data.Title())},
+			},
+			{
+				Type:   "section",
+				Fields: fields,
+			},
+		},
+	}
+}
+
+func generateSlackSummaryMessage(summary *models.BatchSummary) slackMessage {
+	return slackMessage{
+		Text: fmt.Sprintf("Transcoded %d files", summary.Files),
+		Blocks: []slackBlock{
+			{
+				Type: "section",
+				Text: &slackText{Type: "mrkdwn", Text: fmt.Sprintf("*Transcoded %d files in %s*", summary.Files, summary.Duration().Truncate(time.Second))},
+			},
+			{
+				Type: "section",
+				Fields: []slackText{
+					{Type: "mrkdwn", Text: fmt.Sprintf("*Replaced:*\n%d", summary.Replaced)},
+					{Type: "mrkdwn", Text: fmt.Sprintf("*Kept Original:*\n%d", summary.KeptOriginal)},
+					{Type: "mrkdwn", Text: fmt.Sprintf("*Errors:*\n%d", summary.Errors)},
+					{Type: "mrkdwn", Text: fmt.Sprintf("*Saved:*\n%s", utils.BytesHumanReadable(summary.BytesSaved))},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,197 @@
+package notifications
+
+import (
+	"encoding/json"
+	"github.com/Vilsol/transcoder-go/models"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Receives one webhook post and hands over its decoded body
+func startWebhookServer(t *testing.T) (*httptest.Server, <-chan map[string]interface{}) {
+	bodies := make(chan map[string]interface{}, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("expected a JSON post, got %s %s", r.Method, r.Header.Get("Content-Type"))
+		}
+
+		data, err := ioutil.ReadAll(r.Body)
+
+		if err != nil {
+			t.Error(err)
+		}
+
+		var body map[string]interface{}
+		if err := json.Unmarshal(data, &body); err != nil {
+			t.Errorf("invalid JSON %s: %s", data, err)
+		}
+
+		bodies <- body
+	}))
+
+	t.Cleanup(server.Close)
+
+	return server, bodies
+}
+
+// Texts of the section blocks, in order, the fields of a section joined by a bar
+func slackSections(t *testing.T, body map[string]interface{}) []string {
+	if _, ok := body["text"].(string); !ok {
+		t.Errorf("expected a fallback text, got %v", body["text"])
+	}
+
+	blocks, ok := body["blocks"].([]interface{})
+
+	if !ok {
+		t.Fatalf("expected blocks, got %v", body["blocks"])
+	}
+
+	sections := make([]string, 0)
+
+	for _, block := range blocks {
+		block := block.(map[string]interface{})
+
+		if block["type"] != "section" {
+			t.Errorf("expected a section block, got %v", block["type"])
+		}
+
+		texts := make([]interface{}, 0)
+
+		if text, ok := block["text"]; ok {
+			texts = append(texts, text)
+		}
+
+		if fields, ok := block["fields"].([]interface{}); ok {
+			texts = append(texts, fields...)
+		}
+
+		section := ""
+
+		for i, text := range texts {
+			text := text.(map[string]interface{})
+
+			if text["type"] != "mrkdwn" {
+				t.Errorf("expected mrkdwn text, got %v", text["type"])
+			}
+
+			if i > 0 {
+				section += "|"
+			}
+
+			section += text["text"].(string)
+		}
+
+		sections = append(sections, section)
+	}
+
+	return sections
+}
+
+func TestSlackMessage(t *testing.T) {
+	data := &models.NotificationData{
+		Filename:     "movie.mkv",
+		OriginalSize: 2000000000,
+		CurrentSize:  1000000000,
+		Index:        2,
+		Total:        3,
+	}
+
+	tests := []struct {
+		name     string
+		result   models.Result
+		reason   string
+		text     string
+		sections []string
+	}{
+		{
+			name:     "replaced",
+			result:   models.ResultReplaced,
+			text:     "movie.mkv (2/3): Replaced with new",
+			sections: []string{"*movie.mkv (2/3)*", "*Status:*\nReplaced with new|*Original Size:*\n2.0 GB|*New Size:*\n1.0 GB"},
+		},
+		{
+			name:     "error",
+			result:   models.ResultError,
+			reason:   "exit status 1",
+			text:     "movie.mkv (2/3): Error",
+			sections: []string{"*movie.mkv (2/3)*", "*Status:*\nError|*Reason:*\nexit status 1"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, bodies := startWebhookServer(t)
+
+			message := *data
+			message.Reason = test.reason
+
+			if err := postJSON(server.URL, generateSlackMessage(&message, test.result)); err != nil {
+				t.Fatal(err)
+			}
+
+			body := <-bodies
+
+			if body["text"] != test.text {
+				t.Errorf("expected text %q, got %q", test.text, body["text"])
+			}
+
+			sections := slackSections(t, body)
+
+			if len(sections) != len(test.sections) {
+				t.Fatalf("expected sections %q, got %q", test.sections, sections)
+			}
+
+			for i := range sections {
+				if sections[i] != test.sections[i] {
+					t.Errorf("expected section %q, got %q", test.sections[i], sections[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSlackSummaryMessage(t *testing.T) {
+	server, bodies := startWebhookServer(t)
+
+	started := time.Now().Add(-90 * time.Minute)
+	summary := &models.BatchSummary{
+		Started:      started,
+		Ended:        started.Add(90 * time.Minute),
+		Files:        3,
+		Replaced:     1,
+		KeptOriginal: 1,
+		Errors:       1,
+		BytesSaved:   1500000000,
+	}
+
+	if err := postJSON(server.URL, generateSlackSummaryMessage(summary)); err != nil {
+		t.Fatal(err)
+	}
+
+	body := <-bodies
+
+	if body["text"] != "Transcoded 3 files" {
+		t.Errorf("expected text %q, got %q", "Transcoded 3 files", body["text"])
+	}
+
+	expected := []string{
+		"*Transcoded 3 files in 1h30m0s*",
+		"*Replaced:*\n1|*Kept Original:*\n1|*Errors:*\n1|*Saved:*\n1.5 GB",
+	}
+
+	sections := slackSections(t, body)
+
+	if len(sections) != len(expected) {
+		t.Fatalf("expected sections %q, got %q", expected, sections)
+	}
+
+	for i := range sections {
+		if sections[i] != expected[i] {
+			t.Errorf("expected section %q, got %q", expected[i], sections[i])
+		}
+	}
+}
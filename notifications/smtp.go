@@ -0,0 +1,189 @@
+package notifications
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"github.com/Vilsol/transcoder-go/models"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"html"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Keeps a hung mail server from stalling the queue, covers connecting and the whole conversation
+var smtpTimeout = 30 * time.Second
+
+func init() {
+	initialize = append(initialize, func() {
+		if viper.GetString("smtp-host") == "" || len(viper.GetStringSlice("smtp-to")) == 0 {
+			return
+		}
+
+		switch viper.GetString("smtp-tls") {
+		case "none", "starttls", "tls":
+			break
+		default:
+			log.Fatalf("Invalid smtp-tls mode: %s", viper.GetString("smtp-tls"))
+		}
+
+		log.Infof("SMTP configured: %s", viper.GetString("smtp-host"))
+
+		end = append(end, func(data *models.NotificationData, result models.Result) {
+			err := sendMail(data.Title()+": "+string(result), generatePlainText(data, result))
+
+			if err != nil {
+				log.Errorf("Error sending email: %s", err)
+			}
+		})
+
+		summary = append(summary, func(batchSummary *models.BatchSummary) {
+			err := sendMail("Transcoding finished", generatePlainSummaryText(batchSummary))
+
+			if err != nil {
+				log.Errorf("Error sending email: %s", err)
+			}
+		})
+	})
+}
+
+func sendMail(subject string, body string) error {
+	host := viper.GetString("smtp-host")
+	address := net.JoinHostPort(host, strconv.Itoa(viper.GetInt("smtp-port")))
+	tlsConfig := &tls.Config{ServerName: host}
+
+	conn, err := net.DialTimeout("tcp", address, smtpTimeout)
+
+	if err != nil {
+		return err
+	}
+
+	if err := conn.SetDeadline(time.Now().Add(smtpTimeout)); err != nil {
+		_ = conn.Close()
+		return err
+	}
+
+	if viper.GetString("smtp-tls") == "tls" {
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	client, err := smtp.NewClient(conn, host)
+
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+
+	if viper.GetString("smtp-tls") == "starttls" {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			_ = client.Close()
+			return err
+		}
+	}
+
+	defer client.Close()
+
+	if viper.GetString("smtp-user") != "" {
+		err := client.Auth(smtp.PlainAuth("", viper.GetString("smtp-user"), viper.GetString("smtp-pass"), host))
+
+		if err != nil {
+			return err
+		}
+	}
+
+	from := viper.GetString("smtp-from")
+	if from == "" {
+		from = viper.GetString("smtp-user")
+	}
+
+	err = client.Mail(from)
+
+	if err != nil {
+		return err
+	}
+
+	to := viper.GetStringSlice("smtp-to")
+	for _, recipient := range to {
+		err = client.Rcpt(recipient)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	writer, err := client.Data()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = writer.Write([]byte(generateMail(from, to, subject, body)))
+
+	if err != nil {
+		return err
+	}
+
+	err = writer.Close()
+
+	if err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+func generateMail(from string, to []string, subject string, body string) string {
+	contentType := "text/plain; charset=UTF-8"
+
+	if viper.GetBool("smtp-html") {
+		contentType = "text/html; charset=UTF-8"
+		body = "<pre>" + html.EscapeString(body) + "</pre>"
+	}
+
+	return fmt.Sprintf(
+		"From: %s\r\n"+
+			"To: %s\r\n"+
+			"Subject: %s\r\n"+
+			"Date: %s\r\n"+
+			"Message-ID: %s\r\n"+
+			"MIME-Version: 1.0\r\n"+
+			"Content-Type: %s\r\n"+
+			"\r\n"+
+			"%s\r\n",
+		from,
+		strings.Join(to, ", "),
+		encodeSubject(subject),
+		time.Now().Format(time.RFC1123Z),
+		messageID(from),
+		contentType,
+		strings.ReplaceAll(body, "\n", "\r\n"),
+	)
+}
+
+// File names end up in the subject, a line break in one must not start a header of its own
+func encodeSubject(subject string) string {
+	subject = strings.Join(strings.FieldsFunc(subject, func(r rune) bool {
+		return r == '\r' || r == '\n'
+	}), " ")
+
+	return mime.QEncoding.Encode("UTF-8", subject)
+}
+
+// Unique id in the domain of the sender
+func messageID(from string) string {
+	random := make([]byte, 16)
+	_, _ = rand.Read(random)
+
+	domain := "localhost"
+	if at := strings.LastIndex(from, "@"); at >= 0 && at < len(from)-1 {
+		domain = strings.Trim(from[at+1:], "<> ")
+	}
+
+	return fmt.Sprintf("<%d.%s@%s>", time.Now().UnixNano(), hex.EncodeToString(random), domain)
+}
@@ -0,0 +1,226 @@
+package notifications
+
+import (
+	"bufio"
+	"github.com/spf13/viper"
+	"mime"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+// What the fake server received in one conversation
+type receivedMail struct {
+	from string
+	to   []string
+	data string
+}
+
+// Speaks just enough SMTP to take a single mail, without any extensions
+func startSMTPServer(t *testing.T) (int, <-chan receivedMail) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		listener.Close()
+	})
+
+	mails := make(chan receivedMail, 1)
+
+	go func() {
+		conn, err := listener.Accept()
+
+		if err != nil {
+			return
+		}
+
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		reply := func(line string) {
+			_, _ = conn.Write([]byte(line + "\r\n"))
+		}
+
+		var received receivedMail
+		reply("220 localhost ESMTP")
+
+		for {
+			line, err := reader.ReadString('\n')
+
+			if err != nil {
+				return
+			}
+
+			line = strings.TrimRight(line, "\r\n")
+			command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+
+			switch {
+			case command == "EHLO" || command == "HELO":
+				reply("250 localhost")
+			case strings.HasPrefix(strings.ToUpper(line), "MAIL FROM:"):
+				received.from = strings.Trim(line[len("MAIL FROM:"):], "<>")
+				reply("250 OK")
+			case strings.HasPrefix(strings.ToUpper(line), "RCPT TO:"):
+				received.to = append(received.to, strings.Trim(line[len("RCPT TO:"):], "<>"))
+				reply("250 OK")
+			case command == "DATA":
+				reply("354 Go ahead")
+
+				var data strings.Builder
+				for {
+					dataLine, err := reader.ReadString('\n')
+
+					if err != nil {
+						return
+					}
+
+					if dataLine == ".\r\n" {
+						break
+					}
+
+					data.WriteString(dataLine)
+				}
+
+				received.data = data.String()
+				reply("250 OK")
+			case command == "QUIT":
+				reply("221 Bye")
+				mails <- received
+				return
+			default:
+				reply("502 Not implemented")
+			}
+		}
+	}()
+
+	return listener.Addr().(*net.TCPAddr).Port, mails
+}
+
+func useSMTP(t *testing.T, port int) {
+	viper.Set("smtp-host", "127.0.0.1")
+	viper.Set("smtp-port", port)
+	viper.Set("smtp-tls", "none")
+	viper.Set("smtp-from", "transcoder@example.com")
+	viper.Set("smtp-to", []string{"first@example.com", "second@example.com"})
+
+	t.Cleanup(func() {
+		for _, key := range []string{"smtp-host", "smtp-port", "smtp-tls", "smtp-from", "smtp-to"} {
+			viper.Set(key, nil)
+		}
+	})
+}
+
+func TestSendMail(t *testing.T) {
+	port, mails := startSMTPServer(t)
+	useSMTP(t, port)
+
+	if err := sendMail("Show.S01E01.mkv\r\nBcc: victim@example.com: replaced", "Saved 1 GB\nin 10 minutes"); err != nil {
+		t.Fatal(err)
+	}
+
+	var received receivedMail
+	select {
+	case received = <-mails:
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not receive a mail")
+	}
+
+	if received.from != "transcoder@example.com" {
+		t.Errorf("expected sender transcoder@example.com, got %s", received.from)
+	}
+
+	if strings.Join(received.to, ",") != "first@example.com,second@example.com" {
+		t.Errorf("expected both recipients, got %v", received.to)
+	}
+
+	message, err := mail.ReadMessage(strings.NewReader(received.data))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bcc := message.Header.Get("Bcc"); bcc != "" {
+		t.Errorf("subject injected a Bcc header: %s", bcc)
+	}
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(message.Header.Get("Subject"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if subject != "Show.S01E01.mkv Bcc: victim@example.com: replaced" {
+		t.Errorf("unexpected subject: %q", subject)
+	}
+
+	if _, err := message.Header.Date(); err != nil {
+		t.Errorf("invalid Date header: %s", err)
+	}
+
+	if id := message.Header.Get("Message-ID"); !strings.HasPrefix(id, "<") || !strings.HasSuffix(id, "@example.com>") {
+		t.Errorf("invalid Message-ID header: %s", id)
+	}
+}
+
+func TestSendMailTimeout(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer listener.Close()
+
+	// Accepts but never greets
+	go func() {
+		conn, err := listener.Accept()
+
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(5 * time.Second)
+		}
+	}()
+
+	useSMTP(t, listener.Addr().(*net.TCPAddr).Port)
+
+	timeout := smtpTimeout
+	smtpTimeout = 100 * time.Millisecond
+
+	t.Cleanup(func() {
+		smtpTimeout = timeout
+	})
+
+	start := time.Now()
+
+	if err := sendMail("Subject", "Body"); err == nil {
+		t.Fatal("expected a timeout")
+	}
+
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected to give up after the timeout, took %s", elapsed)
+	}
+}
+
+func TestEncodeSubject(t *testing.T) {
+	tests := []struct {
+		subject  string
+		expected string
+	}{
+		{"Show.mkv: replaced", "Show.mkv: replaced"},
+		{"Show.mkv\r\nBcc: x@example.com", "Show.mkv Bcc: x@example.com"},
+		{"Amélie.mkv: replaced", "=?UTF-8?q?Am=C3=A9lie.mkv:_replaced?="},
+	}
+
+	for _, test := range tests {
+		t.Run(test.subject, func(t *testing.T) {
+			if encoded := encodeSubject(test.subject); encoded != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, encoded)
+			}
+		})
+	}
+}
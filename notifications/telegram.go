@@ -7,6 +7,8 @@ import (
 	"github.com/go-telegram-bot-api/telegram-bot-api"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -24,67 +26,280 @@ func init() {
 
 			log.Printf("Telegram connected: %s", tgBot.Self.UserName)
 
-			var currentMessage *tgbotapi.Message
+			go runTelegramQueue()
 
-			lastMessage := int64(0)
+			start = append(start, startTelegramJob)
+			progressStatus = append(progressStatus, progressTelegramJob)
+			end = append(end, endTelegramJob)
+			discard = append(discard, discardTelegramJob)
 
-			start = append(start, func(data *models.NotificationData) {
-				message := tgbotapi.NewMessage(viper.GetInt64("tg-chat-id"), generateTelegramMessageText(data, nil))
-				message.ParseMode = tgbotapi.ModeMarkdown
-				send, err := tgBot.Send(message)
+			summary = append(summary, func(batchSummary *models.BatchSummary) {
+				done := make(chan bool)
 
-				if err != nil {
-					log.Errorf("Error sending telegram message: %s", err)
-					return
-				}
+				// The process exits after the summary, so this one waits until it got sent
+				queueTelegram(func() {
+					defer close(done)
 
-				currentMessage = &send
-				lastMessage = time.Now().Unix()
-			})
-
-			progressStatus = append(progressStatus, func(data *models.NotificationData) {
-				// Rate-limit to 15 messages/min
-				if time.Now().Unix()-lastMessage < 4 {
-					return
-				}
-
-				if currentMessage != nil {
-					message := tgbotapi.NewEditMessageText(viper.GetInt64("tg-chat-id"), currentMessage.MessageID, generateTelegramMessageText(data, nil))
+					message := tgbotapi.NewMessage(viper.GetInt64("tg-chat-id"), generateTelegramSummaryText(batchSummary))
 					message.ParseMode = tgbotapi.ModeMarkdown
-					_, err := tgBot.Send(message)
+					_, err := sendTelegram(message)
 
 					if err != nil {
-						log.Errorf("Error editing telegram message: %s", err)
+						log.Errorf("Error sending telegram message: %s", err)
 					}
+				})
 
-					lastMessage = time.Now().Unix()
-				}
+				<-done
 			})
+		}
+	})
+}
 
-			end = append(end, func(data *models.NotificationData, result models.Result) {
-				if currentMessage != nil {
-					message := tgbotapi.NewEditMessageText(viper.GetInt64("tg-chat-id"), currentMessage.MessageID, generateTelegramMessageText(data, &result))
-					message.ParseMode = tgbotapi.ModeMarkdown
-					_, err := tgBot.Send(message)
+// Each file in progress keeps editing its own message
+type telegramJob struct {
+	messageID   int
+	lastMessage int64
+}
 
-					if err != nil {
-						log.Errorf("Error editing telegram message: %s", err)
-					}
+var telegramJobs = make(map[string]*telegramJob)
+var telegramJobsLock sync.Mutex
 
-					lastMessage = time.Now().Unix()
-				}
-			})
+func startTelegramJob(data *models.NotificationData) {
+	text := generateTelegramStartText(data)
+
+	// The job exists right away, so progress and end wait for the message instead of getting lost
+	job := &telegramJob{lastMessage: time.Now().Unix()}
+	telegramJobsLock.Lock()
+	telegramJobs[data.Path] = job
+	telegramJobsLock.Unlock()
+
+	queueTelegram(func() {
+		message := tgbotapi.NewMessage(viper.GetInt64("tg-chat-id"), text)
+		message.ParseMode = tgbotapi.ModeMarkdown
+		send, err := sendTelegram(message)
+
+		if err != nil {
+			log.Errorf("Error sending telegram message: %s", err)
+			return
+		}
+
+		telegramJobsLock.Lock()
+		job.messageID = send.MessageID
+		telegramJobsLock.Unlock()
+	})
+}
+
+func progressTelegramJob(data *models.NotificationData) {
+	telegramJobsLock.Lock()
+	defer telegramJobsLock.Unlock()
+
+	job, ok := telegramJobs[data.Path]
+
+	if !ok {
+		return
+	}
+
+	// Edit every interval, but never more than 15 messages/min
+	interval := int64(viper.GetInt("interval"))
+	if interval < 4 {
+		interval = 4
+	}
+
+	if time.Now().Unix()-job.lastMessage < interval {
+		return
+	}
+
+	job.lastMessage = time.Now().Unix()
+
+	// Progress is superseded by the next update anyway, so it is dropped while rate limited
+	if telegramRateLimited() {
+		return
+	}
+
+	text := generateTelegramMessageText(data, nil)
+	tryQueueTelegram(func() {
+		telegramJobsLock.Lock()
+		messageID := job.messageID
+		telegramJobsLock.Unlock()
+
+		if messageID != 0 {
+			editTelegramMessage(messageID, text)
+		}
+	})
+}
+
+// Removes the job of the file, nil if there is none
+func takeTelegramJob(data *models.NotificationData) *telegramJob {
+	telegramJobsLock.Lock()
+	defer telegramJobsLock.Unlock()
+
+	job := telegramJobs[data.Path]
+	delete(telegramJobs, data.Path)
+
+	return job
+}
+
+func endTelegramJob(data *models.NotificationData, result models.Result) {
+	job := takeTelegramJob(data)
+
+	if job == nil {
+		return
+	}
+
+	text := generateTelegramMessageText(data, &result)
+	thumbnail := data.Thumbnail
+	queueTelegram(func() {
+		telegramJobsLock.Lock()
+		messageID := job.messageID
+		telegramJobsLock.Unlock()
+
+		if thumbnail != nil {
+			sendTelegramPhoto(messageID, text, thumbnail)
+		} else if messageID != 0 {
+			editTelegramMessage(messageID, text)
+		}
+	})
+}
+
+// The start message still shows the progress, edits do not notify so it gets the result anyway
+func discardTelegramJob(data *models.NotificationData, result models.Result) {
+	job := takeTelegramJob(data)
+
+	if job == nil {
+		return
+	}
+
+	text := generateTelegramMessageText(data, &result)
+	queueTelegram(func() {
+		telegramJobsLock.Lock()
+		messageID := job.messageID
+		telegramJobsLock.Unlock()
+
+		if messageID != 0 {
+			editTelegramMessage(messageID, text)
 		}
 	})
 }
 
+// Sends happen in order on a single goroutine, so waiting out a rate limit never holds up a transcode
+var telegramQueue = make(chan func(), 100)
+
+// Nothing gets sent before this, Telegram rejects every request until the retry_after passed
+var telegramBlockedUntil time.Time
+var telegramBlockedLock sync.Mutex
+
+// Rate limited sends are retried at most this often before they are dropped
+const telegramMaxRetries = 5
+
+func runTelegramQueue() {
+	for task := range telegramQueue {
+		task()
+	}
+}
+
+func queueTelegram(task func()) {
+	telegramQueue <- task
+}
+
+// Queues the task unless the queue is backed up
+func tryQueueTelegram(task func()) {
+	select {
+	case telegramQueue <- task:
+	default:
+	}
+}
+
+func telegramRateLimited() bool {
+	telegramBlockedLock.Lock()
+	defer telegramBlockedLock.Unlock()
+
+	return time.Now().Before(telegramBlockedUntil)
+}
+
+// Sends the message, waiting out the retry_after of 429 responses
+func sendTelegram(message tgbotapi.Chattable) (tgbotapi.Message, error) {
+	for attempt := 0; ; attempt++ {
+		telegramBlockedLock.Lock()
+		wait := time.Until(telegramBlockedUntil)
+		telegramBlockedLock.Unlock()
+
+		if wait > 0 {
+			time.Sleep(wait)
+		}
+
+		send, err := tgBot.Send(message)
+
+		apiErr, ok := err.(tgbotapi.Error)
+
+		if !ok || apiErr.RetryAfter <= 0 || attempt >= telegramMaxRetries {
+			return send, err
+		}
+
+		retryAfter := time.Duration(apiErr.RetryAfter) * time.Second
+		log.Warningf("Telegram rate limited, retrying in %s", retryAfter)
+
+		telegramBlockedLock.Lock()
+		telegramBlockedUntil = time.Now().Add(retryAfter)
+		telegramBlockedLock.Unlock()
+	}
+}
+
+func editTelegramMessage(messageID int, text string) {
+	message := tgbotapi.NewEditMessageText(viper.GetInt64("tg-chat-id"), messageID, text)
+	message.ParseMode = tgbotapi.ModeMarkdown
+	_, err := sendTelegram(message)
+
+	// Telegram rejects edits that do not change the content
+	if err != nil && !strings.Contains(err.Error(), "message is not modified") {
+		log.Errorf("Error editing telegram message: %s", err)
+	}
+}
+
+// Replaces the progress message with the thumbnail, captioned with the end text
+func sendTelegramPhoto(messageID int, caption string, thumbnail []byte) {
+	photo := tgbotapi.NewPhotoUpload(viper.GetInt64("tg-chat-id"), tgbotapi.FileBytes{Name: "thumbnail.jpg", Bytes: thumbnail})
+	photo.Caption = caption
+	photo.ParseMode = tgbotapi.ModeMarkdown
+
+	if _, err := sendTelegram(photo); err != nil {
+		log.Errorf("Error sending telegram photo: %s", err)
+
+		if messageID != 0 {
+			editTelegramMessage(messageID, caption)
+		}
+
+		return
+	}
+
+	if messageID != 0 {
+		if _, err := sendTelegram(tgbotapi.NewDeleteMessage(viper.GetInt64("tg-chat-id"), messageID)); err != nil {
+			log.Errorf("Error deleting telegram message: %s", err)
+		}
+	}
+}
+
+func generateTelegramStartText(data *models.NotificationData) string {
+	return fmt.Sprintf(
+		"Started transcoding *%s* (size %s, duration %s)",
+		data.Title(),
+		utils.BytesHumanReadable(int64(data.OriginalSize)),
+		data.Duration.Truncate(time.Second),
+	)
+}
+
 func generateTelegramMessageText(data *models.NotificationData, result *models.Result) string {
+	reason := ""
+	if data.Reason != "" {
+		reason = "\n*Reason:* " + data.Reason
+	}
+
 	if result != nil && *result == models.ResultError {
 		return fmt.Sprintf(
 			"*%s*"+
-				"\n*Status:* %s",
-			data.Filename,
+				"\n*Status:* %s%s",
+			data.Title(),
 			string(*result),
+			reason,
 		)
 	}
 
@@ -94,10 +309,11 @@ func generateTelegramMessageText(data *models.NotificationData, result *models.R
 		return fmt.Sprintf(
 			"*%s*"+
 				"\n*Size:* %s --> %s (%.2f%%)"+
-				"\n*Status:* %s",
-			data.Filename,
+				"\n*Status:* %s%s",
+			data.Title(),
 			utils.BytesHumanReadable(int64(data.OriginalSize)), utils.BytesHumanReadable(int64(data.CurrentSize)), diff,
 			string(*result),
+			reason,
 		)
 	}
 
@@ -110,6 +326,10 @@ func generateTelegramMessageText(data *models.NotificationData, result *models.R
 		eta = time.Duration((float64(time.Now().Sub(data.Started)) / complete) * (100 - complete))
 	}
 
+	if data.ETA > 0 {
+		eta = data.ETA
+	}
+
 	return fmt.Sprintf(
 		"*%s*"+
 			"\n*Size:* %s --> %s (%.2f%%)"+
@@ -117,7 +337,7 @@ func generateTelegramMessageText(data *models.NotificationData, result *models.R
 			"\n*Expected Size:* %s"+
 			"\n*ETA:* %s"+
 			"\n*FPS:* %.2f",
-		data.Filename,
+		data.Title(),
 		utils.BytesHumanReadable(int64(data.OriginalSize)), utils.BytesHumanReadable(int64(data.CurrentSize)), diff,
 		complete,
 		expected,
@@ -125,3 +345,18 @@ func generateTelegramMessageText(data *models.NotificationData, result *models.R
 		data.FPS,
 	)
 }
+
+func generateTelegramSummaryText(summary *models.BatchSummary) string {
+	return fmt.Sprintf(
+		"*Transcoded %d files in %s*"+
+			"\n*Replaced:* %d"+
+			"\n*Kept original:* %d"+
+			"\n*Errors:* %d"+
+			"\n*Saved:* %s",
+		summary.Files, summary.Duration().Truncate(time.Second),
+		summary.Replaced,
+		summary.KeptOriginal,
+		summary.Errors,
+		utils.BytesHumanReadable(summary.BytesSaved),
+	)
+}
@@ -0,0 +1,177 @@
+package notifications
+
+import (
+	"github.com/Vilsol/transcoder-go/models"
+	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/spf13/viper"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Sends every request to the test server, the API endpoint of the bot can not be changed
+type rewriteTransport struct {
+	target *url.URL
+}
+
+func (transport rewriteTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	request.URL.Scheme = transport.target.Scheme
+	request.URL.Host = transport.target.Host
+
+	return http.DefaultTransport.RoundTrip(request)
+}
+
+// Answers the requests to the method with the responses in order, the last one repeats
+func useTelegramServer(t *testing.T, method string, responses ...func(w http.ResponseWriter)) *[]time.Time {
+	var lock sync.Mutex
+	requests := make([]time.Time, 0)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		requests = append(requests, time.Now())
+		index := len(requests) - 1
+		lock.Unlock()
+
+		if r.URL.Path != "/bottoken/"+method {
+			t.Errorf("unexpected request to %s", r.URL.Path)
+		}
+
+		if index >= len(responses) {
+			index = len(responses) - 1
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		responses[index](w)
+	}))
+
+	target, _ := url.Parse(server.URL)
+	tgBot = &tgbotapi.BotAPI{
+		Token:  "token",
+		Client: &http.Client{Transport: rewriteTransport{target: target}},
+	}
+
+	t.Cleanup(func() {
+		server.Close()
+		tgBot = nil
+
+		telegramBlockedLock.Lock()
+		telegramBlockedUntil = time.Time{}
+		telegramBlockedLock.Unlock()
+	})
+
+	return &requests
+}
+
+func rateLimited(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusTooManyRequests)
+	_, _ = w.Write([]byte(`{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 1","parameters":{"retry_after":1}}`))
+}
+
+func sent(w http.ResponseWriter) {
+	_, _ = w.Write([]byte(`{"ok":true,"result":{"message_id":42,"date":0,"chat":{"id":1,"type":"private"},"text":"done"}}`))
+}
+
+func badRequest(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusBadRequest)
+	_, _ = w.Write([]byte(`{"ok":false,"error_code":400,"description":"Bad Request: chat not found"}`))
+}
+
+func TestSendTelegramWaitsOutRetryAfter(t *testing.T) {
+	requests := useTelegramServer(t, "sendMessage", rateLimited, sent)
+
+	message, err := sendTelegram(tgbotapi.NewMessage(1, "done"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if message.MessageID != 42 {
+		t.Errorf("message id = %d, want 42", message.MessageID)
+	}
+
+	if len(*requests) != 2 {
+		t.Fatalf("got %d requests, want 2", len(*requests))
+	}
+
+	if wait := (*requests)[1].Sub((*requests)[0]); wait < time.Second {
+		t.Errorf("retried after %s, want at least the retry_after of 1s", wait)
+	}
+}
+
+// A 429 marks Telegram as rate limited, so progress updates get dropped until the retry_after passed
+func TestTelegramRateLimited(t *testing.T) {
+	useTelegramServer(t, "sendMessage", rateLimited, sent)
+
+	done := make(chan error)
+
+	go func() {
+		_, err := sendTelegram(tgbotapi.NewMessage(1, "done"))
+		done <- err
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for !telegramRateLimited() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if !telegramRateLimited() {
+		t.Error("not rate limited after a 429")
+	}
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSendTelegramDoesNotRetryOtherErrors(t *testing.T) {
+	requests := useTelegramServer(t, "sendMessage", badRequest, sent)
+
+	if _, err := sendTelegram(tgbotapi.NewMessage(1, "done")); err == nil {
+		t.Fatal("expected the error of the bad request")
+	}
+
+	if len(*requests) != 1 {
+		t.Errorf("got %d requests, want 1", len(*requests))
+	}
+}
+
+// A result filtered out by notify-on still ends the job, its start message gets the result without a new message
+func TestDiscardTelegramJob(t *testing.T) {
+	requests := useTelegramServer(t, "editMessageText", sent)
+
+	viper.Set("tg-chat-id", 1)
+	t.Cleanup(func() {
+		viper.Set("tg-chat-id", nil)
+	})
+
+	data := &models.NotificationData{Path: "/media/movie.mkv", Filename: "movie.mkv"}
+
+	telegramJobsLock.Lock()
+	telegramJobs[data.Path] = &telegramJob{messageID: 42}
+	telegramJobsLock.Unlock()
+
+	discardTelegramJob(data, models.ResultKeepOriginal)
+
+	telegramJobsLock.Lock()
+	_, ok := telegramJobs[data.Path]
+	telegramJobsLock.Unlock()
+
+	if ok {
+		t.Error("job still tracked after it was discarded")
+	}
+
+	// Runs the queued edit here instead of on the queue goroutine
+	select {
+	case task := <-telegramQueue:
+		task()
+	default:
+		t.Fatal("expected the start message to be edited")
+	}
+
+	if len(*requests) != 1 {
+		t.Errorf("got %d requests, want 1", len(*requests))
+	}
+}
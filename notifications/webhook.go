@@ -0,0 +1,118 @@
+package notifications
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/Vilsol/transcoder-go/models"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"net/http"
+	"text/template"
+)
+
+const defaultWebhookTemplate = `{"file": {{json .File}}, "result": {{json .Result}}, "old_size": {{.OldSize}}, "new_size": {{.NewSize}}, "savings": {{.Savings}}, "reason": {{json .Reason}}}`
+
+type webhookData struct {
+	File    string
+	Result  models.Result
+	OldSize int64
+	// Size of the transcode, 0 if there is none
+	NewSize int64
+	// Bytes saved, negative if the transcode is bigger
+	Savings int64
+	Reason  string
+	// Length of the media in seconds
+	Duration float64
+}
+
+var webhookTemplate *template.Template
+
+func init() {
+	initialize = append(initialize, func() {
+		if viper.GetString("webhook-url") == "" {
+			return
+		}
+
+		tmpl, err := parseWebhookTemplate(viper.GetString("webhook-template"))
+
+		if err != nil {
+			log.Fatalf("Invalid webhook template: %s", err)
+		}
+
+		webhookTemplate = tmpl
+
+		log.Infof("Webhook configured: %s", viper.GetString("webhook-url"))
+
+		end = append(end, func(data *models.NotificationData, result models.Result) {
+			body, err := renderWebhook(webhookTemplate, newWebhookData(data, result))
+
+			if err != nil {
+				log.Errorf("Error rendering webhook: %s", err)
+				return
+			}
+
+			if err := sendBody(http.MethodPost, viper.GetString("webhook-url"), body, nil); err != nil {
+				log.Errorf("Error sending webhook: %s", err)
+			}
+		})
+	})
+}
+
+func newWebhookData(data *models.NotificationData, result models.Result) webhookData {
+	webhook := webhookData{
+		File:     data.Path,
+		Result:   result,
+		OldSize:  int64(data.OriginalSize),
+		Reason:   data.Reason,
+		Duration: data.Duration.Seconds(),
+	}
+
+	// The size of a failed or interrupted transcode is only how far it got
+	if result != models.ResultError && data.CurrentSize > 0 {
+		webhook.NewSize = int64(data.CurrentSize)
+		webhook.Savings = int64(data.OriginalSize - data.CurrentSize)
+	}
+
+	return webhook
+}
+
+// Parses the template and renders it once, so a broken template fails before any file is transcoded
+func parseWebhookTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		text = defaultWebhookTemplate
+	}
+
+	tmpl, err := template.New("webhook").Funcs(template.FuncMap{
+		"json": func(value interface{}) (string, error) {
+			encoded, err := json.Marshal(value)
+			return string(encoded), err
+		},
+	}).Parse(text)
+
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = renderWebhook(tmpl, webhookData{File: "example.mkv", Result: models.ResultReplaced})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return tmpl, nil
+}
+
+func renderWebhook(tmpl *template.Template, data webhookData) ([]byte, error) {
+	var buffer bytes.Buffer
+
+	if err := tmpl.Execute(&buffer, data); err != nil {
+		return nil, err
+	}
+
+	if !json.Valid(buffer.Bytes()) {
+		return nil, errors.New("template did not render valid JSON")
+	}
+
+	return buffer.Bytes(), nil
+}
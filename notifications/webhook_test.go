@@ -0,0 +1,91 @@
+package notifications
+
+import (
+	"github.com/Vilsol/transcoder-go/models"
+	"testing"
+	"time"
+)
+
+func TestWebhookBody(t *testing.T) {
+	tests := []struct {
+		name        string
+		result      models.Result
+		currentSize int
+		reason      string
+		body        string
+	}{
+		{
+			name:        "replaced",
+			result:      models.ResultReplaced,
+			currentSize: 600,
+			body:        `{"file": "/media/movie.mkv", "result": "Replaced with new", "old_size": 1000, "new_size": 600, "savings": 400, "reason": ""}`,
+		},
+		{
+			name:        "kept original",
+			result:      models.ResultKeepOriginal,
+			currentSize: 1200,
+			reason:      "transcode is bigger",
+			body:        `{"file": "/media/movie.mkv", "result": "Kept original", "old_size": 1000, "new_size": 1200, "savings": -200, "reason": "transcode is bigger"}`,
+		},
+		{
+			name:        "error",
+			result:      models.ResultError,
+			currentSize: 300,
+			reason:      "exit status 1",
+			body:        `{"file": "/media/movie.mkv", "result": "Error", "old_size": 1000, "new_size": 0, "savings": 0, "reason": "exit status 1"}`,
+		},
+		{
+			name:   "no transcode",
+			result: models.ResultKeepOriginal,
+			reason: "already hevc",
+			body:   `{"file": "/media/movie.mkv", "result": "Kept original", "old_size": 1000, "new_size": 0, "savings": 0, "reason": "already hevc"}`,
+		},
+	}
+
+	tmpl, err := parseWebhookTemplate("")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := &models.NotificationData{
+				Path:         "/media/movie.mkv",
+				OriginalSize: 1000,
+				CurrentSize:  test.currentSize,
+				Reason:       test.reason,
+				Duration:     90 * time.Second,
+			}
+
+			body, err := renderWebhook(tmpl, newWebhookData(data, test.result))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(body) != test.body {
+				t.Errorf("body = %s, want %s", body, test.body)
+			}
+		})
+	}
+}
+
+func TestWebhookDuration(t *testing.T) {
+	tmpl, err := parseWebhookTemplate(`{"duration": {{.Duration}}}`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := &models.NotificationData{Path: "/media/movie.mkv", Duration: 90 * time.Second}
+	body, err := renderWebhook(tmpl, newWebhookData(data, models.ResultError))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(body) != `{"duration": 90}` {
+		t.Errorf("body = %s, want the media length of 90 seconds", body)
+	}
+}
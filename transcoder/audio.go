@@ -0,0 +1,150 @@
+package transcoder
+
+import (
+	"github.com/Vilsol/transcoder-go/config"
+	"github.com/Vilsol/transcoder-go/models"
+	log "github.com/sirupsen/logrus"
+	"strconv"
+)
+
+// Audio codecs that can be stored in matroska as they are
+var copyableAudioCodecs = map[string]bool{
+	"aac":    true,
+	"ac3":    true,
+	"eac3":   true,
+	"dts":    true,
+	"flac":   true,
+	"mp3":    true,
+	"opus":   true,
+	"truehd": true,
+	"vorbis": true,
+}
+
+var audioEncoders = map[string]string{
+	"aac":  "aac",
+	"opus": "libopus",
+}
+
+// Removes audio codec flags so they can be decided per stream
+func stripAudioFlags(flags []string) []string {
+	result := make([]string, 0, len(flags))
+
+	for i := 0; i < len(flags); i++ {
+		switch flags[i] {
+		case "-c:a", "-acodec", "-b:a":
+			i++
+			continue
+		}
+
+		result = append(result, flags[i])
+	}
+
+	return result
+}
+
+// Returns the indexes of the audio streams to keep, all of them unless keep-audio-langs is set.
+// Nothing is dropped if no stream matches, so a file never ends up without audio.
+func keptAudioStreams(fileName string, metadata *models.FileMetadata) []int {
+	streams := metadata.AudioStreams()
+
+	languages := make(map[string]bool)
+	for _, language := range config.GetStringSlice(fileName, "keep-audio-langs") {
+		languages[models.NormalizeLanguage(language)] = true
+	}
+
+	kept := make([]int, 0, len(streams))
+
+	for i, stream := range streams {
+		if len(languages) == 0 || languages[stream.LanguageCode()] {
+			kept = append(kept, i)
+		} else if stream.LanguageCode() == "" && !config.GetBool(fileName, "drop-untagged") {
+			kept = append(kept, i)
+		}
+	}
+
+	if len(kept) == 0 && len(streams) > 0 {
+		log.Warningf("No audio stream matches keep-audio-langs, keeping all of them: %s", fileName)
+
+		for i := range streams {
+			kept = append(kept, i)
+		}
+	}
+
+	return kept
+}
+
+// Returns negative maps for the audio streams that are not kept, they take them out of the -map of the base flags
+func buildAudioMapFlags(fileName string, metadata *models.FileMetadata) []string {
+	if metadata == nil {
+		return []string{}
+	}
+
+	streams := metadata.AudioStreams()
+	kept := keptAudioStreams(fileName, metadata)
+
+	flags := make([]string, 0)
+
+	for i, stream := range streams {
+		if len(kept) > 0 && kept[0] == i {
+			kept = kept[1:]
+
+			if len(config.GetStringSlice(fileName, "keep-audio-langs")) > 0 {
+				log.Infof("Keeping audio stream %d (%s): %s", i, languageName(stream.Language()), fileName)
+			}
+
+			continue
+		}
+
+		log.Infof("Dropping audio stream %d (%s): %s", i, languageName(stream.Language()), fileName)
+		flags = append(flags, "-map", "-0:a:"+strconv.Itoa(i))
+	}
+
+	return flags
+}
+
+func languageName(language string) string {
+	if language == "" {
+		return "untagged"
+	}
+
+	return language
+}
+
+// Returns the audio flags for every audio stream, nil if the base flags decide
+func buildAudioFlags(fileName string, metadata *models.FileMetadata) []string {
+	mode := config.GetString(fileName, "audio-mode")
+
+	if mode == "" || metadata == nil {
+		return nil
+	}
+
+	container := OutputContainer()
+
+	// Copy falls back to re-encoding codecs the container can not hold
+	encoder := container.audioFallback
+	if mode != "copy" {
+		encoder = audioEncoders[mode]
+	}
+
+	streams := metadata.AudioStreams()
+
+	flags := make([]string, 0)
+
+	// Dropped streams shift the output indexes the per stream flags refer to
+	for output, i := range keptAudioStreams(fileName, metadata) {
+		stream := streams[i]
+		index := strconv.Itoa(output)
+
+		// Filtered streams can not be copied
+		if mode == "copy" && container.audioCodecs[stream.CodecName] && !config.GetBool(fileName, "loudnorm") {
+			log.Infof("Copying audio stream %d (%s): %s", i, stream.CodecName, fileName)
+			flags = append(flags, "-c:a:"+index, "copy")
+			continue
+		}
+
+		log.Infof("Encoding audio stream %d (%s) with %s: %s", i, stream.CodecName, encoder, fileName)
+		flags = append(flags, "-c:a:"+index, encoder, "-b:a:"+index, config.GetString(fileName, "audio-bitrate"))
+	}
+
+	return flags
+}
@@ -0,0 +1,43 @@
+package transcoder
+
+import (
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"strings"
+)
+
+// The configured ffmpeg, the one in the PATH without a config
+func FFmpegBinary() string {
+	if path := viper.GetString("ffmpeg-path"); path != "" {
+		return path
+	}
+
+	return "ffmpeg"
+}
+
+func FFprobeBinary() string {
+	if path := viper.GetString("ffprobe-path"); path != "" {
+		return path
+	}
+
+	return "ffprobe"
+}
+
+// Fails if ffmpeg or ffprobe can not be executed
+func CheckBinaries() error {
+	for _, binary := range []string{FFmpegBinary(), FFprobeBinary()} {
+		output, err := executor.Command(context.Background(), binary, "-version").Output()
+
+		if err != nil {
+			return fmt.Errorf("failed running %s: %s", binary, err)
+		}
+
+		version := strings.SplitN(string(output), "\n", 2)[0]
+
+		log.Infof("Found %s: %s", binary, version)
+	}
+
+	return nil
+}
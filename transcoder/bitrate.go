@@ -0,0 +1,75 @@
+package transcoder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Vilsol/transcoder-go/models"
+	log "github.com/sirupsen/logrus"
+	"strconv"
+	"strings"
+)
+
+// Relative positions in the file where the video bitrate gets sampled
+var bitrateSamplePoints = []float64{0.1, 0.3, 0.5, 0.7, 0.9}
+
+const bitrateSampleSeconds = 10
+
+type packet struct {
+	Size         string `json:"size"`
+	DurationTime string `json:"duration_time"`
+}
+
+// Samples the video packets at several offsets and returns the average bitrate in bits per second.
+// The container bitrate includes audio and is often missing, so the packets are measured directly.
+func SampleVideoBitrate(fileName string, metadata *models.FileMetadata) (int64, error) {
+	duration, _ := strconv.ParseFloat(metadata.Format.Duration, 64)
+
+	intervals := make([]string, 0, len(bitrateSamplePoints))
+	for _, point := range bitrateSamplePoints {
+		intervals = append(intervals, fmt.Sprintf("%.2f%%+%d", duration*point, bitrateSampleSeconds))
+	}
+
+	params := []string{
+		"-v", "quiet",
+		"-print_format", "json",
+		"-select_streams", "v:0",
+		"-read_intervals", strings.Join(intervals, ","),
+		"-show_entries", "packet=size,duration_time",
+		fileName,
+	}
+
+	log.Tracef("Executing %s %s", FFprobeBinary(), strings.Join(params, " "))
+
+	output, err := executor.Command(context.Background(), FFprobeBinary(), params...).Output()
+
+	if err != nil {
+		return 0, fmt.Errorf("ffprobe exited: %s", err)
+	}
+
+	var result struct {
+		Packets []packet `json:"packets"`
+	}
+
+	if err := json.Unmarshal(output, &result); err != nil {
+		return 0, fmt.Errorf("failed parsing ffprobe output: %s", err)
+	}
+
+	bytes := int64(0)
+	seconds := 0.0
+
+	for _, p := range result.Packets {
+		size, _ := strconv.ParseInt(p.Size, 10, 64)
+		packetDuration, _ := strconv.ParseFloat(p.DurationTime, 64)
+
+		bytes += size
+		seconds += packetDuration
+	}
+
+	if seconds <= 0 {
+		return 0, errors.New("no packet durations reported")
+	}
+
+	return int64(float64(bytes*8) / seconds), nil
+}
@@ -0,0 +1,47 @@
+package transcoder
+
+import (
+	"github.com/Vilsol/transcoder-go/config"
+	"github.com/Vilsol/transcoder-go/models"
+	"github.com/spf13/viper"
+)
+
+// Returns the flags mapping chapters and global metadata from the input, and the flags of the mp4 muxer.
+// Generated chapters come from the second input.
+func buildMetadataFlags(fileName string, metadata *models.FileMetadata, container string, generatedChapters bool) []string {
+	flags := make([]string, 0)
+
+	if generatedChapters {
+		flags = append(flags, "-map_chapters", "1")
+	} else if !config.GetBool(fileName, "copy-chapters") {
+		flags = append(flags, "-map_chapters", "-1")
+	} else if metadata != nil && len(metadata.Chapters) > 0 {
+		// The muxer converts the chapters, e.g. Matroska chapters to QuickTime chapters for mp4
+		flags = append(flags, "-map_chapters", "0")
+	}
+
+	// Only the last -movflags counts, so they are collected into one
+	movFlags := ""
+
+	if !config.GetBool(fileName, "copy-metadata") {
+		flags = append(flags, "-map_metadata", "-1")
+	} else if metadata != nil && len(metadata.Format.Tags) > 0 {
+		flags = append(flags, "-map_metadata", "0")
+
+		// The mp4 muxer drops every tag it does not know about otherwise
+		if container == "mp4" {
+			movFlags += "+use_metadata_tags"
+		}
+	}
+
+	// Moves the index to the front, so playback over HTTP can start before the whole file is downloaded
+	if container == "mp4" && viper.GetBool("faststart") {
+		movFlags += "+faststart"
+	}
+
+	if movFlags != "" {
+		flags = append(flags, "-movflags", movFlags)
+	}
+
+	return flags
+}
@@ -0,0 +1,122 @@
+package transcoder
+
+import (
+	"fmt"
+	"github.com/spf13/viper"
+	"strings"
+)
+
+type Container struct {
+	// Name of the ffmpeg muxer
+	Format    string
+	Extension string
+	// Base flags used instead of the built-in defaults
+	defaultFlags string
+	// Prefixes of the encoders the container can hold, nil if it takes any
+	videoEncoders []string
+	audioEncoders []string
+	// Audio codecs that can be copied into the container, nil if it takes any
+	audioCodecs map[string]bool
+	// Encoder used when audio can not be copied
+	audioFallback string
+}
+
+var containers = map[string]Container{
+	"mkv": {
+		Format:        "matroska",
+		Extension:     ".mkv",
+		audioCodecs:   copyableAudioCodecs,
+		audioFallback: "aac",
+	},
+	"mp4": {
+		Format:        "mp4",
+		Extension:     ".mp4",
+		videoEncoders: []string{"copy", "libx265", "libx264", "hevc_", "h264_", "libaom-av1", "libsvtav1", "av1_"},
+		audioEncoders: []string{"copy", "aac", "libfdk_aac", "ac3", "eac3", "libmp3lame", "libopus", "alac"},
+		audioCodecs: map[string]bool{
+			"aac":  true,
+			"ac3":  true,
+			"eac3": true,
+			"mp3":  true,
+			"opus": true,
+			"alac": true,
+		},
+		audioFallback: "aac",
+	},
+	"webm": {
+		Format:        "webm",
+		Extension:     ".webm",
+		defaultFlags:  "-map 0 -c:v libvpx-vp9 -crf 32 -b:v 0 -c:a libopus -b:a 128k",
+		videoEncoders: []string{"copy", "libvpx", "libaom-av1", "libsvtav1", "av1_"},
+		audioEncoders: []string{"copy", "libopus", "libvorbis"},
+		audioCodecs: map[string]bool{
+			"opus":   true,
+			"vorbis": true,
+		},
+		audioFallback: "libopus",
+	},
+}
+
+func OutputContainer() Container {
+	if container, ok := containers[viper.GetString("container")]; ok {
+		return container
+	}
+
+	return containers["mkv"]
+}
+
+func OutputExtension() string {
+	return OutputContainer().Extension
+}
+
+// Fails if the configured encoders can not be stored in the output container
+func CheckContainer() error {
+	name := viper.GetString("container")
+
+	container, ok := containers[name]
+
+	if !ok {
+		return fmt.Errorf("unknown container: %s", name)
+	}
+
+	for _, baseFlags := range AllBaseFlags() {
+		split, err := splitFlags(baseFlags)
+
+		if err != nil {
+			return err
+		}
+
+		flags := applyHardwareAcceleration(split)
+
+		for i := 0; i < len(flags)-1; i++ {
+			switch flags[i] {
+			case "-c:v", "-vcodec":
+				if !supportsEncoder(container.videoEncoders, flags[i+1]) {
+					return fmt.Errorf("%s does not support video encoder %s", name, flags[i+1])
+				}
+				break
+			case "-c:a", "-acodec":
+				if !supportsEncoder(container.audioEncoders, flags[i+1]) {
+					return fmt.Errorf("%s does not support audio encoder %s", name, flags[i+1])
+				}
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+func supportsEncoder(encoders []string, encoder string) bool {
+	if encoders == nil {
+		return true
+	}
+
+	for _, prefix := range encoders {
+		if strings.HasPrefix(encoder, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
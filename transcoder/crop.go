@@ -0,0 +1,77 @@
+package transcoder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Vilsol/transcoder-go/models"
+	log "github.com/sirupsen/logrus"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Relative positions in the file that get sampled for black bars
+var cropSamplePoints = []float64{0.1, 0.3, 0.5, 0.7, 0.9}
+
+const cropSampleSeconds = 5
+
+var cropRegex = regexp.MustCompile("crop=([0-9]+):([0-9]+):([0-9]+):([0-9]+)")
+
+// Detects black bars and returns the crop filter that removes them, empty if nothing should be cropped
+func DetectCrop(fileName string, metadata *models.FileMetadata) (string, error) {
+	video := metadata.VideoStream()
+
+	if video == nil {
+		return "", errors.New("no video stream")
+	}
+
+	duration, _ := strconv.ParseFloat(metadata.Format.Duration, 64)
+
+	counts := make(map[string]int)
+	for _, point := range cropSamplePoints {
+		params := []string{
+			"-hide_banner",
+			"-ss", strconv.FormatFloat(duration*point, 'f', 2, 64),
+			"-i", fileName,
+			"-t", strconv.Itoa(cropSampleSeconds),
+			"-vf", "cropdetect",
+			"-an", "-sn",
+			"-f", "null", "-",
+		}
+
+		log.Tracef("Executing %s %s", FFmpegBinary(), strings.Join(params, " "))
+
+		// cropdetect reports on stderr
+		output, err := executor.Command(context.Background(), FFmpegBinary(), params...).CombinedOutput()
+
+		if err != nil {
+			return "", fmt.Errorf("ffmpeg exited: %s", err)
+		}
+
+		for _, match := range cropRegex.FindAllString(string(output), -1) {
+			counts[match]++
+		}
+	}
+
+	crop := ""
+	for value, count := range counts {
+		if count > counts[crop] {
+			crop = value
+		}
+	}
+
+	if crop == "" {
+		return "", errors.New("cropdetect did not report anything")
+	}
+
+	matches := cropRegex.FindStringSubmatch(crop)
+	width, _ := strconv.Atoi(matches[1])
+	height, _ := strconv.Atoi(matches[2])
+
+	if width == video.Width && height == video.Height {
+		return "", nil
+	}
+
+	return crop, nil
+}
@@ -0,0 +1,97 @@
+package transcoder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Vilsol/transcoder-go/config"
+	"github.com/Vilsol/transcoder-go/models"
+	log "github.com/sirupsen/logrus"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Relative position and number of frames of the sample idet looks at
+const deinterlaceSamplePoint = 0.3
+const deinterlaceSampleFrames = 500
+
+var idetRegex = regexp.MustCompile("Multi frame detection: TFF:\\s*([0-9]+)\\s*BFF:\\s*([0-9]+)\\s*Progressive:\\s*([0-9]+)")
+
+// Runs idet on a sample of the file and returns whether most of its frames are interlaced
+func DetectInterlacing(fileName string, metadata *models.FileMetadata) (bool, error) {
+	if metadata.VideoStream() == nil {
+		return false, errors.New("no video stream")
+	}
+
+	duration, _ := strconv.ParseFloat(metadata.Format.Duration, 64)
+
+	params := []string{
+		"-hide_banner",
+		"-ss", strconv.FormatFloat(duration*deinterlaceSamplePoint, 'f', 2, 64),
+		"-i", fileName,
+		"-frames:v", strconv.Itoa(deinterlaceSampleFrames),
+		"-vf", "idet",
+		"-an", "-sn",
+		"-f", "null", "-",
+	}
+
+	log.Tracef("Executing %s %s", FFmpegBinary(), strings.Join(params, " "))
+
+	// idet reports on stderr
+	output, err := executor.Command(context.Background(), FFmpegBinary(), params...).CombinedOutput()
+
+	if err != nil {
+		return false, fmt.Errorf("ffmpeg exited: %s", err)
+	}
+
+	matches := idetRegex.FindStringSubmatch(string(output))
+
+	if matches == nil {
+		return false, errors.New("idet did not report anything")
+	}
+
+	tff, _ := strconv.Atoi(matches[1])
+	bff, _ := strconv.Atoi(matches[2])
+	progressive, _ := strconv.Atoi(matches[3])
+
+	log.Infof("Interlacing of %s: %d top field first, %d bottom field first, %d progressive frames", fileName, tff, bff, progressive)
+
+	return tff+bff > progressive, nil
+}
+
+// Returns the deinterlace filter for the file, empty if it should not be deinterlaced
+func buildDeinterlaceFilter(fileName string, metadata *models.FileMetadata, analyze bool) string {
+	mode := config.GetString(fileName, "deinterlace")
+
+	switch mode {
+	case "yadif", "bwdif":
+		return mode
+	case "auto":
+		if metadata == nil {
+			return ""
+		}
+
+		if !analyze {
+			return placeholder("bwdif if idet finds interlacing")
+		}
+
+		interlaced, err := DetectInterlacing(fileName, metadata)
+
+		if err != nil {
+			log.Warningf("Error detecting interlacing of %s: %s", fileName, err)
+			return ""
+		}
+
+		if !interlaced {
+			log.Infof("Not interlaced: %s", fileName)
+			return ""
+		}
+
+		log.Infof("Interlaced, deinterlacing with bwdif: %s", fileName)
+
+		return "bwdif"
+	}
+
+	return ""
+}
@@ -0,0 +1,41 @@
+package transcoder
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// How many lines of stderr are kept for error reports
+const stderrTailLines = 10
+
+// Returned when ffmpeg failed on its own
+type TranscodeError struct {
+	ExitCode int
+	Stderr   string
+	Err      error
+}
+
+func newTranscodeError(err error, stderr string) *TranscodeError {
+	exitCode := -1
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		exitCode = exitErr.ExitCode()
+	}
+
+	return &TranscodeError{
+		ExitCode: exitCode,
+		Stderr:   stderr,
+		Err:      err,
+	}
+}
+
+func (err *TranscodeError) Error() string {
+	if err.Stderr == "" {
+		return fmt.Sprintf("ffmpeg exited with code %d", err.ExitCode)
+	}
+
+	return fmt.Sprintf("ffmpeg exited with code %d: %s", err.ExitCode, err.Stderr)
+}
+
+func (err *TranscodeError) Unwrap() error {
+	return err.Err
+}
@@ -0,0 +1,57 @@
+package transcoder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Vilsol/transcoder-go/models"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Transcodes the start of the file and extrapolates the size of the whole transcode from it
+func EstimateSize(ctx context.Context, fileName string, estimateFileName string, metadata *models.FileMetadata) (int64, error) {
+	duration, _ := strconv.ParseFloat(metadata.Format.Duration, 64)
+
+	if duration <= 0 {
+		return 0, errors.New("unknown duration")
+	}
+
+	clipSeconds := viper.GetFloat64("estimate-seconds")
+
+	if clipSeconds <= 0 || clipSeconds > duration {
+		clipSeconds = duration
+	}
+
+	defer os.Remove(estimateFileName)
+	defer RemoveChapters(estimateFileName)
+
+	binary, flags, err := BuildCommand(fileName, estimateFileName, metadata)
+
+	if err != nil {
+		return 0, err
+	}
+
+	// Limit the output right before the output file
+	output := flags[len(flags)-1]
+	flags = append(flags[:len(flags)-1], "-t", strconv.FormatFloat(clipSeconds, 'f', 2, 64), output)
+
+	log.Tracef("Executing %s %s", binary, strings.Join(flags, " "))
+
+	err = executor.Command(ctx, binary, flags...).Run()
+
+	if err != nil {
+		return 0, fmt.Errorf("ffmpeg exited: %s", err)
+	}
+
+	stat, err := os.Stat(estimateFileName)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(float64(stat.Size()) / clipSeconds * duration), nil
+}
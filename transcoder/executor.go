@@ -0,0 +1,29 @@
+package transcoder
+
+import (
+	"context"
+	"os/exec"
+)
+
+// Creates the commands of every ffmpeg and ffprobe run.
+// Replacing it allows running something else in place of the binaries, e.g. a helper process returning canned output.
+type Executor interface {
+	Command(ctx context.Context, name string, args ...string) *exec.Cmd
+}
+
+type systemExecutor struct{}
+
+func (systemExecutor) Command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	return exec.CommandContext(ctx, name, args...)
+}
+
+var executor Executor = systemExecutor{}
+
+// Replaces the executor used for all commands, nil restores the default
+func SetExecutor(e Executor) {
+	if e == nil {
+		e = systemExecutor{}
+	}
+
+	executor = e
+}
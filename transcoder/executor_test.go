@@ -0,0 +1,20 @@
+package transcoder
+
+import (
+	"github.com/Vilsol/transcoder-go/transcoder/transcodertest"
+	"testing"
+)
+
+// Replaces the executor for the rest of the test
+func useFakeExecutor(t *testing.T, scenario transcodertest.Scenario) {
+	SetExecutor(transcodertest.Executor{Scenario: scenario})
+
+	t.Cleanup(func() {
+		SetExecutor(nil)
+	})
+}
+
+// Not a test, the fake ffmpeg and ffprobe run as this when the fake executor starts them
+func TestHelperProcess(t *testing.T) {
+	transcodertest.HelperProcess()
+}
@@ -0,0 +1,138 @@
+package transcoder
+
+import (
+	"fmt"
+	"github.com/Vilsol/transcoder-go/config"
+	"github.com/Vilsol/transcoder-go/utils"
+	"github.com/spf13/viper"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const DefaultFlags = "-map 0 -c:v libx265 -preset ultrafast -x265-params crf=16 -c:a aac -strict -2 -b:a 256k"
+
+// Returns the configured base flags for the file.
+//
+// The flags can either be a single string or a map of extension to flags,
+// where the "default" entry is used for extensions without their own flags.
+func BaseFlags(fileName string) string {
+	flags := config.Get(fileName, "flags")
+
+	// Library callers may not configure any flags
+	if flags == nil {
+		return containerFlags(DefaultFlags)
+	}
+
+	profiles, ok := flags.(map[string]interface{})
+
+	if !ok {
+		return containerFlags(config.GetString(fileName, "flags"))
+	}
+
+	ext := strings.ToLower(filepath.Ext(fileName))
+
+	for _, key := range []string{ext, strings.TrimPrefix(ext, "."), "default"} {
+		if value, ok := profiles[key]; ok {
+			return fmt.Sprint(value)
+		}
+	}
+
+	return containerFlags(DefaultFlags)
+}
+
+// Tokenizes base flags, quotes keep arguments like filter graphs with spaces together
+func splitFlags(flags string) ([]string, error) {
+	arguments, err := utils.SplitArguments(flags)
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", err, flags)
+	}
+
+	return arguments, nil
+}
+
+// Fails if any of the configured base flags can not be tokenized
+func CheckFlags() error {
+	for _, flags := range AllBaseFlags() {
+		if _, err := splitFlags(flags); err != nil {
+			return err
+		}
+	}
+
+	if _, err := splitFlags(viper.GetString("extra-flags")); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Returns the extra flags without video filters, and the video filters separately.
+// ffmpeg only honors the last -vf, so the filters have to join the generated chain.
+func extraFlags(fileName string) ([]string, []string, error) {
+	all, err := splitFlags(config.GetString(fileName, "extra-flags"))
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	flags := make([]string, 0, len(all))
+	filters := make([]string, 0)
+
+	for i := 0; i < len(all); i++ {
+		if (all[i] == "-vf" || all[i] == "-filter:v") && i+1 < len(all) {
+			filters = append(filters, all[i+1])
+			i++
+			continue
+		}
+
+		flags = append(flags, all[i])
+	}
+
+	return flags, filters, nil
+}
+
+// Swaps the built-in defaults for the defaults of the output container
+func containerFlags(flags string) string {
+	if flags == DefaultFlags && OutputContainer().defaultFlags != "" {
+		return OutputContainer().defaultFlags
+	}
+
+	return flags
+}
+
+// Returns every configured set of base flags
+func AllBaseFlags() []string {
+	return allBaseFlags(viper.GetViper())
+}
+
+// Returns every set of base flags of the config
+func allBaseFlags(v *viper.Viper) []string {
+	// Like BaseFlags, library callers may not configure any flags
+	if v.Get("flags") == nil {
+		return []string{containerFlags(DefaultFlags)}
+	}
+
+	profiles, ok := v.Get("flags").(map[string]interface{})
+
+	if !ok {
+		return []string{containerFlags(v.GetString("flags"))}
+	}
+
+	keys := make([]string, 0, len(profiles))
+	for key := range profiles {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	all := make([]string, 0, len(keys))
+	for _, key := range keys {
+		all = append(all, containerFlags(fmt.Sprint(profiles[key])))
+	}
+
+	if _, ok := profiles["default"]; !ok {
+		all = append(all, containerFlags(DefaultFlags))
+	}
+
+	return all
+}
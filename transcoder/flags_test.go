@@ -0,0 +1,212 @@
+package transcoder
+
+import (
+	"github.com/Vilsol/transcoder-go/config"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExtraFlags(t *testing.T) {
+	tests := []struct {
+		name        string
+		extraFlags  string
+		wantFlags   []string
+		wantFilters []string
+	}{
+		{"none", "", []string{}, []string{}},
+		{"no filters", "-tune grain", []string{"-tune", "grain"}, []string{}},
+		{
+			"quoted filtergraph",
+			`-vf "hqdn3d=4:3:6, unsharp=5:5:0.5" -tune grain`,
+			[]string{"-tune", "grain"},
+			[]string{"hqdn3d=4:3:6, unsharp=5:5:0.5"},
+		},
+		{
+			"quotes inside the filtergraph",
+			`-filter:v "drawtext=text='a b'" -vf 'fps=30'`,
+			[]string{},
+			[]string{"drawtext=text='a b'", "fps=30"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			viper.Set("extra-flags", test.extraFlags)
+			defer viper.Set("extra-flags", "")
+
+			flags, filters, err := extraFlags("video.mkv")
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(flags, test.wantFlags) {
+				t.Errorf("flags = %q, want %q", flags, test.wantFlags)
+			}
+
+			if !reflect.DeepEqual(filters, test.wantFilters) {
+				t.Errorf("filters = %q, want %q", filters, test.wantFilters)
+			}
+		})
+	}
+}
+
+func TestBuildFlagsRejectsBrokenFlags(t *testing.T) {
+	tests := []struct {
+		key   string
+		value string
+	}{
+		{"flags", `-c:v libx265 -vf "scale=1280:-2`},
+		{"extra-flags", `-vf 'fps=30`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.key, func(t *testing.T) {
+			viper.Set(test.key, test.value)
+			defer viper.Set(test.key, nil)
+
+			if _, err := BuildFlags("video.mkv", "video.transcoding.mkv", nil); err == nil {
+				t.Errorf("BuildFlags with %s %q did not fail", test.key, test.value)
+			}
+
+			if err := CheckFlags(); err == nil {
+				t.Errorf("CheckFlags with %s %q did not fail", test.key, test.value)
+			}
+		})
+	}
+}
+
+func TestBaseFlagsPrecedence(t *testing.T) {
+	const configFile = `flags:
+  mkv: -c:v libx265 -crf 22
+  default: -c:v libx264 -crf 22
+profiles:
+  small:
+    flags:
+      mkv: -c:v libx265 -crf 28
+      default: -c:v libx264 -crf 28
+  mkv-only:
+    flags:
+      mkv: -c:v libx265 -crf 30
+`
+
+	tests := []struct {
+		name            string
+		file            string
+		profile         string
+		flag            string
+		directoryConfig string
+		want            string
+	}{
+		{name: "config file extension", file: "movie.mkv", want: "-c:v libx265 -crf 22"},
+		{name: "config file extension upper case", file: "movie.MKV", want: "-c:v libx265 -crf 22"},
+		{name: "config file default", file: "movie.mp4", want: "-c:v libx264 -crf 22"},
+		{name: "profile over config file", file: "movie.mkv", profile: "small", want: "-c:v libx265 -crf 28"},
+		{name: "profile default over config file", file: "movie.mp4", profile: "small", want: "-c:v libx264 -crf 28"},
+		{name: "profile replaces the whole map", file: "movie.mp4", profile: "mkv-only", want: DefaultFlags},
+		{
+			name:            "directory config over profile",
+			file:            "movie.mkv",
+			profile:         "small",
+			directoryConfig: "flags:\n  mkv: -c:v libx265 -crf 18\n",
+			want:            "-c:v libx265 -crf 18",
+		},
+		{
+			name:            "directory config replaces the whole map",
+			file:            "movie.mp4",
+			directoryConfig: "flags:\n  mkv: -c:v libx265 -crf 18\n",
+			want:            DefaultFlags,
+		},
+		{
+			name:            "directory config single string",
+			file:            "movie.mp4",
+			directoryConfig: "flags: -c:v libx264 -crf 18\n",
+			want:            "-c:v libx264 -crf 18",
+		},
+		{
+			name:            "flag over everything",
+			file:            "movie.mkv",
+			profile:         "small",
+			flag:            "-c:v libsvtav1 -crf 35",
+			directoryConfig: "flags:\n  mkv: -c:v libx265 -crf 18\n",
+			want:            "-c:v libsvtav1 -crf 35",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			directory, err := ioutil.TempDir("", "transcoder")
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			t.Cleanup(func() {
+				resetConfig(t, directory)
+				os.RemoveAll(directory)
+			})
+
+			if err := ioutil.WriteFile(filepath.Join(directory, "transcoder.yaml"), []byte(configFile), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			library := filepath.Join(directory, "library")
+
+			if err := os.Mkdir(library, 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			if test.directoryConfig != "" {
+				if err := ioutil.WriteFile(filepath.Join(library, config.DirectoryConfigName), []byte(test.directoryConfig), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			flags := pflag.NewFlagSet("transcoder", pflag.ContinueOnError)
+			flags.String("config", "", "")
+			flags.String("profile", "", "")
+			flags.String("flags", DefaultFlags, "")
+
+			for _, name := range []string{"config", "profile", "flags"} {
+				_ = viper.BindPFlag(name, flags.Lookup(name))
+			}
+
+			_ = flags.Set("config", filepath.Join(directory, "transcoder.yaml"))
+			_ = flags.Set("profile", test.profile)
+
+			if test.flag != "" {
+				_ = flags.Set("flags", test.flag)
+			}
+
+			config.InitializeConfig(flags)
+
+			if got := BaseFlags(filepath.Join(library, test.file)); got != test.want {
+				t.Errorf("BaseFlags = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+// Initializes the config again from an empty file, so no flag of the test stays changed for later tests
+func resetConfig(t *testing.T, directory string) {
+	emptyConfig := filepath.Join(directory, "empty.yaml")
+
+	if err := ioutil.WriteFile(emptyConfig, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	viper.Reset()
+
+	flags := pflag.NewFlagSet("transcoder", pflag.ContinueOnError)
+	flags.String("config", emptyConfig, "")
+	_ = viper.BindPFlag("config", flags.Lookup("config"))
+
+	config.InitializeConfig(flags)
+
+	viper.Reset()
+}
@@ -0,0 +1,192 @@
+package transcoder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Vilsol/transcoder-go/config"
+	"github.com/Vilsol/transcoder-go/models"
+	log "github.com/sirupsen/logrus"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	masteringDisplaySideData = "Mastering display metadata"
+	contentLightSideData     = "Content light level metadata"
+)
+
+// Adds the color and HDR10 params to libx265, which otherwise drops the HDR signaling from the bitstream.
+// ffmpeg passes the color flags to other encoders itself, so only libx265 needs this.
+func applyHDR(fileName string, flags []string, metadata *models.FileMetadata, analyze bool) []string {
+	if config.GetString(fileName, "hdr") != "passthrough" || metadata == nil {
+		return flags
+	}
+
+	video := metadata.VideoStream()
+
+	if video == nil || !video.IsHDR() || !usesEncoder(flags, "libx265") {
+		return flags
+	}
+
+	params := make([]string, 0)
+
+	if video.ColorPrimaries != nil {
+		params = append(params, "colorprim="+*video.ColorPrimaries)
+	}
+	if video.ColorTransfer != nil {
+		params = append(params, "transfer="+*video.ColorTransfer)
+	}
+	if video.ColorSpace != nil {
+		params = append(params, "colormatrix="+*video.ColorSpace)
+	}
+
+	// HDR10 metadata only exists for PQ, HLG is described by the transfer alone
+	if video.ColorTransfer != nil && *video.ColorTransfer == "smpte2084" {
+		// hdr10-opt is the current name of hdr-opt
+		params = append(params, "hdr10=1", "hdr10-opt=1", "repeat-headers=1")
+
+		mastering, light := video.SideData(masteringDisplaySideData), video.SideData(contentLightSideData)
+
+		if analyze {
+			mastering, light = hdrSideData(fileName, video)
+		}
+
+		if mastering != nil {
+			params = append(params, "master-display="+masterDisplay(mastering))
+		} else if !analyze {
+			params = append(params, "master-display="+placeholder("from the first frame"))
+		}
+
+		if light != nil {
+			params = append(params, fmt.Sprintf("max-cll=%d,%d", light.MaxContent, light.MaxAverage))
+		} else if !analyze {
+			params = append(params, "max-cll="+placeholder("from the first frame"))
+		}
+	}
+
+	log.Infof("Passing through HDR of %s", fileName)
+
+	result := make([]string, 0, len(flags)+2)
+	merged := false
+
+	for i := 0; i < len(flags); i++ {
+		result = append(result, flags[i])
+
+		if flags[i] == "-x265-params" && i+1 < len(flags) {
+			result = append(result, flags[i+1]+":"+strings.Join(params, ":"))
+			merged = true
+			i++
+		}
+	}
+
+	if !merged {
+		result = append(result, "-x265-params", strings.Join(params, ":"))
+	}
+
+	return result
+}
+
+// Whether the flags encode video with the encoder
+func usesEncoder(flags []string, encoder string) bool {
+	for i := 0; i+1 < len(flags); i++ {
+		if (flags[i] == "-c:v" || flags[i] == "-vcodec") && flags[i+1] == encoder {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the mastering display and content light level side data.
+// Not every demuxer exports them on the stream, so the first frame is probed as a fallback.
+func hdrSideData(fileName string, video *models.Stream) (*models.SideData, *models.SideData) {
+	mastering := video.SideData(masteringDisplaySideData)
+	light := video.SideData(contentLightSideData)
+
+	if mastering != nil && light != nil {
+		return mastering, light
+	}
+
+	frame, err := readFirstFrameSideData(fileName)
+
+	if err != nil {
+		log.Warningf("Error reading HDR side data of %s: %s", fileName, err)
+		return mastering, light
+	}
+
+	if mastering == nil {
+		mastering = frame.SideData(masteringDisplaySideData)
+	}
+
+	if light == nil {
+		light = frame.SideData(contentLightSideData)
+	}
+
+	return mastering, light
+}
+
+// Reads the side data of the first video frame, it is returned as a stream for the lookup helpers
+func readFirstFrameSideData(fileName string) (*models.Stream, error) {
+	params := []string{
+		"-v", "quiet",
+		"-print_format", "json",
+		"-select_streams", "v:0",
+		"-read_intervals", "%+#1",
+		"-show_entries", "frame=side_data_list",
+		fileName,
+	}
+
+	log.Tracef("Executing %s %s", FFprobeBinary(), strings.Join(params, " "))
+
+	output, err := executor.Command(context.Background(), FFprobeBinary(), params...).Output()
+
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe exited: %s", err)
+	}
+
+	var result struct {
+		Frames []models.Stream `json:"frames"`
+	}
+
+	if err := json.Unmarshal(output, &result); err != nil {
+		return nil, fmt.Errorf("failed parsing ffprobe output: %s", err)
+	}
+
+	if len(result.Frames) == 0 {
+		return &models.Stream{}, nil
+	}
+
+	return &result.Frames[0], nil
+}
+
+// Formats the mastering display for x265, chromaticities are in units of 0.00002 and luminance in units of 0.0001
+func masterDisplay(data *models.SideData) string {
+	return fmt.Sprintf(
+		"G(%d,%d)B(%d,%d)R(%d,%d)WP(%d,%d)L(%d,%d)",
+		scaleRational(data.GreenX, 50000), scaleRational(data.GreenY, 50000),
+		scaleRational(data.BlueX, 50000), scaleRational(data.BlueY, 50000),
+		scaleRational(data.RedX, 50000), scaleRational(data.RedY, 50000),
+		scaleRational(data.WhitePointX, 50000), scaleRational(data.WhitePointY, 50000),
+		scaleRational(data.MaxLuminance, 10000), scaleRational(data.MinLuminance, 10000),
+	)
+}
+
+// Converts a rational like 35400/50000 into an integer in the given units
+func scaleRational(value string, units float64) int64 {
+	split := strings.SplitN(value, "/", 2)
+
+	numerator, _ := strconv.ParseFloat(split[0], 64)
+	denominator := 1.0
+
+	if len(split) == 2 {
+		denominator, _ = strconv.ParseFloat(split[1], 64)
+	}
+
+	if denominator == 0 {
+		return 0
+	}
+
+	return int64(math.Round(numerator / denominator * units))
+}
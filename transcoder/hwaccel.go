@@ -0,0 +1,226 @@
+package transcoder
+
+import (
+	"context"
+	"fmt"
+	"github.com/spf13/viper"
+	"regexp"
+	"strings"
+)
+
+type hardwareAccelerator struct {
+	// Name of the decoder in `ffmpeg -hwaccels`
+	decoder string
+	// Encoder suffix appended to the codec family (hevc, h264)
+	encoderSuffix string
+	// Flag that replaces crf as the quality parameter
+	qualityFlag string
+	// Maps software presets onto the encoder presets, nil if the encoder has none
+	presets map[string]string
+	// Filters needed to get frames onto the device
+	videoFilters []string
+}
+
+var x26xPresetsToNvenc = map[string]string{
+	"ultrafast": "p1",
+	"superfast": "p1",
+	"veryfast":  "p2",
+	"faster":    "p3",
+	"fast":      "p4",
+	"medium":    "p5",
+	"slow":      "p6",
+	"slower":    "p7",
+	"veryslow":  "p7",
+	"placebo":   "p7",
+}
+
+var x26xPresetsToQsv = map[string]string{
+	"ultrafast": "veryfast",
+	"superfast": "veryfast",
+	"veryfast":  "veryfast",
+	"faster":    "faster",
+	"fast":      "fast",
+	"medium":    "medium",
+	"slow":      "slow",
+	"slower":    "slower",
+	"veryslow":  "veryslow",
+	"placebo":   "veryslow",
+}
+
+var hardwareAccelerators = map[string]hardwareAccelerator{
+	"nvenc": {
+		decoder:       "cuda",
+		encoderSuffix: "_nvenc",
+		qualityFlag:   "-cq",
+		presets:       x26xPresetsToNvenc,
+	},
+	"qsv": {
+		decoder:       "qsv",
+		encoderSuffix: "_qsv",
+		qualityFlag:   "-global_quality",
+		presets:       x26xPresetsToQsv,
+	},
+	"vaapi": {
+		decoder:       "vaapi",
+		encoderSuffix: "_vaapi",
+		qualityFlag:   "-qp",
+		videoFilters:  []string{"format=nv12", "hwupload"},
+	},
+}
+
+var softwareEncoders = map[string]string{
+	"libx265": "hevc",
+	"libx264": "h264",
+}
+
+var crfRegex = regexp.MustCompile("(?:^|:)crf=([0-9.]+)")
+
+func getHardwareAccelerator() (*hardwareAccelerator, error) {
+	name := viper.GetString("hwaccel")
+
+	if name == "" || name == "none" {
+		return nil, nil
+	}
+
+	accelerator, ok := hardwareAccelerators[name]
+
+	if !ok {
+		return nil, fmt.Errorf("unknown hardware acceleration: %s", name)
+	}
+
+	return &accelerator, nil
+}
+
+// Flags that have to be placed before the input file
+func hardwareInputFlags() []string {
+	accelerator, _ := getHardwareAccelerator()
+
+	if accelerator == nil {
+		return []string{}
+	}
+
+	flags := []string{"-hwaccel", accelerator.decoder}
+
+	if accelerator.decoder == "vaapi" {
+		flags = append(flags, "-vaapi_device", viper.GetString("hwaccel-device"))
+	}
+
+	return flags
+}
+
+// Rewrites software encoder flags into their hardware equivalents
+func applyHardwareAcceleration(flags []string) []string {
+	accelerator, _ := getHardwareAccelerator()
+
+	if accelerator == nil {
+		return flags
+	}
+
+	result := make([]string, 0, len(flags))
+
+	for i := 0; i < len(flags); i++ {
+		flag := flags[i]
+
+		if i+1 >= len(flags) {
+			result = append(result, flag)
+			continue
+		}
+
+		value := flags[i+1]
+
+		switch flag {
+		case "-c:v", "-vcodec":
+			if family, ok := softwareEncoders[value]; ok {
+				value = family + accelerator.encoderSuffix
+			}
+			break
+		case "-x265-params", "-x264-params":
+			// Only the quality survives, the rest is specific to the software encoder
+			matches := crfRegex.FindStringSubmatch(value)
+			if len(matches) > 1 {
+				result = append(result, accelerator.qualityFlag, matches[1])
+			}
+			i++
+			continue
+		case "-crf":
+			flag = accelerator.qualityFlag
+			break
+		case "-preset":
+			if accelerator.presets == nil {
+				i++
+				continue
+			}
+
+			if preset, ok := accelerator.presets[value]; ok {
+				value = preset
+			}
+			break
+		default:
+			result = append(result, flag)
+			continue
+		}
+
+		result = append(result, flag, value)
+		i++
+	}
+
+	return result
+}
+
+// Fails if the configured hardware acceleration is not supported by the ffmpeg build
+func CheckHardwareAcceleration() error {
+	accelerator, err := getHardwareAccelerator()
+
+	if err != nil {
+		return err
+	}
+
+	if accelerator == nil {
+		return nil
+	}
+
+	hwaccels, err := executor.Command(context.Background(), FFmpegBinary(), "-hide_banner", "-hwaccels").Output()
+
+	if err != nil {
+		return fmt.Errorf("failed listing ffmpeg hwaccels: %s", err)
+	}
+
+	if !containsWord(string(hwaccels), accelerator.decoder) {
+		return fmt.Errorf("ffmpeg does not support hwaccel %s", accelerator.decoder)
+	}
+
+	encoders, err := executor.Command(context.Background(), FFmpegBinary(), "-hide_banner", "-encoders").Output()
+
+	if err != nil {
+		return fmt.Errorf("failed listing ffmpeg encoders: %s", err)
+	}
+
+	for _, baseFlags := range AllBaseFlags() {
+		split, err := splitFlags(baseFlags)
+
+		if err != nil {
+			return err
+		}
+
+		flags := applyHardwareAcceleration(split)
+		for i := 0; i < len(flags)-1; i++ {
+			if flags[i] == "-c:v" || flags[i] == "-vcodec" {
+				if !containsWord(string(encoders), flags[i+1]) {
+					return fmt.Errorf("ffmpeg does not support encoder %s", flags[i+1])
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func containsWord(output string, word string) bool {
+	for _, field := range strings.Fields(output) {
+		if field == word {
+			return true
+		}
+	}
+
+	return false
+}
@@ -1,46 +1,49 @@
 package transcoder
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/Vilsol/transcoder-go/models"
 	log "github.com/sirupsen/logrus"
 	"io/ioutil"
-	"os/exec"
 	"strings"
 )
 
-func ReadFileMetadata(file string) *models.FileMetadata {
-	params := []string{"-v", "quiet", "-print_format", "json", "-show_format", "-show_streams", file}
+// Probes the streams, format and chapters of the file
+func ReadFileMetadata(file string) (*models.FileMetadata, error) {
+	params := []string{"-v", "quiet", "-print_format", "json", "-show_format", "-show_streams", "-show_chapters", file}
 
-	log.Tracef("Executing ffprobe %s", strings.Join(params, " "))
+	log.Tracef("Executing %s %s", FFprobeBinary(), strings.Join(params, " "))
 
-	c := exec.Command("ffprobe", params...)
+	c := executor.Command(context.Background(), FFprobeBinary(), params...)
 
 	pipe, err := c.StdoutPipe()
 	if err != nil {
-		log.Fatalf("Failed hooking ffprobe stdout: %s", err)
+		return nil, fmt.Errorf("failed hooking ffprobe stdout: %s", err)
 	}
 
 	err = c.Start()
 	if err != nil {
-		log.Fatalf("Failed running ffprobe: %s", err)
+		return nil, fmt.Errorf("failed running ffprobe: %s", err)
 	}
 
 	stdoutData, err := ioutil.ReadAll(pipe)
 	if err != nil {
-		log.Fatalf("Failed reading ffprobe response: %s", err)
+		_ = c.Wait()
+		return nil, fmt.Errorf("failed reading ffprobe response: %s", err)
 	}
 
 	err = c.Wait()
 	if err != nil {
-		log.Fatalf("ffprobe exited: %s", err)
+		return nil, fmt.Errorf("ffprobe exited: %s", err)
 	}
 
 	var metadata models.FileMetadata
 	err = json.Unmarshal(stdoutData, &metadata)
 	if err != nil {
-		log.Fatalf("Failed parsing ffprobe output: %s", err)
+		return nil, fmt.Errorf("failed parsing ffprobe output: %s", err)
 	}
 
-	return &metadata
+	return &metadata, nil
 }
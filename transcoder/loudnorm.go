@@ -0,0 +1,117 @@
+package transcoder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Vilsol/transcoder-go/config"
+	"github.com/Vilsol/transcoder-go/models"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"strconv"
+	"strings"
+)
+
+// Maximum true peak in dBTP and loudness range in LU, the target loudness is configurable
+const loudnormTruePeak = -2
+const loudnormRange = 7
+
+// Values loudnorm prints at the end of the measuring pass
+type loudnessMeasurement struct {
+	InputI       string `json:"input_i"`
+	InputTP      string `json:"input_tp"`
+	InputLRA     string `json:"input_lra"`
+	InputThresh  string `json:"input_thresh"`
+	TargetOffset string `json:"target_offset"`
+}
+
+func loudnormTarget() string {
+	return fmt.Sprintf("I=%s:TP=%d:LRA=%d",
+		strconv.FormatFloat(viper.GetFloat64("loudnorm-target"), 'f', -1, 64),
+		loudnormTruePeak,
+		loudnormRange,
+	)
+}
+
+// Measures the loudness of the audio stream, the first pass of loudnorm
+func MeasureLoudness(fileName string, audioIndex int) (*loudnessMeasurement, error) {
+	params := []string{
+		"-hide_banner",
+		"-i", fileName,
+		"-map", "0:a:" + strconv.Itoa(audioIndex),
+		"-af", "loudnorm=" + loudnormTarget() + ":print_format=json",
+		"-vn", "-sn",
+		"-f", "null", "-",
+	}
+
+	log.Tracef("Executing %s %s", FFmpegBinary(), strings.Join(params, " "))
+
+	// loudnorm reports on stderr
+	output, err := executor.Command(context.Background(), FFmpegBinary(), params...).CombinedOutput()
+
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg exited: %s", err)
+	}
+
+	// The JSON block is the last thing printed
+	start := strings.LastIndex(string(output), "{")
+	end := strings.LastIndex(string(output), "}")
+
+	if start < 0 || end < start {
+		return nil, errors.New("loudnorm did not report anything")
+	}
+
+	var measurement loudnessMeasurement
+	if err := json.Unmarshal(output[start:end+1], &measurement); err != nil {
+		return nil, fmt.Errorf("failed parsing loudnorm output: %s", err)
+	}
+
+	if measurement.InputI == "" || measurement.InputI == "-inf" {
+		// Silence can not be normalized
+		return nil, errors.New("audio stream is silent")
+	}
+
+	return &measurement, nil
+}
+
+// Returns a loudnorm filter for every kept audio stream, measured first so the second pass can normalize linearly.
+// Streams that can not be measured fall back to a single dynamic pass.
+func buildLoudnormFlags(fileName string, metadata *models.FileMetadata, analyze bool) []string {
+	if !config.GetBool(fileName, "loudnorm") || metadata == nil {
+		return []string{}
+	}
+
+	flags := make([]string, 0)
+
+	for output, i := range keptAudioStreams(fileName, metadata) {
+		filter := "loudnorm=" + loudnormTarget()
+
+		if !analyze {
+			flags = append(flags, "-filter:a:"+strconv.Itoa(output), filter+":"+placeholder("measured loudness"))
+			continue
+		}
+
+		log.Infof("Measuring loudness of audio stream %d: %s", i, fileName)
+
+		measurement, err := MeasureLoudness(fileName, i)
+
+		if err != nil {
+			log.Warningf("Error measuring loudness of audio stream %d of %s, normalizing in a single pass: %s", i, fileName, err)
+		} else {
+			log.Infof("Loudness of audio stream %d is %s LUFS: %s", i, measurement.InputI, fileName)
+
+			filter += fmt.Sprintf(":measured_I=%s:measured_TP=%s:measured_LRA=%s:measured_thresh=%s:offset=%s:linear=true",
+				measurement.InputI,
+				measurement.InputTP,
+				measurement.InputLRA,
+				measurement.InputThresh,
+				measurement.TargetOffset,
+			)
+		}
+
+		flags = append(flags, "-filter:a:"+strconv.Itoa(output), filter)
+	}
+
+	return flags
+}
@@ -0,0 +1,225 @@
+package transcoder
+
+import (
+	"context"
+	"fmt"
+	"github.com/Vilsol/transcoder-go/config"
+	"github.com/Vilsol/transcoder-go/models"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Formats tried in order when the encoder can not take the source format, by bit depth
+var pixelFormatFallbacks = map[int][]string{
+	8:  {"yuv420p", "nv12"},
+	10: {"yuv420p10le", "p010le"},
+	12: {"yuv420p12le"},
+}
+
+var pixelFormatDepthRegex = regexp.MustCompile("p([0-9]+)(le|be)?$")
+
+var encoderPixelFormats = make(map[string][]string)
+var encoderPixelFormatsLock sync.Mutex
+
+// Returns the bit depth of a pixel format, e.g. 10 for yuv420p10le
+func pixelFormatBitDepth(format string) int {
+	if matches := pixelFormatDepthRegex.FindStringSubmatch(format); matches != nil {
+		depth, _ := strconv.Atoi(matches[1])
+		return depth
+	}
+
+	// p010 and p016 are semi-planar formats named after their depth
+	if strings.HasPrefix(format, "p0") && len(format) >= 4 {
+		depth, _ := strconv.Atoi(format[1:4])
+		return depth
+	}
+
+	return 8
+}
+
+// Returns the pixel formats the encoder supports, nil if ffmpeg does not list them
+func EncoderPixelFormats(encoder string) ([]string, error) {
+	encoderPixelFormatsLock.Lock()
+	defer encoderPixelFormatsLock.Unlock()
+
+	if formats, ok := encoderPixelFormats[encoder]; ok {
+		return formats, nil
+	}
+
+	output, err := executor.Command(context.Background(), FFmpegBinary(), "-hide_banner", "-h", "encoder="+encoder).Output()
+
+	if err != nil {
+		return nil, fmt.Errorf("failed describing encoder %s: %s", encoder, err)
+	}
+
+	var formats []string
+
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.TrimSpace(line)
+
+		if strings.HasPrefix(line, "Supported pixel formats:") {
+			formats = strings.Fields(strings.TrimPrefix(line, "Supported pixel formats:"))
+			break
+		}
+	}
+
+	encoderPixelFormats[encoder] = formats
+
+	return formats, nil
+}
+
+// Returns the last video encoder in the flags, empty if there is none
+func videoEncoder(flags []string) string {
+	encoder := ""
+
+	for i := 0; i < len(flags)-1; i++ {
+		if flags[i] == "-c:v" || flags[i] == "-vcodec" {
+			encoder = flags[i+1]
+		}
+	}
+
+	return encoder
+}
+
+func supportsPixelFormat(formats []string, format string) bool {
+	// Encoders that do not list their formats take anything
+	if formats == nil {
+		return true
+	}
+
+	for _, supported := range formats {
+		if supported == format {
+			return true
+		}
+	}
+
+	return false
+}
+
+func init() {
+	config.AddDirectoryCheck(checkDirectoryPixelFormat)
+}
+
+// Fails if pix-fmt is set to a format one of the configured encoders does not support
+func CheckPixelFormat() error {
+	return checkPixelFormat(viper.GetString("pix-fmt"), AllBaseFlags())
+}
+
+// Checks the pix-fmt of a directory config against its own base flags, or the configured ones if it has none
+func checkDirectoryPixelFormat(directoryConfig *viper.Viper) error {
+	if !directoryConfig.IsSet("pix-fmt") {
+		return nil
+	}
+
+	allFlags := AllBaseFlags()
+	if directoryConfig.IsSet("flags") {
+		allFlags = allBaseFlags(directoryConfig)
+	}
+
+	if err := checkPixelFormat(directoryConfig.GetString("pix-fmt"), allFlags); err != nil {
+		return fmt.Errorf("invalid pix-fmt: %s", err)
+	}
+
+	return nil
+}
+
+func checkPixelFormat(format string, allFlags []string) error {
+	if format == "auto" {
+		return nil
+	}
+
+	for _, baseFlags := range allFlags {
+		flags, err := splitFlags(baseFlags)
+
+		if err != nil {
+			return err
+		}
+
+		encoder := videoEncoder(applyHardwareAcceleration(flags))
+
+		if encoder == "" || encoder == "copy" {
+			continue
+		}
+
+		formats, err := EncoderPixelFormats(encoder)
+
+		if err != nil {
+			return err
+		}
+
+		if !supportsPixelFormat(formats, format) {
+			return fmt.Errorf("encoder %s does not support %s, it supports %s", encoder, format, strings.Join(formats, " "))
+		}
+	}
+
+	return nil
+}
+
+// Returns the pixel format of the output, empty to leave it to the encoder.
+// auto keeps the format of the source, or at least its bit depth if the encoder can not take it.
+func buildPixelFormat(fileName string, flags []string, metadata *models.FileMetadata) string {
+	format := config.GetString(fileName, "pix-fmt")
+
+	if format != "auto" {
+		return format
+	}
+
+	if metadata == nil {
+		return ""
+	}
+
+	video := metadata.VideoStream()
+
+	if video == nil || video.PixelFormat == nil {
+		return ""
+	}
+
+	source := *video.PixelFormat
+	encoder := videoEncoder(flags)
+
+	if encoder == "" || encoder == "copy" {
+		return source
+	}
+
+	formats, err := EncoderPixelFormats(encoder)
+
+	if err != nil {
+		log.Warningf("Error reading pixel formats of %s, keeping %s: %s", encoder, source, err)
+		return source
+	}
+
+	if supportsPixelFormat(formats, source) {
+		return source
+	}
+
+	depth := pixelFormatBitDepth(source)
+
+	// As close to the source as possible, losing depth only if the encoder has nothing deeper
+	for _, fallbackDepth := range []int{12, 10, 8} {
+		if fallbackDepth > depth {
+			continue
+		}
+
+		for _, fallback := range pixelFormatFallbacks[fallbackDepth] {
+			if !supportsPixelFormat(formats, fallback) {
+				continue
+			}
+
+			if fallbackDepth < depth {
+				log.Warningf("Encoder %s does not support %d-bit %s, reducing to %d-bit %s: %s", encoder, depth, source, fallbackDepth, fallback, fileName)
+			} else {
+				log.Infof("Encoder %s does not support %s, keeping %d-bit with %s: %s", encoder, source, depth, fallback, fileName)
+			}
+
+			return fallback
+		}
+	}
+
+	log.Warningf("Encoder %s supports none of the fallbacks for %s, leaving it to the encoder: %s", encoder, source, fileName)
+
+	return ""
+}
@@ -0,0 +1,37 @@
+package transcoder
+
+import (
+	"github.com/Vilsol/transcoder-go/config"
+	"github.com/Vilsol/transcoder-go/transcoder/transcodertest"
+	"github.com/spf13/viper"
+	"path/filepath"
+	"testing"
+)
+
+func TestDirectoryPixelFormat(t *testing.T) {
+	useFakeExecutor(t, transcodertest.Scenario{})
+
+	viper.Set("pix-fmt", "auto")
+	defer viper.Set("pix-fmt", nil)
+
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"supported", "pix-fmt: yuv420p10le\n", "yuv420p10le"},
+		{"unsupported by the encoder", "pix-fmt: rgb48le\n", "auto"},
+		{"unsupported by the encoder of the directory", "flags: -c:v libsvtav1\npix-fmt: rgb48le\n", "auto"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			directory := transcodertest.TempDir(t)
+			transcodertest.WriteFile(t, filepath.Join(directory, config.DirectoryConfigName), test.content)
+
+			if got := config.GetString(filepath.Join(directory, "video.mkv"), "pix-fmt"); got != test.want {
+				t.Errorf("pix-fmt = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package transcoder
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"runtime"
+	"strconv"
+)
+
+var ioniceClasses = map[string]string{
+	"realtime":    "1",
+	"best-effort": "2",
+	"idle":        "3",
+}
+
+// Fails if the configured io priority is unknown, warns if priorities are not supported
+func CheckPriority() error {
+	class := viper.GetString("ionice-class")
+
+	if _, ok := ioniceClasses[class]; class != "" && !ok {
+		return fmt.Errorf("unknown ionice class: %s", class)
+	}
+
+	if runtime.GOOS != "linux" && (viper.GetBool("nice") || class != "") {
+		log.Warningf("Process priorities are only supported on linux, ignoring nice and ionice-class")
+	}
+
+	return nil
+}
+
+// Wraps the command with nice and ionice on linux
+func applyPriority(command []string) []string {
+	if runtime.GOOS != "linux" {
+		return command
+	}
+
+	if viper.GetBool("nice") {
+		command = append([]string{"nice", "-n", strconv.Itoa(viper.GetInt("nice-level"))}, command...)
+	}
+
+	if class, ok := ioniceClasses[viper.GetString("ionice-class")]; ok {
+		command = append([]string{"ionice", "-c", class}, command...)
+	}
+
+	return command
+}
@@ -0,0 +1,431 @@
+package transcoder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Vilsol/transcoder-go/models"
+	"github.com/Vilsol/transcoder-go/utils"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Returned by ProcessFile when the context got cancelled before the transcode finished
+var ErrInterrupted = errors.New("interrupted")
+
+// Decides how ProcessFile transcodes a file and what it does with the transcode.
+// The zero value replaces the file with a transcode written next to it, whatever its size.
+type Options struct {
+	// Metadata of the file, read with ffprobe when nil
+	Metadata *models.FileMetadata
+	// Where ffmpeg writes to, <file>.transcode-temp when empty
+	TempFileName string
+	// Where the transcode ends up, the file with the extension of the output container when empty
+	OutputFileName string
+	// Leave the original in place and only move the transcode to OutputFileName
+	KeepSource bool
+	// Keep the original if the transcode is bigger
+	KeepOld bool
+	// Kill ffmpeg as soon as the output is bigger than the original, only with KeepOld
+	EarlyExit bool
+	// Keep the output of failed and killed transcodes as <file>.failed
+	KeepFailed bool
+	// Keep the original if the VMAF score of the transcode is below this, 0 to not compute it
+	MinVMAF float64
+	// Keep the original unless the transcode is at least this many percent smaller, 0 to disable
+	MinSavingsPercent float64
+	// Seconds the transcode may be shorter than the original before it counts as failed, 0 to disable
+	DurationTolerance float64
+	// Kill ffmpeg if the transcode takes longer than this, 0 to disable
+	Timeout time.Duration
+	// How often a failed ffmpeg is run again, every retry waits the attempt times RetryBackoff
+	Retries      int
+	RetryBackoff time.Duration
+	// Extract a screenshot of the transcode into the result
+	Thumbnail bool
+	// Copy owner and permissions of the original onto the replacement
+	PreserveOwnership bool
+	// Receives every progress report, may be nil
+	OnProgress ProgressFunc
+	// Asked before the original gets replaced, nil always replaces it
+	ConfirmReplace func(originalSize int64, newSize int64) bool
+	// Moves the original out of the way, e.g. into a trash. Called before the transcode takes
+	// the name of the original, nil deletes it and replaces an original of the same name in one rename.
+	RemoveOriginal func(fileName string) error
+}
+
+// How a ProcessFile call turned out
+type Result struct {
+	models.FileResult
+	// Why the original was kept or the transcode failed, empty if the result says it all
+	Reason string
+	// Where the transcode ended up, empty if it did not replace or join the original
+	OutputFileName string
+	// Metadata of the original
+	Metadata *models.FileMetadata
+	// Metadata of the transcode, nil if it did not finish
+	NewMetadata *models.FileMetadata
+	// Last progress report of ffmpeg, nil if it never reported
+	LastReport *models.ProgressReport
+	// Screenshot of the transcode, only with Options.Thumbnail
+	Thumbnail []byte
+}
+
+// Transcodes a single file and replaces or keeps the original according to the options.
+// The error is set for every ResultError, ErrInterrupted if the context got cancelled,
+// context.DeadlineExceeded once the timeout of the options passed.
+func ProcessFile(ctx context.Context, fileName string, opts Options) (Result, error) {
+	started := time.Now()
+
+	metadata := opts.Metadata
+	if metadata == nil {
+		var err error
+		metadata, err = ReadFileMetadata(fileName)
+
+		if err != nil {
+			result := Result{FileResult: models.FileResult{File: fileName}}
+			return result.failed(fmt.Errorf("error reading metadata of %s: %s", fileName, err), started)
+		}
+	}
+
+	tempFileName := opts.TempFileName
+	if tempFileName == "" {
+		tempFileName = fileName + ".transcode-temp"
+	}
+
+	outputFileName := opts.OutputFileName
+	if outputFileName == "" {
+		outputFileName = strings.TrimSuffix(fileName, filepath.Ext(fileName)) + OutputExtension()
+	}
+
+	result := Result{
+		FileResult: models.FileResult{File: fileName, OriginalSize: metadata.Format.SizeInt()},
+		Metadata:   metadata,
+	}
+
+	fileCtx := ctx
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		fileCtx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	stopAbove := int64(0)
+	if opts.EarlyExit && opts.KeepOld {
+		stopAbove = metadata.Format.SizeInt()
+	}
+
+	var outcome models.Outcome
+	var err error
+
+	for attempt := 0; ; attempt++ {
+		outcome, result.LastReport, err = TranscodeFile(fileCtx, fileName, tempFileName, metadata, stopAbove, opts.OnProgress)
+
+		// Only ffmpeg failing is worth another attempt, broken flags stay broken
+		var transcodeErr *TranscodeError
+		if outcome != models.OutcomeFailed || !errors.As(err, &transcodeErr) || IsSignaled(err) || attempt >= opts.Retries {
+			break
+		}
+
+		if removeErr := os.Remove(tempFileName); removeErr != nil && !os.IsNotExist(removeErr) {
+			log.Errorf("Error deleting file %s: %s", tempFileName, removeErr)
+			break
+		}
+
+		backoff := time.Duration(attempt+1) * opts.RetryBackoff
+		log.Warningf("Retrying %s in %s (%d/%d): %s", fileName, backoff, attempt+1, opts.Retries, err)
+
+		select {
+		case <-fileCtx.Done():
+		case <-time.After(backoff):
+		}
+
+		if fileCtx.Err() != nil {
+			break
+		}
+	}
+
+	if ctx.Err() == nil && fileCtx.Err() == context.DeadlineExceeded {
+		DiscardFailed(fileName, tempFileName, opts.KeepFailed)
+
+		ResultLog(fileName, models.ResultError, result.OriginalSize, 0).Errorf("Timed out transcoding %s after %s", fileName, opts.Timeout)
+
+		return result.end(models.ResultError, 0, "Timed out after "+opts.Timeout.String(), started), fileCtx.Err()
+	}
+
+	if ctx.Err() != nil || outcome == models.OutcomeTerminated {
+		DiscardFailed(fileName, tempFileName, opts.KeepFailed)
+
+		return result.end(models.ResultError, 0, "", started), ErrInterrupted
+	}
+
+	if outcome == models.OutcomeFailed {
+		DiscardFailed(fileName, tempFileName, opts.KeepFailed)
+
+		ResultLog(fileName, models.ResultError, result.OriginalSize, 0).Errorf("Failed transcoding %s: %s", fileName, err)
+
+		return result.end(models.ResultError, 0, err.Error(), started), err
+	}
+
+	if outcome == models.OutcomeStopped {
+		// The temp file is already gone, only the last report tells how big it got
+		newSize := int64(0)
+		if result.LastReport != nil {
+			newSize = int64(result.LastReport.TotalSize)
+		}
+
+		ResultLog(fileName, models.ResultKeepOriginal, result.OriginalSize, newSize).Infof("Kept original %s: %s < %s",
+			fileName,
+			utils.BytesHumanReadable(result.OriginalSize),
+			utils.BytesHumanReadable(newSize),
+		)
+
+		return result.end(models.ResultKeepOriginal, newSize, "", started), nil
+	}
+
+	newMetadata, err := ReadFileMetadata(tempFileName)
+
+	if err != nil {
+		// A truncated or corrupt transcode, the original stays
+		DiscardFailed(fileName, tempFileName, opts.KeepFailed)
+
+		return result.failed(fmt.Errorf("error reading metadata of %s: %s", tempFileName, err), started)
+	}
+
+	result.NewMetadata = newMetadata
+	newSize := result.NewMetadata.Format.SizeInt()
+
+	if err := checkOutput(metadata, result.NewMetadata, opts.DurationTolerance); err != nil {
+		// ffmpeg sometimes exits cleanly after giving up on the rest of the input
+		DiscardFailed(fileName, tempFileName, opts.KeepFailed)
+
+		ResultLog(fileName, models.ResultError, result.OriginalSize, newSize).Errorf("Failed transcoding %s: %s", fileName, err)
+
+		result.NewMetadata = nil
+		return result.end(models.ResultError, 0, err.Error(), started), err
+	}
+
+	if opts.Thumbnail {
+		thumbnail, err := ExtractThumbnail(tempFileName, tempFileName+".jpg", result.NewMetadata)
+
+		if err != nil {
+			log.Warningf("Error extracting thumbnail of %s: %s", fileName, err)
+		} else {
+			result.Thumbnail = thumbnail
+		}
+	}
+
+	if opts.MinVMAF > 0 {
+		score, err := ComputeVMAF(fileName, tempFileName)
+
+		if err != nil {
+			// Without a score there is no telling whether the quality is acceptable, so the file is retried
+			DiscardFailed(fileName, tempFileName, opts.KeepFailed)
+
+			return result.failed(fmt.Errorf("VMAF could not be computed: %s", err), started)
+		}
+
+		log.Infof("VMAF of %s: %.2f", fileName, score)
+		result.VMAF = &score
+
+		if score < opts.MinVMAF {
+			if err := os.Remove(tempFileName); err != nil {
+				return result.failed(fmt.Errorf("error deleting file %s: %s", tempFileName, err), started)
+			}
+
+			ResultLog(fileName, models.ResultKeepOriginal, result.OriginalSize, newSize).
+				WithField("vmaf", score).
+				Infof("Kept original %s: VMAF %.2f < %.2f", fileName, score, opts.MinVMAF)
+
+			return result.end(models.ResultKeepOriginal, newSize, fmt.Sprintf("VMAF %.2f below %.2f", score, opts.MinVMAF), started), nil
+		}
+	}
+
+	if opts.MinSavingsPercent > 0 {
+		savings := 0.0
+		if result.OriginalSize > 0 {
+			savings = float64(result.OriginalSize-newSize) / float64(result.OriginalSize) * 100
+		}
+
+		log.Infof("Savings of %s: %.2f%%", fileName, savings)
+
+		if savings < opts.MinSavingsPercent {
+			if err := os.Remove(tempFileName); err != nil {
+				return result.failed(fmt.Errorf("error deleting file %s: %s", tempFileName, err), started)
+			}
+
+			ResultLog(fileName, models.ResultKeepOriginal, result.OriginalSize, newSize).
+				WithField("savings_percent", savings).
+				Infof("Kept original %s: savings %.2f%% < %.2f%%", fileName, savings, opts.MinSavingsPercent)
+
+			return result.end(models.ResultKeepOriginal, newSize, fmt.Sprintf("Savings %.2f%% below %.2f%%", savings, opts.MinSavingsPercent), started), nil
+		}
+	}
+
+	if opts.KeepOld && newSize > result.OriginalSize {
+		// Transcoded file is bigger than original
+		if err := os.Remove(tempFileName); err != nil {
+			return result.failed(fmt.Errorf("error deleting file %s: %s", tempFileName, err), started)
+		}
+
+		ResultLog(fileName, models.ResultKeepOriginal, result.OriginalSize, newSize).Infof("Kept original %s: %s < %s",
+			fileName,
+			utils.BytesHumanReadable(result.OriginalSize),
+			utils.BytesHumanReadable(newSize),
+		)
+
+		return result.end(models.ResultKeepOriginal, newSize, "", started), nil
+	}
+
+	if opts.KeepSource {
+		// Transcoded file is smaller than original, but the original is left untouched
+		if err := os.MkdirAll(filepath.Dir(outputFileName), 0755); err != nil {
+			return result.failed(fmt.Errorf("error creating directory %s: %s", filepath.Dir(outputFileName), err), started)
+		}
+
+		if err := utils.MoveFile(tempFileName, outputFileName); err != nil {
+			return result.failed(fmt.Errorf("error moving file %s to %s: %s", tempFileName, outputFileName, err), started)
+		}
+
+		ResultLog(fileName, models.ResultReplaced, result.OriginalSize, newSize).Infof("Transcoded %s to %s: %s < %s",
+			fileName,
+			outputFileName,
+			utils.BytesHumanReadable(newSize),
+			utils.BytesHumanReadable(result.OriginalSize),
+		)
+
+		result.OutputFileName = outputFileName
+		return result.end(models.ResultReplaced, newSize, "", started), nil
+	}
+
+	if opts.ConfirmReplace != nil && !opts.ConfirmReplace(result.OriginalSize, newSize) {
+		if err := os.Remove(tempFileName); err != nil {
+			return result.failed(fmt.Errorf("error deleting file %s: %s", tempFileName, err), started)
+		}
+
+		ResultLog(fileName, models.ResultKeepOriginal, result.OriginalSize, newSize).Infof("Kept original %s: replacement declined", fileName)
+
+		return result.end(models.ResultKeepOriginal, newSize, "Replacement declined", started), nil
+	}
+
+	// Transcoded file is smaller than original
+	originalStat, err := os.Stat(fileName)
+
+	if err != nil {
+		return result.failed(fmt.Errorf("error reading file %s: %s", fileName, err), started)
+	}
+
+	if err := replaceOriginal(fileName, tempFileName, outputFileName, opts.RemoveOriginal); err != nil {
+		return result.failed(fmt.Errorf("error replacing file %s with %s: %s", fileName, tempFileName, err), started)
+	}
+
+	if opts.PreserveOwnership {
+		err = utils.PreserveOwnership(outputFileName, originalStat)
+
+		if os.IsPermission(err) {
+			log.Warningf("Missing permissions to preserve ownership of %s: %s", outputFileName, err)
+		} else if err != nil {
+			log.Errorf("Error preserving ownership of %s: %s", outputFileName, err)
+		}
+	}
+
+	ResultLog(fileName, models.ResultReplaced, result.OriginalSize, newSize).Infof("Replaced %s with transcoded: %s < %s",
+		fileName,
+		utils.BytesHumanReadable(newSize),
+		utils.BytesHumanReadable(result.OriginalSize),
+	)
+
+	result.OutputFileName = outputFileName
+	return result.end(models.ResultReplaced, newSize, "", started), nil
+}
+
+func (result Result) end(kind models.Result, newSize int64, reason string, started time.Time) Result {
+	result.Result = kind
+	result.NewSize = newSize
+	result.Reason = reason
+	result.Duration = time.Since(started).Seconds()
+
+	return result
+}
+
+// Ends the result with an error after the transcode, the transcode is left where it is
+func (result Result) failed(err error, started time.Time) (Result, error) {
+	ResultLog(result.File, models.ResultError, result.OriginalSize, 0).Errorf("Failed processing %s: %s", result.File, err)
+
+	return result.end(models.ResultError, 0, err.Error(), started), err
+}
+
+// Log entry with the fields every result is logged with
+func ResultLog(fileName string, result models.Result, originalSize int64, newSize int64) *log.Entry {
+	return log.WithField("file", fileName).
+		WithField("original_size", originalSize).
+		WithField("new_size", newSize).
+		WithField("result", string(result))
+}
+
+// Fails if the transcode lost its video or is shorter than the original by more than the tolerance in seconds
+func checkOutput(metadata *models.FileMetadata, resultMetadata *models.FileMetadata, tolerance float64) error {
+	if metadata.VideoStream() != nil && resultMetadata.VideoStream() == nil {
+		return errors.New("output has no video stream")
+	}
+
+	if tolerance <= 0 {
+		return nil
+	}
+
+	duration, _ := strconv.ParseFloat(metadata.Format.Duration, 64)
+	resultDuration, _ := strconv.ParseFloat(resultMetadata.Format.Duration, 64)
+
+	if duration > 0 && duration-resultDuration > tolerance {
+		return fmt.Errorf("output is %.2fs long, the original %.2fs", resultDuration, duration)
+	}
+
+	return nil
+}
+
+// Puts the transcode in place of the original, which may have a different extension.
+// The transcode is staged next to the destination first, so a copy from another filesystem
+// fails while the original is still untouched and the last step is a rename.
+func replaceOriginal(fileName string, tempFileName string, destination string, removeOriginal func(string) error) error {
+	staged, err := utils.StageFile(tempFileName, destination)
+
+	if err != nil {
+		return err
+	}
+
+	if fileName == destination && removeOriginal != nil {
+		// The original has to be out of the way before its name is taken, a trash
+		// copies across filesystems before it removes the original
+		if err := removeOriginal(fileName); err != nil {
+			os.Remove(staged)
+			return err
+		}
+
+		if err := os.Rename(staged, destination); err != nil {
+			log.Errorf("Original %s is removed, the transcode is left at %s", fileName, staged)
+			return err
+		}
+
+		return nil
+	}
+
+	// Renaming over an original of the same name replaces it in one step
+	if err := os.Rename(staged, destination); err != nil {
+		os.Remove(staged)
+		return err
+	}
+
+	if fileName == destination {
+		return nil
+	}
+
+	if removeOriginal == nil {
+		return os.Remove(fileName)
+	}
+
+	return removeOriginal(fileName)
+}
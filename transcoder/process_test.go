@@ -0,0 +1,498 @@
+package transcoder
+
+import (
+	"context"
+	"errors"
+	"github.com/Vilsol/transcoder-go/models"
+	"github.com/Vilsol/transcoder-go/transcoder/transcodertest"
+	"github.com/Vilsol/transcoder-go/utils"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Moves the original into the directory like a trash would
+func moveInto(directory string) func(string) error {
+	return func(fileName string) error {
+		return utils.MoveFile(fileName, filepath.Join(directory, filepath.Base(fileName)))
+	}
+}
+
+func TestReplaceOriginal(t *testing.T) {
+	tests := []struct {
+		name        string
+		destination string
+		trash       bool
+	}{
+		{"same name", "video.mkv", false},
+		{"corrected extension", "video.mp4", false},
+		{"same name into the trash", "video.mkv", true},
+		{"corrected extension into the trash", "video.mp4", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			directory := transcodertest.TempDir(t)
+			trashDir := transcodertest.TempDir(t)
+			fileName := filepath.Join(directory, "video.mkv")
+			tempFileName := filepath.Join(transcodertest.TempDir(t), "video.transcode-temp")
+			destination := filepath.Join(directory, test.destination)
+
+			transcodertest.WriteFile(t, fileName, "original")
+			transcodertest.WriteFile(t, tempFileName, "transcoded")
+
+			var removeOriginal func(string) error
+			if test.trash {
+				removeOriginal = moveInto(trashDir)
+			}
+
+			if err := replaceOriginal(fileName, tempFileName, destination, removeOriginal); err != nil {
+				t.Fatal(err)
+			}
+
+			transcodertest.AssertContent(t, destination, "transcoded")
+			transcodertest.AssertMissing(t, tempFileName)
+			transcodertest.AssertMissing(t, utils.StagingPath(destination))
+
+			if fileName != destination {
+				transcodertest.AssertMissing(t, fileName)
+			}
+
+			if test.trash {
+				transcodertest.AssertContent(t, filepath.Join(trashDir, "video.mkv"), "original")
+			}
+		})
+	}
+}
+
+func TestReplaceOriginalFailureKeepsOriginal(t *testing.T) {
+	failedTrash := func(string) error {
+		return errors.New("trash unavailable")
+	}
+
+	tests := []struct {
+		name           string
+		transcode      bool
+		destination    string
+		removeOriginal func(string) error
+	}{
+		{"missing transcode", false, "video.mkv", nil},
+		{"missing transcode into the trash", false, "video.mkv", failedTrash},
+		{"failed trash", true, "video.mkv", failedTrash},
+		{"failed trash with corrected extension", true, "video.mp4", failedTrash},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			directory := transcodertest.TempDir(t)
+			fileName := filepath.Join(directory, "video.mkv")
+			tempFileName := filepath.Join(directory, "video.transcode-temp")
+			destination := filepath.Join(directory, test.destination)
+
+			transcodertest.WriteFile(t, fileName, "original")
+
+			if test.transcode {
+				transcodertest.WriteFile(t, tempFileName, "transcoded")
+			}
+
+			if err := replaceOriginal(fileName, tempFileName, destination, test.removeOriginal); err == nil {
+				t.Fatal("replaceOriginal did not fail")
+			}
+
+			transcodertest.AssertContent(t, fileName, "original")
+		})
+	}
+}
+
+func TestCheckOutput(t *testing.T) {
+	video := models.Stream{CodecType: "video"}
+	audio := models.Stream{CodecType: "audio"}
+
+	metadata := func(duration string, streams ...models.Stream) *models.FileMetadata {
+		return &models.FileMetadata{Streams: streams, Format: models.Format{Duration: duration}}
+	}
+
+	tests := []struct {
+		name      string
+		original  *models.FileMetadata
+		output    *models.FileMetadata
+		tolerance float64
+		wantErr   bool
+	}{
+		{"same", metadata("60", video, audio), metadata("60", video, audio), 2, false},
+		{"within tolerance", metadata("60", video), metadata("58.5", video), 2, false},
+		{"too short", metadata("60", video), metadata("30", video), 2, true},
+		{"tolerance disabled", metadata("60", video), metadata("30", video), 0, false},
+		{"lost video", metadata("60", video, audio), metadata("60", audio), 2, true},
+		{"audio only", metadata("60", audio), metadata("60", audio), 2, false},
+		{"unknown duration", metadata("", video), metadata("30", video), 2, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := checkOutput(test.original, test.output, test.tolerance); (err != nil) != test.wantErr {
+				t.Errorf("checkOutput = %v, want error %t", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func fileSize(t *testing.T, fileName string) int64 {
+	t.Helper()
+
+	stat, err := os.Stat(fileName)
+
+	if os.IsNotExist(err) {
+		return -1
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return stat.Size()
+}
+
+func TestProcessFile(t *testing.T) {
+	const originalSize = 1000
+
+	tests := []struct {
+		name     string
+		fileName string
+		scenario transcodertest.Scenario
+		opts     Options
+		// Size of the result, -1 if it must not exist
+		wantResult    models.Result
+		wantErr       bool
+		wantReason    string
+		wantOriginal  int64
+		wantOutput    int64
+		wantStopped   bool
+		wantFailedOut int64
+	}{
+		{
+			name:         "replaced",
+			fileName:     "video.mkv",
+			scenario:     transcodertest.Scenario{OutputSize: 400},
+			opts:         Options{KeepOld: true, EarlyExit: true},
+			wantResult:   models.ResultReplaced,
+			wantOriginal: 400,
+			wantOutput:   400,
+		},
+		{
+			name:         "replaced with corrected extension",
+			fileName:     "video.mp4",
+			scenario:     transcodertest.Scenario{OutputSize: 400},
+			opts:         Options{KeepOld: true, EarlyExit: true},
+			wantResult:   models.ResultReplaced,
+			wantOriginal: -1,
+			wantOutput:   400,
+		},
+		{
+			name:         "kept source",
+			fileName:     "video.mkv",
+			scenario:     transcodertest.Scenario{OutputSize: 400},
+			opts:         Options{KeepOld: true, KeepSource: true, OutputFileName: "output/video.h265.mkv"},
+			wantResult:   models.ResultReplaced,
+			wantOriginal: originalSize,
+			wantOutput:   400,
+		},
+		{
+			name:         "kept original",
+			fileName:     "video.mkv",
+			scenario:     transcodertest.Scenario{OutputSize: 1500},
+			opts:         Options{KeepOld: true},
+			wantResult:   models.ResultKeepOriginal,
+			wantOriginal: originalSize,
+			wantOutput:   originalSize,
+		},
+		{
+			name:         "kept original without enough savings",
+			fileName:     "video.mkv",
+			scenario:     transcodertest.Scenario{OutputSize: 950},
+			opts:         Options{KeepOld: true, MinSavingsPercent: 10},
+			wantResult:   models.ResultKeepOriginal,
+			wantReason:   "Savings 5.00% below 10.00%",
+			wantOriginal: originalSize,
+			wantOutput:   originalSize,
+		},
+		{
+			name:     "kept original when declined",
+			fileName: "video.mkv",
+			scenario: transcodertest.Scenario{OutputSize: 400},
+			opts: Options{ConfirmReplace: func(originalSize int64, newSize int64) bool {
+				return false
+			}},
+			wantResult:   models.ResultKeepOriginal,
+			wantReason:   "Replacement declined",
+			wantOriginal: originalSize,
+			wantOutput:   originalSize,
+		},
+		{
+			name:         "kept original below the VMAF",
+			fileName:     "video.mkv",
+			scenario:     transcodertest.Scenario{OutputSize: 400, VMAF: 80},
+			opts:         Options{MinVMAF: 90},
+			wantResult:   models.ResultKeepOriginal,
+			wantReason:   "VMAF 80.00 below 90.00",
+			wantOriginal: originalSize,
+			wantOutput:   originalSize,
+		},
+		{
+			name:         "replaced above the VMAF",
+			fileName:     "video.mkv",
+			scenario:     transcodertest.Scenario{OutputSize: 400, VMAF: 95},
+			opts:         Options{MinVMAF: 90},
+			wantResult:   models.ResultReplaced,
+			wantOriginal: 400,
+			wantOutput:   400,
+		},
+		{
+			name:         "error computing the VMAF",
+			fileName:     "video.mkv",
+			scenario:     transcodertest.Scenario{OutputSize: 400},
+			opts:         Options{MinVMAF: 90},
+			wantResult:   models.ResultError,
+			wantErr:      true,
+			wantReason:   "VMAF could not be computed: ffmpeg exited: exit status 1: No such filter: 'libvmaf'",
+			wantOriginal: originalSize,
+			wantOutput:   originalSize,
+		},
+		{
+			name:         "skipped larger",
+			fileName:     "video.mkv",
+			scenario:     transcodertest.Scenario{OutputSize: 1500, Hang: true},
+			opts:         Options{KeepOld: true, EarlyExit: true},
+			wantResult:   models.ResultKeepOriginal,
+			wantOriginal: originalSize,
+			wantOutput:   originalSize,
+			wantStopped:  true,
+		},
+		{
+			name:         "replaced when bigger without keep-old",
+			fileName:     "video.mkv",
+			scenario:     transcodertest.Scenario{OutputSize: 1500},
+			opts:         Options{EarlyExit: true},
+			wantResult:   models.ResultReplaced,
+			wantOriginal: 1500,
+			wantOutput:   1500,
+		},
+		{
+			name:         "error",
+			fileName:     "video.mkv",
+			scenario:     transcodertest.Scenario{OutputSize: 400, ExitCode: 1, Stderr: "Invalid data found when processing input"},
+			opts:         Options{KeepOld: true, Retries: 1, RetryBackoff: time.Millisecond},
+			wantResult:   models.ResultError,
+			wantErr:      true,
+			wantReason:   "ffmpeg exited with code 1: Invalid data found when processing input",
+			wantOriginal: originalSize,
+			wantOutput:   originalSize,
+		},
+		{
+			name:          "error keeping the failed transcode",
+			fileName:      "video.mkv",
+			scenario:      transcodertest.Scenario{OutputSize: 400, ExitCode: 1},
+			opts:          Options{KeepFailed: true},
+			wantResult:    models.ResultError,
+			wantErr:       true,
+			wantReason:    "ffmpeg exited with code 1",
+			wantOriginal:  originalSize,
+			wantOutput:    originalSize,
+			wantFailedOut: 400,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useFakeExecutor(t, transcodertest.Scenario{
+				OutputSize: test.scenario.OutputSize,
+				Duration:   "60",
+				ExitCode:   test.scenario.ExitCode,
+				Stderr:     test.scenario.Stderr,
+				Hang:       test.scenario.Hang,
+				VMAF:       test.scenario.VMAF,
+			})
+
+			directory := transcodertest.TempDir(t)
+			fileName := filepath.Join(directory, test.fileName)
+			transcodertest.WriteFile(t, fileName, string(make([]byte, originalSize)))
+
+			opts := test.opts
+			opts.TempFileName = filepath.Join(transcodertest.TempDir(t), "video.transcode-temp")
+
+			outputFileName := filepath.Join(directory, "video.mkv")
+			if opts.OutputFileName != "" {
+				outputFileName = filepath.Join(directory, opts.OutputFileName)
+				opts.OutputFileName = outputFileName
+			}
+
+			reports := 0
+			opts.OnProgress = func(report *models.ProgressReport) {
+				reports++
+			}
+
+			result, err := ProcessFile(context.Background(), fileName, opts)
+
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ProcessFile error = %v, want error %t", err, test.wantErr)
+			}
+
+			if result.Result != test.wantResult {
+				t.Errorf("result = %s, want %s", result.Result, test.wantResult)
+			}
+
+			if result.Reason != test.wantReason {
+				t.Errorf("reason = %q, want %q", result.Reason, test.wantReason)
+			}
+
+			if result.OriginalSize != originalSize {
+				t.Errorf("original size = %d, want %d", result.OriginalSize, originalSize)
+			}
+
+			if reports == 0 {
+				t.Error("no progress reported")
+			}
+
+			if test.wantStopped != (result.NewMetadata == nil && result.Result == models.ResultKeepOriginal) {
+				t.Errorf("stopped early = %t, want %t", !test.wantStopped, test.wantStopped)
+			}
+
+			if test.wantStopped && result.NewSize <= originalSize {
+				t.Errorf("new size of the stopped transcode = %d, want more than %d", result.NewSize, originalSize)
+			}
+
+			if size := fileSize(t, fileName); size != test.wantOriginal {
+				t.Errorf("original is %d bytes, want %d", size, test.wantOriginal)
+			}
+
+			if size := fileSize(t, outputFileName); size != test.wantOutput {
+				t.Errorf("output is %d bytes, want %d", size, test.wantOutput)
+			}
+
+			if test.wantResult == models.ResultReplaced && result.OutputFileName != outputFileName {
+				t.Errorf("output file = %s, want %s", result.OutputFileName, outputFileName)
+			}
+
+			if failed := fileSize(t, fileName+".failed"); test.wantFailedOut != 0 && failed != test.wantFailedOut {
+				t.Errorf("failed transcode is %d bytes, want %d", failed, test.wantFailedOut)
+			}
+
+			transcodertest.AssertMissing(t, opts.TempFileName)
+		})
+	}
+}
+
+// ffprobe failing is a failed file rather than the end of the batch
+func TestProcessFileUnreadableMetadata(t *testing.T) {
+	tests := []struct {
+		name      string
+		failProbe string
+	}{
+		{"original", "video.mkv"},
+		{"transcode", "video.transcode-temp"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useFakeExecutor(t, transcodertest.Scenario{OutputSize: 400, Duration: "60", FailProbe: test.failProbe})
+
+			fileName := filepath.Join(transcodertest.TempDir(t), "video.mkv")
+			transcodertest.WriteFile(t, fileName, string(make([]byte, 1000)))
+
+			opts := Options{TempFileName: filepath.Join(transcodertest.TempDir(t), "video.transcode-temp")}
+
+			result, err := ProcessFile(context.Background(), fileName, opts)
+
+			if err == nil {
+				t.Fatal("expected the error of ffprobe")
+			}
+
+			if result.Result != models.ResultError {
+				t.Errorf("result = %s, want %s", result.Result, models.ResultError)
+			}
+
+			if result.NewMetadata != nil {
+				t.Error("expected no metadata of the transcode")
+			}
+
+			if size := fileSize(t, fileName); size != 1000 {
+				t.Errorf("original is %d bytes, want 1000", size)
+			}
+
+			transcodertest.AssertMissing(t, opts.TempFileName)
+		})
+	}
+}
+
+func TestProcessFileInterrupted(t *testing.T) {
+	useFakeExecutor(t, transcodertest.Scenario{OutputSize: 400, Duration: "60", Hang: true})
+
+	fileName := filepath.Join(transcodertest.TempDir(t), "video.mkv")
+	transcodertest.WriteFile(t, fileName, string(make([]byte, 1000)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	opts := Options{
+		TempFileName: filepath.Join(transcodertest.TempDir(t), "video.transcode-temp"),
+		// Like a termination signal in the middle of the transcode
+		OnProgress: func(report *models.ProgressReport) {
+			cancel()
+		},
+	}
+
+	result, err := ProcessFile(ctx, fileName, opts)
+
+	if err != ErrInterrupted {
+		t.Fatalf("ProcessFile error = %v, want ErrInterrupted", err)
+	}
+
+	if result.Result != models.ResultError {
+		t.Errorf("result = %s, want %s", result.Result, models.ResultError)
+	}
+
+	if size := fileSize(t, fileName); size != 1000 {
+		t.Errorf("original is %d bytes, want 1000", size)
+	}
+
+	transcodertest.AssertMissing(t, opts.TempFileName)
+}
+
+func TestProcessFileTimeout(t *testing.T) {
+	// ffmpeg never finishes on its own, like a transcode stuck on a broken input
+	useFakeExecutor(t, transcodertest.Scenario{OutputSize: 400, Duration: "60", Hang: true})
+
+	fileName := filepath.Join(transcodertest.TempDir(t), "video.mkv")
+	transcodertest.WriteFile(t, fileName, string(make([]byte, 1000)))
+
+	opts := Options{
+		TempFileName: filepath.Join(transcodertest.TempDir(t), "video.transcode-temp"),
+		Timeout:      200 * time.Millisecond,
+	}
+
+	started := time.Now()
+	result, err := ProcessFile(context.Background(), fileName, opts)
+
+	if err != context.DeadlineExceeded {
+		t.Fatalf("ProcessFile error = %v, want context.DeadlineExceeded", err)
+	}
+
+	if elapsed := time.Since(started); elapsed > 30*time.Second {
+		t.Errorf("took %s to time out", elapsed)
+	}
+
+	if result.Result != models.ResultError {
+		t.Errorf("result = %s, want %s", result.Result, models.ResultError)
+	}
+
+	if result.Reason != "Timed out after 200ms" {
+		t.Errorf("reason = %q, want %q", result.Reason, "Timed out after 200ms")
+	}
+
+	if size := fileSize(t, fileName); size != 1000 {
+		t.Errorf("original is %d bytes, want 1000", size)
+	}
+
+	transcodertest.AssertMissing(t, opts.TempFileName)
+}
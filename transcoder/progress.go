@@ -0,0 +1,114 @@
+package transcoder
+
+import (
+	"fmt"
+	"github.com/Vilsol/transcoder-go/metrics"
+	"github.com/Vilsol/transcoder-go/models"
+	"github.com/Vilsol/transcoder-go/notifications"
+	"github.com/Vilsol/transcoder-go/utils"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const progressBarWidth = 30
+
+// Consumes the progress of a transcode for the CLI: notifications, metrics and the progress bar or periodic logs
+type CLIProgress struct {
+	fileName    string
+	metadata    *models.FileMetadata
+	job         *notifications.Job
+	progressBar bool
+	lastLog     int64
+}
+
+func NewCLIProgress(fileName string, metadata *models.FileMetadata, job *notifications.Job) *CLIProgress {
+	return &CLIProgress{
+		fileName: fileName,
+		metadata: metadata,
+		job:      job,
+		// The results own stdout in json mode, and quiet runs only want to hear about problems
+		progressBar: viper.GetBool("progress-bar") && !viper.GetBool("json-results") && log.IsLevelEnabled(log.InfoLevel) && utils.IsTerminal(os.Stdout),
+	}
+}
+
+func (progress *CLIProgress) Report(report *models.ProgressReport) {
+	progress.job.NotifyProgressStatus(report)
+	metrics.SetProgress(progress.fileName, report)
+
+	if progress.progressBar {
+		renderProgressBar(progress.fileName, report, progress.metadata)
+	} else if time.Now().Unix()-progress.lastLog > int64(viper.GetInt("interval")) {
+		report.Log(progress.fileName)
+		progress.lastLog = time.Now().Unix()
+	}
+}
+
+// Ends the progress bar line and drops the file from the metrics, once the transcode returned
+func (progress *CLIProgress) Finish() {
+	metrics.RemoveProgress(progress.fileName)
+
+	if progress.progressBar {
+		finishProgressBar()
+	}
+}
+
+// Remaining encode time based on the position in the video and the encode speed
+func estimateETA(report *models.ProgressReport, metadata *models.FileMetadata) time.Duration {
+	if metadata == nil || report.Speed <= 0 {
+		return 0
+	}
+
+	duration, _ := strconv.ParseFloat(metadata.Format.Duration, 64)
+
+	if duration <= report.OutTime {
+		return 0
+	}
+
+	return time.Duration((duration - report.OutTime) / report.Speed * float64(time.Second))
+}
+
+// Redraws the current line with the progress of the file
+func renderProgressBar(fileName string, report *models.ProgressReport, metadata *models.FileMetadata) {
+	duration := 0.0
+	if metadata != nil {
+		duration, _ = strconv.ParseFloat(metadata.Format.Duration, 64)
+	}
+
+	complete := 0.0
+	if duration > 0 {
+		complete = report.OutTime / duration
+	}
+
+	if complete > 1 {
+		complete = 1
+	} else if complete < 0 {
+		complete = 0
+	}
+
+	eta := "?"
+	if report.ETA > 0 {
+		eta = report.ETA.Truncate(time.Second).String()
+	}
+
+	filled := int(complete * progressBarWidth)
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled)
+
+	// \033[K clears what is left of a longer previous line
+	_, _ = fmt.Fprintf(os.Stdout, "\r%s [%s] %5.1f%% %.1f fps %.2fx ETA %s\033[K",
+		filepath.Base(fileName),
+		bar,
+		complete*100,
+		report.FPS,
+		report.Speed,
+		eta,
+	)
+}
+
+func finishProgressBar() {
+	_, _ = fmt.Fprintln(os.Stdout)
+}
@@ -0,0 +1,152 @@
+package transcoder
+
+import (
+	"context"
+	"fmt"
+	"github.com/Vilsol/transcoder-go/config"
+	"github.com/Vilsol/transcoder-go/models"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Anything quieter counts as silence
+const silenceNoise = "-50dB"
+
+// Chapters shorter than this are merged into the previous one, so a pause within a song does not split it
+const autoChapterMinimum = 10.0
+
+var silenceEndRegex = regexp.MustCompile("silence_end: (-?[0-9.]+)")
+
+// Runs silencedetect on the first audio stream and returns the times at which silences of at least minDuration seconds end
+func DetectSilences(fileName string, minDuration float64) ([]float64, error) {
+	params := []string{
+		"-hide_banner",
+		"-i", fileName,
+		"-map", "0:a:0",
+		"-af", "silencedetect=noise=" + silenceNoise + ":d=" + strconv.FormatFloat(minDuration, 'f', -1, 64),
+		"-vn", "-sn",
+		"-f", "null", "-",
+	}
+
+	log.Tracef("Executing %s %s", FFmpegBinary(), strings.Join(params, " "))
+
+	// silencedetect reports on stderr
+	output, err := executor.Command(context.Background(), FFmpegBinary(), params...).CombinedOutput()
+
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg exited: %s", err)
+	}
+
+	ends := make([]float64, 0)
+
+	for _, match := range silenceEndRegex.FindAllStringSubmatch(string(output), -1) {
+		end, err := strconv.ParseFloat(match[1], 64)
+
+		if err == nil {
+			ends = append(ends, end)
+		}
+	}
+
+	return ends, nil
+}
+
+// Returns the chapter starts for the silences, every chapter is at least autoChapterMinimum long
+func chapterStarts(silenceEnds []float64, duration float64) []float64 {
+	starts := []float64{0}
+
+	for _, end := range silenceEnds {
+		if end-starts[len(starts)-1] < autoChapterMinimum || duration-end < autoChapterMinimum {
+			continue
+		}
+
+		starts = append(starts, end)
+	}
+
+	return starts
+}
+
+// Writes the chapters in the FFMETADATA format ffmpeg reads as an input
+func writeChapters(chaptersFileName string, starts []float64, duration float64) error {
+	builder := strings.Builder{}
+	builder.WriteString(";FFMETADATA1\n")
+
+	for i, start := range starts {
+		end := duration
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+
+		builder.WriteString("[CHAPTER]\nTIMEBASE=1/1000\n")
+		builder.WriteString(fmt.Sprintf("START=%d\nEND=%d\n", int64(start*1000), int64(end*1000)))
+		builder.WriteString(fmt.Sprintf("title=Chapter %d\n", i+1))
+	}
+
+	return ioutil.WriteFile(chaptersFileName, []byte(builder.String()), 0644)
+}
+
+// Chapters generated for a transcode, next to its temp file
+func chaptersFilePath(tempFileName string) string {
+	return tempFileName + ".chapters"
+}
+
+// Deletes the chapters generated for a transcode, if there are any
+func RemoveChapters(tempFileName string) {
+	err := os.Remove(chaptersFilePath(tempFileName))
+
+	if err != nil && !os.IsNotExist(err) {
+		log.Errorf("Error deleting file %s: %s", chaptersFilePath(tempFileName), err)
+	}
+}
+
+// Generates chapters at long silences for files without chapters with auto-chapters.
+// Returns the file to add as an input, empty if there are no chapters to add.
+func buildAutoChapters(fileName string, tempFileName string, metadata *models.FileMetadata, analyze bool) string {
+	if !config.GetBool(fileName, "auto-chapters") || metadata == nil || len(metadata.Chapters) > 0 {
+		return ""
+	}
+
+	if len(metadata.AudioStreams()) == 0 {
+		log.Warningf("Not generating chapters for a file without audio: %s", fileName)
+		return ""
+	}
+
+	duration, _ := strconv.ParseFloat(metadata.Format.Duration, 64)
+
+	if duration <= 0 {
+		log.Warningf("Not generating chapters for a file with unknown duration: %s", fileName)
+		return ""
+	}
+
+	if !analyze {
+		return placeholder("chapters at silences")
+	}
+
+	silenceEnds, err := DetectSilences(fileName, config.GetFloat64(fileName, "auto-chapters-silence"))
+
+	if err != nil {
+		log.Warningf("Error detecting silences of %s: %s", fileName, err)
+		return ""
+	}
+
+	starts := chapterStarts(silenceEnds, duration)
+
+	if len(starts) < 2 {
+		log.Infof("No silences long enough for chapters: %s", fileName)
+		return ""
+	}
+
+	chaptersFileName := chaptersFilePath(tempFileName)
+
+	if err := writeChapters(chaptersFileName, starts, duration); err != nil {
+		log.Errorf("Error writing file %s: %s", chaptersFileName, err)
+		return ""
+	}
+
+	log.Infof("Adding %d chapters at silences: %s", len(starts), fileName)
+
+	return chaptersFileName
+}
@@ -0,0 +1,99 @@
+package transcoder
+
+import (
+	"github.com/Vilsol/transcoder-go/config"
+	"github.com/Vilsol/transcoder-go/models"
+	log "github.com/sirupsen/logrus"
+	"strconv"
+)
+
+var textSubtitleCodecs = map[string]bool{
+	"ass":      true,
+	"mov_text": true,
+	"ssa":      true,
+	"subrip":   true,
+	"text":     true,
+	"webvtt":   true,
+}
+
+// Text subtitle codec each container supports, containers missing here take any subtitle
+var containerSubtitleCodecs = map[string]string{
+	"mp4":  "mov_text",
+	"webm": "webvtt",
+}
+
+// Whether keep-subtitle-langs keeps the subtitle stream, all of them are kept without the flag
+func keepsSubtitleStream(fileName string, stream models.Stream) bool {
+	languages := config.GetStringSlice(fileName, "keep-subtitle-langs")
+
+	if len(languages) == 0 {
+		return true
+	}
+
+	if stream.IsForced() && config.GetBool(fileName, "keep-forced-subs") {
+		return true
+	}
+
+	if stream.LanguageCode() == "" {
+		return !config.GetBool(fileName, "drop-untagged")
+	}
+
+	for _, language := range languages {
+		if models.NormalizeLanguage(language) == stream.LanguageCode() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the subtitle flags for every subtitle stream, dropped streams get a negative map
+func buildSubtitleFlags(fileName string, metadata *models.FileMetadata, container string) []string {
+	mode := config.GetString(fileName, "subtitles")
+
+	if mode == "drop" {
+		return []string{"-sn"}
+	}
+
+	if metadata == nil {
+		return []string{}
+	}
+
+	codec, convert := containerSubtitleCodecs[container]
+	convert = convert && mode == "convert"
+
+	flags := make([]string, 0)
+
+	// Dropped streams shift the output indexes the codec flags refer to
+	output := 0
+
+	for i, stream := range metadata.SubtitleStreams() {
+		if !keepsSubtitleStream(fileName, stream) {
+			log.Infof("Dropping subtitle stream %d (%s, %s): %s", i, stream.CodecName, languageName(stream.Language()), fileName)
+			flags = append(flags, "-map", "-0:s:"+strconv.Itoa(i))
+			continue
+		}
+
+		if convert && !textSubtitleCodecs[stream.CodecName] {
+			log.Warningf("Dropping subtitle stream %d (%s) unsupported by %s: %s", i, stream.CodecName, container, fileName)
+			flags = append(flags, "-map", "-0:s:"+strconv.Itoa(i))
+			continue
+		}
+
+		if len(config.GetStringSlice(fileName, "keep-subtitle-langs")) > 0 {
+			log.Infof("Keeping subtitle stream %d (%s, %s): %s", i, stream.CodecName, languageName(stream.Language()), fileName)
+		}
+
+		if convert {
+			if stream.CodecName != codec {
+				log.Infof("Converting subtitle stream %d (%s) to %s: %s", i, stream.CodecName, codec, fileName)
+			}
+
+			flags = append(flags, "-c:s:"+strconv.Itoa(output), codec)
+		}
+
+		output++
+	}
+
+	return flags
+}
@@ -0,0 +1,214 @@
+package transcoder
+
+import (
+	"fmt"
+	"github.com/Vilsol/transcoder-go/config"
+	"github.com/Vilsol/transcoder-go/models"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"strconv"
+	"strings"
+)
+
+// Assumed audio bitrate when neither the flags nor the source have one
+const defaultAudioBitrate = 128000
+
+// Fails if the target size mode is combined with an explicit crf or video bitrate.
+// Directory configs can still change either side, checkTargetSize catches those per file.
+func CheckTargetSize() error {
+	if viper.GetFloat64("target-size-mb") <= 0 {
+		return nil
+	}
+
+	for _, baseFlags := range append(AllBaseFlags(), viper.GetString("extra-flags")) {
+		flags, err := splitFlags(baseFlags)
+
+		if err != nil {
+			return err
+		}
+
+		if err := checkRateControl(flags); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Fails if the file has a target size and its base or extra flags set the rate control themselves
+func checkTargetSize(fileName string, baseFlags []string, extraFlags []string) error {
+	if config.GetFloat64(fileName, "target-size-mb") <= 0 {
+		return nil
+	}
+
+	if err := checkRateControl(baseFlags); err != nil {
+		return err
+	}
+
+	return checkRateControl(extraFlags)
+}
+
+func checkRateControl(flags []string) error {
+	for _, flag := range flags {
+		if flag == "-crf" || flag == "-b:v" {
+			return fmt.Errorf("target-size-mb can not be combined with %s, remove it from the flags", flag)
+		}
+	}
+
+	return nil
+}
+
+// Replaces the quality based rate control with a bitrate that hits the target size.
+// The flags have to include the audio flags, they decide how much of the size the audio takes.
+func applyTargetSize(fileName string, flags []string, metadata *models.FileMetadata) []string {
+	targetSize := config.GetFloat64(fileName, "target-size-mb")
+
+	if targetSize <= 0 || metadata == nil {
+		return flags
+	}
+
+	duration, _ := strconv.ParseFloat(metadata.Format.Duration, 64)
+
+	if duration <= 0 {
+		log.Warningf("Unknown duration, ignoring target size: %s", metadata.Format.Filename)
+		return flags
+	}
+
+	result := make([]string, 0, len(flags))
+
+	for i := 0; i < len(flags); i++ {
+		if i+1 < len(flags) && (flags[i] == "-x265-params" || flags[i] == "-x264-params") {
+			params := removeParam(flags[i+1], "crf")
+			if params != "" {
+				result = append(result, flags[i], params)
+			}
+			i++
+			continue
+		}
+
+		result = append(result, flags[i])
+	}
+
+	totalBitrate := int64(targetSize * 1000 * 1000 * 8 / duration)
+	videoBitrate := totalBitrate - audioBudget(fileName, flags, metadata)
+
+	if videoBitrate <= 0 {
+		log.Warningf("Target size too small for audio tracks, ignoring target size: %s", metadata.Format.Filename)
+		return flags
+	}
+
+	log.Infof("Target bitrate %dk: %s", videoBitrate/1000, metadata.Format.Filename)
+
+	return append(result, "-b:v", strconv.FormatInt(videoBitrate/1000, 10)+"k")
+}
+
+// Bitrate of the audio streams in the output, copied ones keep the bitrate of the source
+func audioBudget(fileName string, flags []string, metadata *models.FileMetadata) int64 {
+	// The mandatory -c copy applies to the streams no flag sets a codec for
+	codec := "copy"
+	bitrate := int64(defaultAudioBitrate)
+
+	// Per output stream, they win over the flags for every audio stream
+	streamCodecs := make(map[string]string)
+	streamBitrates := make(map[string]int64)
+
+	for i := 0; i < len(flags); i++ {
+		if flags[i] == "-an" {
+			return 0
+		}
+
+		if i+1 >= len(flags) {
+			break
+		}
+
+		switch {
+		case flags[i] == "-c:a" || flags[i] == "-acodec":
+			codec = flags[i+1]
+		case strings.HasPrefix(flags[i], "-c:a:"):
+			streamCodecs[strings.TrimPrefix(flags[i], "-c:a:")] = flags[i+1]
+		case flags[i] == "-b:a":
+			if value, err := parseBitrate(flags[i+1]); err == nil {
+				bitrate = value
+			}
+		case strings.HasPrefix(flags[i], "-b:a:"):
+			if value, err := parseBitrate(flags[i+1]); err == nil {
+				streamBitrates[strings.TrimPrefix(flags[i], "-b:a:")] = value
+			}
+		}
+	}
+
+	streams := metadata.AudioStreams()
+	budget := int64(0)
+
+	// Dropped streams take up nothing, the kept ones are numbered by their position in the output
+	for output, i := range keptAudioStreams(fileName, metadata) {
+		index := strconv.Itoa(output)
+
+		streamCodec := codec
+		if value, ok := streamCodecs[index]; ok {
+			streamCodec = value
+		}
+
+		if streamCodec == "copy" {
+			if source := sourceBitrate(streams[i]); source > 0 {
+				budget += source
+				continue
+			}
+
+			log.Warningf("Unknown bitrate of copied audio stream %d, assuming %dk: %s", i, defaultAudioBitrate/1000, fileName)
+			budget += defaultAudioBitrate
+			continue
+		}
+
+		if value, ok := streamBitrates[index]; ok {
+			budget += value
+		} else {
+			budget += bitrate
+		}
+	}
+
+	return budget
+}
+
+// Matroska has the bitrate of a stream in its BPS tag instead
+func sourceBitrate(stream models.Stream) int64 {
+	if bitrate := stream.BitRateInt(); bitrate > 0 {
+		return bitrate
+	}
+
+	bitrate, _ := strconv.ParseInt(stream.Tags["BPS"], 10, 64)
+	return bitrate
+}
+
+func removeParam(params string, name string) string {
+	kept := make([]string, 0)
+
+	for _, param := range strings.Split(params, ":") {
+		if param != "" && !strings.HasPrefix(param, name+"=") {
+			kept = append(kept, param)
+		}
+	}
+
+	return strings.Join(kept, ":")
+}
+
+func parseBitrate(value string) (int64, error) {
+	multiplier := int64(1)
+
+	switch {
+	case strings.HasSuffix(value, "k"):
+		multiplier = 1000
+		break
+	case strings.HasSuffix(value, "M"):
+		multiplier = 1000 * 1000
+		break
+	}
+
+	bitrate, err := strconv.ParseFloat(strings.TrimRight(value, "kM"), 64)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(bitrate * float64(multiplier)), nil
+}
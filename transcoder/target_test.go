@@ -0,0 +1,170 @@
+package transcoder
+
+import (
+	"github.com/Vilsol/transcoder-go/config"
+	"github.com/Vilsol/transcoder-go/models"
+	"github.com/Vilsol/transcoder-go/transcoder/transcodertest"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckTargetSizePerFile(t *testing.T) {
+	tests := []struct {
+		name            string
+		flags           string
+		extraFlags      string
+		targetSize      float64
+		directoryConfig string
+		wantErr         bool
+	}{
+		{"crf without target size", "-c:v libx265 -crf 20", "", 0, "", false},
+		{"params crf is replaced", DefaultFlags, "", 500, "", false},
+		{"crf in the flags", "-c:v libx265 -crf 20", "", 500, "", true},
+		{"bitrate in the flags", "-c:v libx265 -b:v 2M", "", 500, "", true},
+		{"bitrate in the extra flags", DefaultFlags, "-b:v 2M", 500, "", true},
+		{"target size from the directory", "-c:v libx265 -crf 20", "", 0, "target-size-mb: 500\n", true},
+		{"crf from the directory", DefaultFlags, "", 500, "flags: -c:v libx265 -crf 20\n", true},
+		{"extension flags from the directory", DefaultFlags, "", 500, "flags:\n  mkv: -c:v libx265 -b:v 2M\n", true},
+		{"directory disables the target size", "-c:v libx265 -crf 20", "", 500, "target-size-mb: 0\n", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			viper.Set("flags", test.flags)
+			viper.Set("extra-flags", test.extraFlags)
+			viper.Set("target-size-mb", test.targetSize)
+			defer viper.Set("flags", nil)
+			defer viper.Set("extra-flags", nil)
+			defer viper.Set("target-size-mb", nil)
+
+			directory, err := ioutil.TempDir("", "transcoder")
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer os.RemoveAll(directory)
+
+			if test.directoryConfig != "" {
+				if err := ioutil.WriteFile(filepath.Join(directory, config.DirectoryConfigName), []byte(test.directoryConfig), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			fileName := filepath.Join(directory, "video.mkv")
+
+			_, err = BuildFlags(fileName, fileName+".transcoding", nil)
+
+			if (err != nil) != test.wantErr {
+				t.Errorf("BuildFlags error = %v, want error %t", err, test.wantErr)
+			}
+		})
+	}
+}
+
+// 10 MB in 100 seconds leave 800k for video and audio together
+func TestTargetSizeAudioBudget(t *testing.T) {
+	audio := func(codec string, language string, bitRate string, tags map[string]string) models.Stream {
+		stream := models.Stream{CodecType: "audio", CodecName: codec, BitRate: bitRate, Tags: map[string]string{"language": language}}
+
+		for key, value := range tags {
+			stream.Tags[key] = value
+		}
+
+		return stream
+	}
+
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		streams  []models.Stream
+		// Empty if the target size gets ignored
+		want string
+	}{
+		{
+			name:    "encoded with the base flags",
+			streams: []models.Stream{audio("ac3", "eng", "640000", nil), audio("ac3", "ger", "640000", nil)},
+			want:    "288k",
+		},
+		{
+			name:     "copied at the source bitrate",
+			settings: map[string]interface{}{"audio-mode": "copy"},
+			streams:  []models.Stream{audio("aac", "eng", "192000", nil), audio("ac3", "ger", "", map[string]string{"BPS": "384000"})},
+			want:     "224k",
+		},
+		{
+			name:     "copied without a known bitrate",
+			settings: map[string]interface{}{"audio-mode": "copy"},
+			streams:  []models.Stream{audio("aac", "eng", "", nil)},
+			want:     "672k",
+		},
+		{
+			name:     "copied and encoded",
+			settings: map[string]interface{}{"audio-mode": "copy", "audio-bitrate": "128k"},
+			streams:  []models.Stream{audio("aac", "eng", "192000", nil), audio("pcm_s16le", "ger", "1536000", nil)},
+			want:     "480k",
+		},
+		{
+			name:     "encoded with the audio mode",
+			settings: map[string]interface{}{"audio-mode": "opus", "audio-bitrate": "96k"},
+			streams:  []models.Stream{audio("ac3", "eng", "640000", nil), audio("ac3", "ger", "640000", nil)},
+			want:     "608k",
+		},
+		{
+			name:     "dropped by keep-audio-langs",
+			settings: map[string]interface{}{"keep-audio-langs": []string{"eng"}},
+			streams:  []models.Stream{audio("ac3", "eng", "640000", nil), audio("ac3", "ger", "640000", nil)},
+			want:     "544k",
+		},
+		{
+			name:     "copies too big for the target",
+			settings: map[string]interface{}{"audio-mode": "copy"},
+			streams:  []models.Stream{audio("truehd", "eng", "4000000", nil)},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			viper.Set("target-size-mb", 10)
+
+			for key, value := range test.settings {
+				viper.Set(key, value)
+			}
+
+			t.Cleanup(func() {
+				viper.Set("target-size-mb", nil)
+
+				for key := range test.settings {
+					viper.Set(key, nil)
+				}
+			})
+
+			fileName := filepath.Join(transcodertest.TempDir(t), "video.mkv")
+
+			metadata := &models.FileMetadata{
+				Streams: append([]models.Stream{{CodecType: "video", CodecName: "h264"}}, test.streams...),
+				Format:  models.Format{Filename: fileName, Duration: "100"},
+			}
+
+			flags, err := BuildFlags(fileName, fileName+".transcoding", metadata)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := ""
+			for i := 0; i+1 < len(flags); i++ {
+				if flags[i] == "-b:v" {
+					got = flags[i+1]
+				}
+			}
+
+			if got != test.want {
+				t.Errorf("-b:v = %q, want %q in %q", got, test.want, flags)
+			}
+		})
+	}
+}
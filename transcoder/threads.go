@@ -0,0 +1,37 @@
+package transcoder
+
+import (
+	"github.com/spf13/viper"
+	"strconv"
+	"strings"
+)
+
+// Limits the threads of a single ffmpeg process, every worker gets its own limit.
+// libx265 ignores -threads, so its thread pool is limited through the x265 params instead.
+// Faster presets parallelize worse, so they lose less speed from a low limit than slower ones.
+func applyThreads(flags []string) []string {
+	threads := viper.GetInt("threads")
+
+	if threads <= 0 {
+		return flags
+	}
+
+	result := make([]string, 0, len(flags)+2)
+
+	for i := 0; i < len(flags); i++ {
+		result = append(result, flags[i])
+
+		if flags[i] == "-x265-params" && i+1 < len(flags) {
+			value := flags[i+1]
+
+			if !strings.Contains(value, "pools=") {
+				value += ":pools=" + strconv.Itoa(threads)
+			}
+
+			result = append(result, value)
+			i++
+		}
+	}
+
+	return append(result, "-threads", strconv.Itoa(threads))
+}
@@ -0,0 +1,48 @@
+package transcoder
+
+import (
+	"context"
+	"fmt"
+	"github.com/Vilsol/transcoder-go/models"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Relative position in the file the thumbnail is taken from, early enough to skip intros most of the time
+const thumbnailPoint = 0.1
+
+const thumbnailWidth = 640
+
+// Extracts a small JPEG screenshot of the file, the thumbnail file is deleted once it has been read
+func ExtractThumbnail(fileName string, thumbnailFileName string, metadata *models.FileMetadata) ([]byte, error) {
+	duration, _ := strconv.ParseFloat(metadata.Format.Duration, 64)
+
+	params := []string{
+		"-v", "error",
+		"-y",
+		"-ss", strconv.FormatFloat(duration*thumbnailPoint, 'f', 2, 64),
+		"-i", fileName,
+		"-frames:v", "1",
+		"-vf", "scale=" + strconv.Itoa(thumbnailWidth) + ":-2",
+		"-q:v", "4",
+		"-f", "image2",
+		thumbnailFileName,
+	}
+
+	log.Tracef("Executing %s %s", FFmpegBinary(), strings.Join(params, " "))
+
+	defer func() {
+		if err := os.Remove(thumbnailFileName); err != nil && !os.IsNotExist(err) {
+			log.Errorf("Error deleting file %s: %s", thumbnailFileName, err)
+		}
+	}()
+
+	if output, err := executor.Command(context.Background(), FFmpegBinary(), params...).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("ffmpeg exited: %s: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	return ioutil.ReadFile(thumbnailFileName)
+}
@@ -1,44 +1,129 @@
 package transcoder
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Vilsol/transcoder-go/config"
 	"github.com/Vilsol/transcoder-go/models"
-	"github.com/Vilsol/transcoder-go/notifications"
+	"github.com/Vilsol/transcoder-go/utils"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 	"io"
 	"os"
 	"os/exec"
-	"os/signal"
 	"regexp"
-	"runtime"
 	"strconv"
 	"strings"
 	"syscall"
-	"time"
 )
 
-var lastReport *models.ProgressReport
+func BuildFlags(fileName string, tempFileName string, metadata *models.FileMetadata) ([]string, error) {
+	return buildFlags(fileName, tempFileName, metadata, true)
+}
 
-func BuildFlags(fileName string, tempFileName string, metadata *models.FileMetadata) []string {
-	finalFlags := make([]string, 0)
+// Builds the flags, without analyze the passes measuring the file are skipped and
+// placeholders stand in for their results, so nothing is read or written
+func buildFlags(fileName string, tempFileName string, metadata *models.FileMetadata, analyze bool) ([]string, error) {
+	baseFlags, err := splitFlags(BaseFlags(fileName))
+
+	if err != nil {
+		return nil, err
+	}
+
+	extra, extraFilters, err := extraFlags(fileName)
+
+	if err != nil {
+		return nil, err
+	}
 
-	if viper.GetBool("nice") && runtime.GOOS == "linux" {
-		finalFlags = append(finalFlags, "ffmpeg")
+	if err := checkTargetSize(fileName, baseFlags, extra); err != nil {
+		return nil, err
 	}
 
+	finalFlags := make([]string, 0)
+
+	// Hardware decoding has to be set up before the input
+	finalFlags = append(finalFlags, hardwareInputFlags()...)
+
 	// The input file
 	finalFlags = append(finalFlags, "-y", "-i", fileName)
 
-	if !viper.GetBool("stderr") {
-		// Add quiet flag
-		finalFlags = append(finalFlags, "-v", "quiet")
+	// Generated chapters are a second input, so everything else keeps mapping from the first
+	chaptersFileName := buildAutoChapters(fileName, tempFileName, metadata, analyze)
+
+	if chaptersFileName != "" {
+		finalFlags = append(finalFlags, "-f", "ffmetadata", "-i", chaptersFileName)
+	}
+
+	// Progress is read from -progress on stdout, so the log level only changes what ends up on stderr
+	if viper.GetBool("stderr") {
+		finalFlags = append(finalFlags, "-hide_banner", "-loglevel", viper.GetString("ffmpeg-loglevel"))
+	} else {
+		// Only errors, so they can be reported when ffmpeg fails
+		finalFlags = append(finalFlags, "-hide_banner", "-loglevel", "error")
 	}
 
 	// Mandatory flags
-	finalFlags = append(finalFlags, "-c", "copy", "-f", "matroska", "-progress", "-")
+	finalFlags = append(finalFlags, "-c", "copy", "-f", OutputContainer().Format, "-progress", "pipe:1")
+
+	// Before the configurable flags, so a -movflags in the base flags still wins
+	finalFlags = append(finalFlags, buildMetadataFlags(fileName, metadata, OutputContainer().Format, chaptersFileName != "")...)
 
 	// Configurable flags
-	finalFlags = append(finalFlags, strings.Split(viper.GetString("flags"), " ")...)
+	flags := baseFlags
+
+	if audioFlags := buildAudioFlags(fileName, metadata); audioFlags != nil {
+		flags = append(stripAudioFlags(flags), audioFlags...)
+	}
+
+	flags = applyTargetSize(fileName, flags, metadata)
+
+	flags = applyHDR(fileName, flags, metadata, analyze)
+
+	finalFlags = append(finalFlags, applyThreads(applyHardwareAcceleration(flags))...)
+
+	// After the -map of the base flags, which the negative maps take streams out of
+	finalFlags = append(finalFlags, buildAudioMapFlags(fileName, metadata)...)
+	finalFlags = append(finalFlags, buildLoudnormFlags(fileName, metadata, analyze)...)
+
+	finalFlags = append(finalFlags, buildSubtitleFlags(fileName, metadata, OutputContainer().Format)...)
+
+	videoFilters := make([]string, 0)
+
+	// Deinterlaced first, cropdetect and scaling work on whole frames
+	if deinterlace := buildDeinterlaceFilter(fileName, metadata, analyze); deinterlace != "" {
+		videoFilters = append(videoFilters, deinterlace)
+	}
+
+	if config.GetBool(fileName, "autocrop") && metadata != nil {
+		if !analyze {
+			videoFilters = append(videoFilters, placeholder("crop from cropdetect"))
+		} else if crop, err := DetectCrop(fileName, metadata); err != nil {
+			log.Warningf("Error detecting crop of %s: %s", fileName, err)
+		} else if crop != "" {
+			log.Infof("Cropping %s: %s", fileName, crop)
+			videoFilters = append(videoFilters, crop)
+		}
+	}
+
+	if maxHeight := config.GetInt(fileName, "max-height"); maxHeight > 0 && metadata != nil {
+		// Never upscale, -2 keeps the aspect ratio with an even width
+		if video := metadata.VideoStream(); video != nil && video.Height > maxHeight {
+			videoFilters = append(videoFilters, "scale=-2:"+strconv.Itoa(maxHeight))
+		}
+	}
+
+	// User filters run on the cropped and scaled frames, before they get uploaded to the device
+	videoFilters = append(videoFilters, extraFilters...)
+
+	if accelerator, _ := getHardwareAccelerator(); accelerator != nil {
+		videoFilters = append(videoFilters, accelerator.videoFilters...)
+	}
+
+	if len(videoFilters) > 0 {
+		finalFlags = append(finalFlags, "-vf", strings.Join(videoFilters, ","))
+	}
 
 	// Add flags from original
 	if metadata != nil {
@@ -56,75 +141,184 @@ func BuildFlags(fileName string, tempFileName string, metadata *models.FileMetad
 				if stream.ColorTransfer != nil {
 					finalFlags = append(finalFlags, "-color_trc", *stream.ColorTransfer)
 				}
-				if stream.PixelFormat != nil {
-					finalFlags = append(finalFlags, "-pix_fmt", *stream.PixelFormat)
-				}
 				break
 			}
 		}
 	}
 
+	if pixelFormat := buildPixelFormat(fileName, finalFlags, metadata); pixelFormat != "" {
+		finalFlags = append(finalFlags, "-pix_fmt", pixelFormat)
+	}
+
+	// Extra flags go last so they override anything generated, but still apply to the output
+	finalFlags = append(finalFlags, extra...)
+
 	// The output file
 	finalFlags = append(finalFlags, tempFileName)
 
-	return finalFlags
+	return finalFlags, nil
+}
+
+// Returns the binary and arguments used to transcode the file
+func BuildCommand(fileName string, tempFileName string, metadata *models.FileMetadata) (string, []string, error) {
+	flags, err := BuildFlags(fileName, tempFileName, metadata)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	command := applyPriority(append([]string{FFmpegBinary()}, flags...))
+
+	return command[0], command[1:], nil
 }
 
-func TranscodeFile(fileName string, tempFileName string, metadata *models.FileMetadata) (bool, *models.ProgressReport) {
-	flags := BuildFlags(fileName, tempFileName, metadata)
+// Returns the command like BuildCommand, without running any analysis pass or writing generated chapters.
+// Placeholders like <crop from cropdetect> stand in for the results those passes would have.
+func DryRunCommand(fileName string, tempFileName string, metadata *models.FileMetadata) (string, []string, error) {
+	flags, err := buildFlags(fileName, tempFileName, metadata, false)
 
-	notifications.NotifyStart(metadata)
+	if err != nil {
+		return "", nil, err
+	}
 
-	log.Tracef("Executing ffmpeg %s", strings.Join(flags, " "))
+	command := applyPriority(append([]string{FFmpegBinary()}, flags...))
 
-	var c *exec.Cmd
-	if viper.GetBool("nice") && runtime.GOOS == "linux" {
-		c = exec.Command("nice", flags...)
-	} else {
-		c = exec.Command("ffmpeg", flags...)
+	return command[0], command[1:], nil
+}
+
+// Stands in for a value an analysis pass would have found
+func placeholder(description string) string {
+	return "<" + description + ">"
+}
+
+// Receives every progress report of a transcode
+type ProgressFunc func(report *models.ProgressReport)
+
+// Transcodes the file, killing ffmpeg when the context gets cancelled and leaving its temp file to the caller.
+// ffmpeg is stopped once the output grows beyond stopAbove bytes, 0 to never stop it.
+// The error is only set for models.OutcomeFailed, onProgress may be nil
+func TranscodeFile(ctx context.Context, fileName string, tempFileName string, metadata *models.FileMetadata, stopAbove int64, onProgress ProgressFunc) (models.Outcome, *models.ProgressReport, error) {
+	defer RemoveChapters(tempFileName)
+
+	binary, flags, err := BuildCommand(fileName, tempFileName, metadata)
+
+	if err != nil {
+		return models.OutcomeFailed, nil, err
 	}
 
-	done := make(chan bool, 2)
-	stopTranscoder := make(chan bool, 2)
+	// The exact arguments handed to exec, quoted so the command can be copied into a shell
+	log.Debugf("Executing %s", utils.ShellQuote(append([]string{binary}, flags...)))
 
-	HookTermination(c, stopTranscoder, done, tempFileName)
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	c := executor.Command(runCtx, binary, flags...)
 
 	outPipe, err := c.StdoutPipe()
-	defer outPipe.Close()
 	if err != nil {
-		log.Fatal(err)
+		return models.OutcomeFailed, nil, fmt.Errorf("failed hooking ffmpeg stdout: %s", err)
 	}
 
+	defer outPipe.Close()
+
 	errPipe, err := c.StderrPipe()
-	defer errPipe.Close()
 	if err != nil {
-		log.Fatal(err)
+		return models.OutcomeFailed, nil, fmt.Errorf("failed hooking ffmpeg stderr: %s", err)
 	}
 
+	defer errPipe.Close()
+
 	err = c.Start()
 	if err != nil {
-		log.Fatal(err)
+		return models.OutcomeFailed, nil, fmt.Errorf("failed running ffmpeg: %s", err)
 	}
 
-	if viper.GetBool("stderr") {
-		go ReadError(errPipe)
-	}
+	stderrTail := make(chan string, 1)
+
+	go ReadError(errPipe, viper.GetBool("stderr"), stderrTail)
+
+	reports := make(chan *models.ProgressReport, 1)
 
-	go ReadOut(outPipe, fileName, metadata, stopTranscoder)
+	go ReadOut(outPipe, metadata, stopAbove, cancel, onProgress, reports)
+
+	// Wait closes the pipe, so stderr has to be drained first
+	tail := <-stderrTail
 
 	err = c.Wait()
 
-	if err != nil {
-		log.Errorf("ffmpeg: %s", err)
+	outcome := models.OutcomeFinished
+
+	if ctx.Err() != nil {
+		outcome = models.OutcomeTerminated
+	} else if runCtx.Err() != nil {
+		outcome = models.OutcomeStopped
+	} else if err != nil {
+		return models.OutcomeFailed, <-reports, newTranscodeError(err, tail)
+	}
+
+	if outcome == models.OutcomeTerminated {
+		log.Warningf("ffmpeg killed")
+	} else if outcome == models.OutcomeStopped {
+		// Assume corrupted output file
+		err = os.Remove(tempFileName)
+
+		if err != nil && !os.IsNotExist(err) {
+			log.Errorf("Error deleting file %s: %s", tempFileName, err)
+		}
+
+		log.Warningf("ffmpeg killed")
+	}
+
+	return outcome, <-reports, nil
+}
+
+// Deletes the temp file of a failed transcode, or keeps it next to the original as <file>.failed
+func DiscardFailed(fileName string, tempFileName string, keep bool) {
+	if keep {
+		failedFileName := fileName + ".failed"
+
+		err := utils.MoveFile(tempFileName, failedFileName)
+
+		if err == nil {
+			log.Infof("Kept failed transcode of %s: %s", fileName, failedFileName)
+			return
+		}
+
+		if !os.IsNotExist(err) {
+			log.Errorf("Error renaming file %s to %s: %s", tempFileName, failedFileName, err)
+		}
+
+		return
 	}
 
-	stopTranscoder <- false
+	err := os.Remove(tempFileName)
 
-	return <-done, lastReport
+	if err != nil && !os.IsNotExist(err) {
+		log.Errorf("Error deleting file %s: %s", tempFileName, err)
+	}
 }
 
-func ReadOut(pipe io.ReadCloser, filename string, metadata *models.FileMetadata, stopTranscoder chan bool) {
-	lastLog := int64(0)
+// Whether ffmpeg was killed by a signal rather than exiting with an error
+func IsSignaled(err error) bool {
+	var exitErr *exec.ExitError
+
+	if !errors.As(err, &exitErr) {
+		return false
+	}
+
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+
+	return ok && status.Signaled()
+}
+
+// Parses the progress blocks ffmpeg writes to stdout and hands every report to onProgress.
+// Stops the transcoder once the output is bigger than stopAbove bytes, unless it is 0.
+func ReadOut(pipe io.ReadCloser, metadata *models.FileMetadata, stopAbove int64, stopTranscoder context.CancelFunc, onProgress ProgressFunc, reports chan *models.ProgressReport) {
+	var lastReport *models.ProgressReport
+	defer func() {
+		reports <- lastReport
+	}()
+
 	lines := make([]string, 0)
 	line := make([]byte, 0)
 	for {
@@ -138,26 +332,23 @@ func ReadOut(pipe io.ReadCloser, filename string, metadata *models.FileMetadata,
 		if buffer[0] != '\n' {
 			line = append(line, buffer[0])
 		} else {
+			isEnd := strings.HasPrefix(string(line), "progress=")
 			lines = append(lines, string(line))
 			line = make([]byte, 0)
 
-			// TODO Progress report based on value detection
-			if len(lines) == 12 {
+			// Every block ends with the progress key, the keys before it differ between ffmpeg versions
+			if isEnd {
 				report := OutputToReport(lines)
+				report.ETA = estimateETA(report, metadata)
 				lastReport = report
 
-				if viper.GetBool("early-exit") && viper.GetBool("keep-old") {
-					if int64(report.TotalSize) > metadata.Format.SizeInt() {
-						stopTranscoder <- true
-						return
-					}
+				if stopAbove > 0 && int64(report.TotalSize) > stopAbove {
+					stopTranscoder()
+					return
 				}
 
-				notifications.NotifyProgressStatus(report)
-
-				if time.Now().Unix()-lastLog > int64(viper.GetInt("interval")) {
-					report.Log(filename)
-					lastLog = time.Now().Unix()
+				if onProgress != nil {
+					onProgress(report)
 				}
 
 				lines = make([]string, 0)
@@ -171,7 +362,23 @@ func ReadOut(pipe io.ReadCloser, filename string, metadata *models.FileMetadata,
 	}
 }
 
-func ReadError(pipe io.ReadCloser) {
+// Reads stderr, forwarding it if requested, and sends the last lines once ffmpeg closes it
+func ReadError(pipe io.ReadCloser, forward bool, tail chan string) {
+	lines := make([]string, 0, stderrTailLines)
+	line := make([]byte, 0)
+
+	defer func() {
+		if len(line) > 0 {
+			lines = append(lines, string(line))
+		}
+
+		if len(lines) > stderrTailLines {
+			lines = lines[len(lines)-stderrTailLines:]
+		}
+
+		tail <- strings.Join(lines, "\n")
+	}()
+
 	for {
 		buffer := make([]byte, 1)
 		readCount, err := pipe.Read(buffer)
@@ -185,6 +392,21 @@ func ReadError(pipe io.ReadCloser) {
 			return
 		}
 
+		if buffer[0] == '\n' {
+			lines = append(lines, string(line))
+			line = make([]byte, 0)
+
+			if len(lines) > stderrTailLines {
+				lines = lines[1:]
+			}
+		} else if buffer[0] != '\r' {
+			line = append(line, buffer[0])
+		}
+
+		if !forward {
+			continue
+		}
+
 		_, err = os.Stderr.Write(buffer)
 
 		if err != nil {
@@ -200,7 +422,12 @@ func OutputToReport(lines []string) *models.ProgressReport {
 	report := models.ProgressReport{}
 
 	for _, line := range lines {
-		split := strings.Split(line, "=")
+		split := strings.SplitN(strings.TrimSpace(line), "=", 2)
+
+		if len(split) != 2 {
+			continue
+		}
+
 		switch split[0] {
 		case "frame":
 			report.Frame, _ = strconv.Atoi(split[1])
@@ -221,6 +448,10 @@ func OutputToReport(lines []string) *models.ProgressReport {
 				report.Speed, _ = strconv.ParseFloat(matches[0][1], 64)
 			}
 			break
+		case "out_time_us":
+			outTime, _ := strconv.ParseInt(split[1], 10, 64)
+			report.OutTime = float64(outTime) / 1000000
+			break
 		case "progress":
 			report.Progress = split[1]
 			break
@@ -229,45 +460,3 @@ func OutputToReport(lines []string) *models.ProgressReport {
 
 	return &report
 }
-
-func HookTermination(c *exec.Cmd, stopTranscoder chan bool, done chan bool, tempFileName string) {
-	go func() {
-		toTerminate := <-stopTranscoder
-
-		if toTerminate {
-			err := c.Process.Kill()
-
-			if err != nil {
-				log.Errorf("Error killing process: %s", err)
-			}
-
-			_, err = c.Process.Wait()
-
-			if err != nil {
-				log.Errorf("Error waiting for process exit: %s", err)
-			}
-
-			err = os.Remove(tempFileName)
-
-			if err != nil {
-				log.Errorf("Error deleting file %s: %s", tempFileName, err)
-			}
-
-			log.Warningf("ffmpeg killed")
-		}
-
-		done <- toTerminate
-	}()
-
-	terminate := make(chan os.Signal)
-
-	go func() {
-		toTerminate := <-terminate
-		if toTerminate != nil {
-			stopTranscoder <- true
-		}
-		signal.Stop(terminate)
-	}()
-
-	signal.Notify(terminate, syscall.SIGINT, syscall.SIGTERM, syscall.SIGKILL)
-}
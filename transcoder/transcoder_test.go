@@ -0,0 +1,132 @@
+package transcoder
+
+import (
+	"context"
+	"github.com/Vilsol/transcoder-go/models"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Records the commands instead of running them, every command fails
+type recordingExecutor struct {
+	lock     sync.Mutex
+	commands []string
+}
+
+func (e *recordingExecutor) Command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.commands = append(e.commands, strings.Join(append([]string{name}, args...), " "))
+
+	return exec.CommandContext(ctx, "false")
+}
+
+func stringPointer(value string) *string {
+	return &value
+}
+
+func TestDryRunCommand(t *testing.T) {
+	settings := map[string]interface{}{
+		"flags":           "-map 0 -c:v libx265 -crf 20 -c:a aac",
+		"loudnorm":        true,
+		"auto-chapters":   true,
+		"autocrop":        true,
+		"deinterlace":     "auto",
+		"hdr":             "passthrough",
+		"pix-fmt":         "yuv420p10le",
+		"copy-chapters":   true,
+		"copy-metadata":   true,
+		"subtitles":       "copy",
+		"loudnorm-target": -23.0,
+	}
+
+	for key, value := range settings {
+		viper.Set(key, value)
+	}
+
+	defer func() {
+		for key := range settings {
+			viper.Set(key, nil)
+		}
+	}()
+
+	recorder := &recordingExecutor{}
+	SetExecutor(recorder)
+	defer SetExecutor(nil)
+
+	directory, err := ioutil.TempDir("", "transcoder")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(directory)
+
+	fileName := filepath.Join(directory, "video.mkv")
+	tempFileName := filepath.Join(directory, "video.transcoding.mkv")
+
+	metadata := &models.FileMetadata{
+		Streams: []models.Stream{
+			{
+				Index:          0,
+				CodecType:      "video",
+				Width:          3840,
+				Height:         2160,
+				ColorTransfer:  stringPointer("smpte2084"),
+				ColorPrimaries: stringPointer("bt2020"),
+			},
+			{Index: 1, CodecType: "audio"},
+		},
+		Format: models.Format{Filename: fileName, Duration: "3600", Size: "1000000"},
+	}
+
+	_, flags, err := DryRunCommand(fileName, tempFileName, metadata)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(recorder.commands) > 0 {
+		t.Errorf("dry run ran commands: %q", recorder.commands)
+	}
+
+	command := strings.Join(flags, " ")
+
+	for _, want := range []string{
+		"-i " + placeholder("chapters at silences"),
+		placeholder("measured loudness"),
+		placeholder("crop from cropdetect"),
+		placeholder("bwdif if idet finds interlacing"),
+		"master-display=" + placeholder("from the first frame"),
+	} {
+		if !strings.Contains(command, want) {
+			t.Errorf("command %q does not contain %q", command, want)
+		}
+	}
+
+	entries, err := ioutil.ReadDir(directory)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, entry := range entries {
+		t.Errorf("dry run wrote %s", entry.Name())
+	}
+
+	// The same settings run every analysis pass outside of dry runs
+	if _, err := BuildFlags(fileName, tempFileName, metadata); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(recorder.commands) < 5 {
+		t.Errorf("analysis passes ran %d commands, want at least 5: %q", len(recorder.commands), recorder.commands)
+	}
+}
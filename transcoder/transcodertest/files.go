@@ -0,0 +1,55 @@
+package transcodertest
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// Creates a directory that is removed after the test
+func TempDir(t *testing.T) string {
+	t.Helper()
+
+	directory, err := ioutil.TempDir("", "transcoder")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		os.RemoveAll(directory)
+	})
+
+	return directory
+}
+
+func WriteFile(t *testing.T, fileName string, content string) {
+	t.Helper()
+
+	if err := ioutil.WriteFile(fileName, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func AssertContent(t *testing.T, fileName string, want string) {
+	t.Helper()
+
+	data, err := ioutil.ReadFile(fileName)
+
+	if err != nil {
+		t.Errorf("%s: %s", fileName, err)
+		return
+	}
+
+	if string(data) != want {
+		t.Errorf("%s = %q, want %q", fileName, data, want)
+	}
+}
+
+func AssertMissing(t *testing.T, fileName string) {
+	t.Helper()
+
+	if _, err := os.Stat(fileName); !os.IsNotExist(err) {
+		t.Errorf("%s exists, err %v", fileName, err)
+	}
+}
@@ -0,0 +1,206 @@
+// Package transcodertest fakes ffmpeg and ffprobe by running the test binary in their place, and has the file helpers shared by the tests
+package transcodertest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Carries the scenario to the fake
+const HelperProcessEnv = "TRANSCODER_HELPER_PROCESS"
+
+// What the fake ffmpeg and ffprobe act out
+type Scenario struct {
+	// Bytes ffmpeg writes to the output, in progress blocks of a tenth each
+	OutputSize int `json:"output_size"`
+	// Duration ffprobe reports for every file
+	Duration string `json:"duration"`
+	// ffmpeg exits with this code after writing the output
+	ExitCode int    `json:"exit_code"`
+	Stderr   string `json:"stderr"`
+	// ffmpeg never exits on its own after writing the output, only when it gets killed
+	Hang bool `json:"hang"`
+	// ffprobe fails on files whose name ends with this, like on a truncated transcode
+	FailProbe string `json:"fail_probe"`
+	// Score the libvmaf filter logs, 0 fails computing it
+	VMAF float64 `json:"vmaf"`
+}
+
+// Runs the test binary in place of ffmpeg and ffprobe, the TestHelperProcess of the binary acts out the scenario
+type Executor struct {
+	Scenario Scenario
+}
+
+func (e Executor) Command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	scenario, err := json.Marshal(e.Scenario)
+
+	if err != nil {
+		panic(err)
+	}
+
+	c := exec.CommandContext(ctx, os.Args[0], append([]string{"-test.run=TestHelperProcess", "--", name}, args...)...)
+	c.Env = append(os.Environ(), HelperProcessEnv+"="+string(scenario))
+
+	return c
+}
+
+// Acts out the scenario when the executor started the test binary, does nothing in a normal test run.
+// Every test binary using the executor has to call it from its TestHelperProcess.
+func HelperProcess() {
+	data := os.Getenv(HelperProcessEnv)
+
+	if data == "" {
+		return
+	}
+
+	var scenario Scenario
+	if err := json.Unmarshal([]byte(data), &scenario); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	args := os.Args
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+
+	if len(args) < 3 {
+		fmt.Fprintln(os.Stderr, "missing command")
+		os.Exit(2)
+	}
+
+	name, args := filepath.Base(args[1]), args[2:]
+
+	if strings.HasPrefix(args[len(args)-1], "encoder=") {
+		// Asked for the pixel formats of an encoder
+		fmt.Println("Encoder " + strings.TrimPrefix(args[len(args)-1], "encoder=") + ":\n    Supported pixel formats: yuv420p yuv420p10le")
+		os.Exit(0)
+	}
+
+	if strings.HasPrefix(name, "ffprobe") {
+		os.Exit(fakeFFprobe(scenario, args[len(args)-1]))
+	}
+
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "libvmaf=") {
+			os.Exit(fakeVMAF(scenario, arg))
+		}
+	}
+
+	os.Exit(fakeFFmpeg(scenario, args[len(args)-1]))
+}
+
+// Reports a video and an audio stream, the size is the one of the file on disk
+func fakeFFprobe(scenario Scenario, fileName string) int {
+	if scenario.FailProbe != "" && strings.HasSuffix(fileName, scenario.FailProbe) {
+		fmt.Fprintln(os.Stderr, "Invalid data found when processing input")
+		return 1
+	}
+
+	stat, err := os.Stat(fileName)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	output, _ := json.Marshal(map[string]interface{}{
+		"streams": []map[string]interface{}{
+			{"index": 0, "codec_type": "video", "codec_name": "h264", "width": 1920, "height": 1080},
+			{"index": 1, "codec_type": "audio", "codec_name": "aac"},
+		},
+		"format": map[string]interface{}{
+			"filename": fileName,
+			"duration": scenario.Duration,
+			"size":     strconv.FormatInt(stat.Size(), 10),
+		},
+	})
+
+	fmt.Println(string(output))
+
+	return 0
+}
+
+// Logs the score of the scenario to the log_path of the filter
+func fakeVMAF(scenario Scenario, filter string) int {
+	if scenario.VMAF == 0 {
+		fmt.Fprintln(os.Stderr, "No such filter: 'libvmaf'")
+		return 1
+	}
+
+	logPath := ""
+
+	for _, option := range strings.Split(strings.TrimPrefix(filter, "libvmaf="), ":") {
+		if strings.HasPrefix(option, "log_path=") {
+			logPath = strings.TrimPrefix(option, "log_path=")
+		}
+	}
+
+	output, _ := json.Marshal(map[string]interface{}{
+		"pooled_metrics": map[string]interface{}{
+			"vmaf": map[string]interface{}{"mean": scenario.VMAF},
+		},
+	})
+
+	if err := ioutil.WriteFile(logPath, output, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
+
+// Writes the output in ten steps with a progress block after each
+func fakeFFmpeg(scenario Scenario, outputFileName string) int {
+	output, err := os.Create(outputFileName)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	defer output.Close()
+
+	chunk := make([]byte, scenario.OutputSize/10)
+	written := 0
+
+	for i := 1; i <= 10; i++ {
+		if i == 10 {
+			chunk = make([]byte, scenario.OutputSize-written)
+		}
+
+		if _, err := output.Write(chunk); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		written += len(chunk)
+
+		progress := "continue"
+		if i == 10 && !scenario.Hang {
+			progress = "end"
+		}
+
+		fmt.Printf("frame=%d\nfps=30.00\nbitrate=1000.0kbits/s\ntotal_size=%d\nout_time_us=%d\nspeed=2.00x\nprogress=%s\n", i*100, written, i*1000000, progress)
+
+		time.Sleep(time.Millisecond)
+	}
+
+	if scenario.Hang {
+		time.Sleep(time.Hour)
+	}
+
+	if scenario.ExitCode != 0 {
+		fmt.Fprintln(os.Stderr, scenario.Stderr)
+	}
+
+	return scenario.ExitCode
+}
@@ -0,0 +1,72 @@
+package transcoder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+type vmafLog struct {
+	PooledMetrics struct {
+		VMAF struct {
+			Mean float64 `json:"mean"`
+		} `json:"vmaf"`
+	} `json:"pooled_metrics"`
+}
+
+// Computes the mean VMAF score of the transcoded file compared to the original
+func ComputeVMAF(original string, transcoded string) (float64, error) {
+	logFile, err := ioutil.TempFile("", "transcoder-vmaf-*.json")
+
+	if err != nil {
+		return 0, err
+	}
+
+	logFile.Close()
+	defer os.Remove(logFile.Name())
+
+	// libvmaf expects the distorted input first and the reference second
+	params := []string{
+		"-hide_banner", "-v", "error",
+		"-i", transcoded,
+		"-i", original,
+		"-lavfi", "libvmaf=log_fmt=json:log_path=" + escapeFilterValue(logFile.Name()),
+		"-f", "null", "-",
+	}
+
+	log.Tracef("Executing %s %s", FFmpegBinary(), strings.Join(params, " "))
+
+	output, err := executor.Command(context.Background(), FFmpegBinary(), params...).CombinedOutput()
+
+	if err != nil {
+		return 0, fmt.Errorf("ffmpeg exited: %s: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	data, err := ioutil.ReadFile(logFile.Name())
+
+	if err != nil {
+		return 0, err
+	}
+
+	var result vmafLog
+	err = json.Unmarshal(data, &result)
+
+	if err != nil {
+		return 0, fmt.Errorf("failed parsing vmaf log: %s", err)
+	}
+
+	if result.PooledMetrics.VMAF.Mean == 0 {
+		return 0, errors.New("vmaf log does not contain a score")
+	}
+
+	return result.PooledMetrics.VMAF.Mean, nil
+}
+
+func escapeFilterValue(value string) string {
+	return strings.NewReplacer("\\", "\\\\", ":", "\\:", "'", "\\'").Replace(value)
+}
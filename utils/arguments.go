@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+)
+
+// Splits a command line like a POSIX shell would, respecting single quotes, double quotes and backslash escapes
+func SplitArguments(s string) ([]string, error) {
+	arguments := make([]string, 0)
+
+	var current strings.Builder
+	inArgument := false
+	var quote rune
+	escaped := false
+
+	for _, r := range s {
+		if escaped {
+			current.WriteRune(r)
+			escaped = false
+			continue
+		}
+
+		switch {
+		case quote == '\'':
+			// Nothing is special inside single quotes
+			if r == '\'' {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\\' && (quote == 0 || quote == '"'):
+			escaped = true
+			inArgument = true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArgument = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArgument {
+				arguments = append(arguments, current.String())
+				current.Reset()
+				inArgument = false
+			}
+		default:
+			current.WriteRune(r)
+			inArgument = true
+		}
+	}
+
+	if escaped {
+		return nil, errors.New("trailing backslash")
+	}
+
+	if quote != 0 {
+		return nil, errors.New("unterminated quote")
+	}
+
+	if inArgument {
+		arguments = append(arguments, current.String())
+	}
+
+	return arguments, nil
+}
@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitArguments(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{"plain", "-c:v libx265  -crf 20", []string{"-c:v", "libx265", "-crf", "20"}},
+		{"empty", "", []string{}},
+		{"double quoted filtergraph", `-vf "scale=1280:-2, fps=30"`, []string{"-vf", "scale=1280:-2, fps=30"}},
+		{"single quoted filtergraph", `-vf 'drawtext=text="a b":x=10'`, []string{"-vf", `drawtext=text="a b":x=10`}},
+		{"nested single quotes", `-vf "drawtext=text='a b'"`, []string{"-vf", "drawtext=text='a b'"}},
+		{"escaped space", `-metadata title=a\ b`, []string{"-metadata", "title=a b"}},
+		{"escaped quote in double quotes", `"a \"b\""`, []string{`a "b"`}},
+		{"empty quotes", `-metadata title=""`, []string{"-metadata", "title="}},
+		{"adjacent quotes", `a"b c"'d e'`, []string{"ab cd e"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := SplitArguments(test.input)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("SplitArguments(%q) = %q, want %q", test.input, got, test.want)
+			}
+		})
+	}
+}
+
+func TestSplitArgumentsErrors(t *testing.T) {
+	for _, input := range []string{`-vf "scale=1280:-2`, `-vf 'scale`, `-vf scale\`} {
+		if got, err := SplitArguments(input); err == nil {
+			t.Errorf("SplitArguments(%q) = %q, want an error", input, got)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Replaced by tests to force the copy fallback
+var rename = os.Rename
+
+// Renames the file, falling back to a copy when source and destination are on different filesystems.
+// The copy is written next to the destination and renamed over it, so a failed copy neither leaves
+// a partial destination nor touches an existing one, and the source is only removed after that.
+func MoveFile(source string, destination string) error {
+	err := rename(source, destination)
+
+	if linkErr, ok := err.(*os.LinkError); !ok || linkErr.Err != syscall.EXDEV {
+		return err
+	}
+
+	staged := StagingPath(destination)
+
+	if err := copyFile(source, staged); err != nil {
+		return err
+	}
+
+	if err := rename(staged, destination); err != nil {
+		os.Remove(staged)
+		return err
+	}
+
+	return os.Remove(source)
+}
+
+// Moves the file to a hidden name next to the destination and returns that name.
+// The final rename onto the destination then stays on one filesystem and can not fail halfway.
+func StageFile(source string, destination string) (string, error) {
+	staged := StagingPath(destination)
+
+	if err := MoveFile(source, staged); err != nil {
+		return "", err
+	}
+
+	return staged, nil
+}
+
+// Hidden name in the directory of the file that a replacement is prepared under
+func StagingPath(fileName string) string {
+	return filepath.Join(filepath.Dir(fileName), "."+filepath.Base(fileName)+".partial")
+}
+
+func copyFile(source string, destination string) error {
+	in, err := os.Open(source)
+
+	if err != nil {
+		return err
+	}
+
+	defer in.Close()
+
+	stat, err := in.Stat()
+
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(destination, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, stat.Mode().Perm())
+
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+
+	if err == nil {
+		// The source is removed right after, so the copy has to be on disk
+		err = out.Sync()
+	}
+
+	if err != nil {
+		out.Close()
+		os.Remove(destination)
+		return err
+	}
+
+	err = out.Close()
+
+	if err != nil {
+		os.Remove(destination)
+		return err
+	}
+
+	// OpenFile applies the umask, so set the mode explicitly
+	err = os.Chmod(destination, stat.Mode().Perm())
+
+	if err != nil {
+		os.Remove(destination)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"github.com/Vilsol/transcoder-go/transcoder/transcodertest"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// Fails renames of the source like a rename across filesystems, so MoveFile has to copy it
+func forceCopy(t *testing.T, source string) {
+	rename = func(oldPath string, newPath string) error {
+		if oldPath == source {
+			return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: syscall.EXDEV}
+		}
+
+		return os.Rename(oldPath, newPath)
+	}
+
+	t.Cleanup(func() {
+		rename = os.Rename
+	})
+}
+
+func writeFileMode(t *testing.T, fileName string, content string, mode os.FileMode) {
+	transcodertest.WriteFile(t, fileName, content)
+
+	// WriteFile applies the umask
+	if err := os.Chmod(fileName, mode); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMoveFileCopy(t *testing.T) {
+	directory := transcodertest.TempDir(t)
+	source := filepath.Join(directory, "video.transcoding.mkv")
+	destination := filepath.Join(directory, "video.mkv")
+
+	writeFileMode(t, source, "transcoded", 0640)
+	writeFileMode(t, destination, "original", 0644)
+	forceCopy(t, source)
+
+	if err := MoveFile(source, destination); err != nil {
+		t.Fatal(err)
+	}
+
+	transcodertest.AssertContent(t, destination, "transcoded")
+	transcodertest.AssertMissing(t, source)
+	transcodertest.AssertMissing(t, StagingPath(destination))
+
+	stat, err := os.Stat(destination)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stat.Mode().Perm() != 0640 {
+		t.Errorf("mode = %s, want %s", stat.Mode().Perm(), os.FileMode(0640))
+	}
+}
+
+func TestMoveFileFailedCopy(t *testing.T) {
+	directory := transcodertest.TempDir(t)
+	destination := filepath.Join(directory, "video.mkv")
+
+	// Opening a directory works, reading it fails halfway into the copy
+	source := filepath.Join(directory, "video.transcoding.mkv")
+	if err := os.Mkdir(source, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	writeFileMode(t, destination, "original", 0644)
+	forceCopy(t, source)
+
+	if err := MoveFile(source, destination); err == nil {
+		t.Fatal("MoveFile did not fail")
+	}
+
+	transcodertest.AssertContent(t, destination, "original")
+	transcodertest.AssertMissing(t, StagingPath(destination))
+
+	if _, err := os.Stat(source); err != nil {
+		t.Errorf("source is gone: %s", err)
+	}
+}
+
+func TestStageFile(t *testing.T) {
+	for _, copied := range []bool{false, true} {
+		directory := transcodertest.TempDir(t)
+		source := filepath.Join(transcodertest.TempDir(t), "video.transcoding.mkv")
+		destination := filepath.Join(directory, "video.mkv")
+
+		writeFileMode(t, source, "transcoded", 0644)
+		writeFileMode(t, destination, "original", 0644)
+
+		if copied {
+			forceCopy(t, source)
+		}
+
+		staged, err := StageFile(source, destination)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if filepath.Dir(staged) != directory {
+			t.Errorf("staged %s outside of %s", staged, directory)
+		}
+
+		transcodertest.AssertContent(t, staged, "transcoded")
+		transcodertest.AssertContent(t, destination, "original")
+		transcodertest.AssertMissing(t, source)
+	}
+}
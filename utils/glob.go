@@ -0,0 +1,203 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Extends filepath.Glob with {a,b} alternatives and ** matching any number of directories.
+// Patterns using neither behave exactly like filepath.Glob.
+func Glob(pattern string) ([]string, error) {
+	seen := make(map[string]bool)
+	matches := make([]string, 0)
+
+	for _, expanded := range ExpandBraces(pattern) {
+		var found []string
+		var err error
+
+		if hasDoubleStar(expanded) {
+			found, err = globDoubleStar(expanded)
+		} else {
+			found, err = filepath.Glob(expanded)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, match := range found {
+			if !seen[match] {
+				seen[match] = true
+				matches = append(matches, match)
+			}
+		}
+	}
+
+	return matches, nil
+}
+
+// Expands every {a,b} group into a pattern per alternative, groups can be nested
+func ExpandBraces(pattern string) []string {
+	depth := 0
+	start := -1
+
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '{':
+			if depth == 0 {
+				start = i
+			}
+			depth++
+		case '}':
+			if depth == 0 {
+				continue
+			}
+
+			depth--
+
+			if depth > 0 {
+				continue
+			}
+
+			alternatives := splitAlternatives(pattern[start+1 : i])
+
+			// A group without a comma is taken literally, like in shells
+			if len(alternatives) < 2 {
+				start = -1
+				continue
+			}
+
+			expanded := make([]string, 0)
+			for _, alternative := range alternatives {
+				expanded = append(expanded, ExpandBraces(pattern[:start]+alternative+pattern[i+1:])...)
+			}
+
+			return expanded
+		}
+	}
+
+	return []string{pattern}
+}
+
+// Splits the inside of a brace group on the commas outside of nested groups
+func splitAlternatives(group string) []string {
+	alternatives := make([]string, 0)
+	depth := 0
+	last := 0
+
+	for i := 0; i < len(group); i++ {
+		switch group[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				alternatives = append(alternatives, group[last:i])
+				last = i + 1
+			}
+		}
+	}
+
+	return append(alternatives, group[last:])
+}
+
+func hasDoubleStar(pattern string) bool {
+	for _, segment := range strings.Split(filepath.ToSlash(pattern), "/") {
+		if segment == "**" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Walks everything below the part of the pattern before the first ** and matches it segment by segment
+func globDoubleStar(pattern string) ([]string, error) {
+	segments := strings.Split(filepath.ToSlash(pattern), "/")
+
+	first := 0
+	for segments[first] != "**" {
+		first++
+	}
+
+	base := strings.Join(segments[:first], "/")
+	if base == "" && first > 0 {
+		// The pattern is absolute
+		base = "/"
+	}
+
+	roots := []string{"."}
+	if base != "" {
+		var err error
+		roots, err = filepath.Glob(filepath.FromSlash(base))
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	matches := make([]string, 0)
+
+	for _, root := range roots {
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				// Unreadable directories are skipped, like filepath.Glob does
+				return nil
+			}
+
+			relative, err := filepath.Rel(root, path)
+
+			if err != nil || relative == "." {
+				return nil
+			}
+
+			if matchSegments(segments[first:], strings.Split(filepath.ToSlash(relative), "/")) {
+				matches = append(matches, path)
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Strings(matches)
+
+	return matches, nil
+}
+
+// Matches path segments against pattern segments, where ** matches zero or more segments
+func matchSegments(pattern []string, path []string) bool {
+	if len(pattern) == 0 {
+		return len(path) == 0
+	}
+
+	if pattern[0] == "**" {
+		for skip := 0; skip <= len(path); skip++ {
+			if matchSegments(pattern[1:], path[skip:]) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if len(path) == 0 {
+		return false
+	}
+
+	if matched, err := filepath.Match(pattern[0], path[0]); err != nil || !matched {
+		return false
+	}
+
+	return matchSegments(pattern[1:], path[1:])
+}
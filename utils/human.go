@@ -1,6 +1,12 @@
 package utils
 
-import "fmt"
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
 
 func BytesHumanReadable(b int64) string {
 	const unit = 1000
@@ -14,3 +20,53 @@ func BytesHumanReadable(b int64) string {
 	}
 	return fmt.Sprintf("%.1f %cB", float64(b)/float64(div), "kMGTPE"[exp])
 }
+
+// Keyed by the lower case unit
+var humanReadableUnits = map[string]int64{
+	"":  1,
+	"b": 1,
+
+	"kb": 1000,
+	"mb": 1000 * 1000,
+	"gb": 1000 * 1000 * 1000,
+	"tb": 1000 * 1000 * 1000 * 1000,
+	"pb": 1000 * 1000 * 1000 * 1000 * 1000,
+
+	"kib": 1 << 10,
+	"mib": 1 << 20,
+	"gib": 1 << 30,
+	"tib": 1 << 40,
+	"pib": 1 << 50,
+}
+
+var humanReadableRegex = regexp.MustCompile(`^\s*([0-9]*\.?[0-9]+)\s*([a-zA-Z]*)\s*$`)
+
+// Parses sizes like 500MB, 1.5 GB or 700MiB into bytes, the inverse of BytesHumanReadable
+func ParseHumanReadable(s string) (int64, error) {
+	matches := humanReadableRegex.FindStringSubmatch(s)
+
+	if matches == nil {
+		return 0, fmt.Errorf("invalid size: %s", s)
+	}
+
+	multiplier, ok := humanReadableUnits[strings.ToLower(matches[2])]
+
+	if !ok {
+		return 0, fmt.Errorf("unknown unit: %s", matches[2])
+	}
+
+	value, err := strconv.ParseFloat(matches[1], 64)
+
+	if err != nil {
+		return 0, fmt.Errorf("invalid size: %s", s)
+	}
+
+	// Rounded, 2.01 kB is 2009.9999999999998 as a float
+	bytes := math.Round(value * float64(multiplier))
+
+	if bytes >= math.MaxInt64 {
+		return 0, fmt.Errorf("size too large: %s", s)
+	}
+
+	return int64(bytes), nil
+}
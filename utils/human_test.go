@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestParseHumanReadable(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int64
+		wantErr bool
+	}{
+		{input: "0", want: 0},
+		{input: "512", want: 512},
+		{input: "512B", want: 512},
+		{input: "1kB", want: 1000},
+		{input: "1KB", want: 1000},
+		{input: "1kb", want: 1000},
+		{input: "500MB", want: 500 * 1000 * 1000},
+		{input: "1.5 GB", want: 1500 * 1000 * 1000},
+		{input: "  2 TB  ", want: 2 * 1000 * 1000 * 1000 * 1000},
+		{input: "1PB", want: 1000 * 1000 * 1000 * 1000 * 1000},
+		{input: ".5GB", want: 500 * 1000 * 1000},
+		{input: "2.01 kB", want: 2010},
+		{input: "1KiB", want: 1024},
+		{input: "700MiB", want: 700 * 1024 * 1024},
+		{input: "700 mib", want: 700 * 1024 * 1024},
+		{input: "1.5GiB", want: 1536 * 1024 * 1024},
+		{input: "1TiB", want: 1 << 40},
+		{input: "0.01 MiB", want: 10486},
+		{input: "", wantErr: true},
+		{input: "MB", wantErr: true},
+		{input: "abc", wantErr: true},
+		{input: "-5MB", wantErr: true},
+		{input: "1.2.3MB", wantErr: true},
+		{input: "5 M", wantErr: true},
+		{input: "5 XB", wantErr: true},
+		{input: "5 MB extra", wantErr: true},
+		{input: "10000 PB", wantErr: true},
+		{input: "9223372036854775807", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			got, err := ParseHumanReadable(test.input)
+
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ParseHumanReadable(%q) error = %v, want error %t", test.input, err, test.wantErr)
+			}
+
+			if got != test.want {
+				t.Errorf("ParseHumanReadable(%q) = %d, want %d", test.input, got, test.want)
+			}
+		})
+	}
+}
+
+// Sizes shown to the user parse back to about the same number of bytes
+func TestParseHumanReadableRoundTrip(t *testing.T) {
+	for _, size := range []int64{999, 1000, 1500, 2 * 1000 * 1000, 4350 * 1000 * 1000, 7 * 1000 * 1000 * 1000 * 1000} {
+		got, err := ParseHumanReadable(BytesHumanReadable(size))
+
+		if err != nil {
+			t.Fatalf("ParseHumanReadable(%q) error = %v", BytesHumanReadable(size), err)
+		}
+
+		if diff := got - size; diff < -size/20 || diff > size/20 {
+			t.Errorf("%d shown as %s parses to %d", size, BytesHumanReadable(size), got)
+		}
+	}
+}
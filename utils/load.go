@@ -0,0 +1,25 @@
+package utils
+
+import (
+	"errors"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// Returns the 1 minute load average, only available on linux
+func LoadAverage() (float64, error) {
+	data, err := ioutil.ReadFile("/proc/loadavg")
+
+	if err != nil {
+		return 0, err
+	}
+
+	fields := strings.Fields(string(data))
+
+	if len(fields) == 0 {
+		return 0, errors.New("empty /proc/loadavg")
+	}
+
+	return strconv.ParseFloat(fields[0], 64)
+}
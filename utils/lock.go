@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Returned by TryLock when another process holds the lock
+var ErrLocked = errors.New("locked by another process")
+
+var processStarted = time.Now()
+
+// Takes an exclusive lock on the file, creating it if needed, and returns the open file holding it.
+// The lock belongs to the open file rather than a PID, so it is dropped as soon as the holder exits,
+// no matter if it crashed or runs as PID 1 of a container.
+func TryLock(fileName string) (*os.File, error) {
+	file, err := tryLock(fileName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Only for whoever looks at the lock, it is never read back
+	hostname, _ := os.Hostname()
+	owner := fmt.Sprintf("%s %d %s\n", hostname, os.Getpid(), processStarted.Format(time.RFC3339))
+
+	if err := file.Truncate(0); err == nil {
+		_, _ = file.WriteAt([]byte(owner), 0)
+	}
+
+	return file, nil
+}
+
+// Deletes the lock file and releases the lock
+func Unlock(file *os.File) error {
+	return unlock(file)
+}
@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"github.com/Vilsol/transcoder-go/transcoder/transcodertest"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestTryLock(t *testing.T) {
+	lockFileName := filepath.Join(transcodertest.TempDir(t), "video.lock")
+
+	lock, err := TryLock(lockFileName)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := TryLock(lockFileName); err != ErrLocked {
+		t.Errorf("second TryLock = %v, want ErrLocked", err)
+	}
+
+	if err := Unlock(lock); err != nil {
+		t.Fatal(err)
+	}
+
+	transcodertest.AssertMissing(t, lockFileName)
+
+	lock, err = TryLock(lockFileName)
+
+	if err != nil {
+		t.Fatalf("TryLock after Unlock = %v", err)
+	}
+
+	if err := Unlock(lock); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTryLockLeftBehind(t *testing.T) {
+	lockFileName := filepath.Join(transcodertest.TempDir(t), "video.lock")
+
+	// A crashed holder leaves the file behind, the PID in it may even belong to a live process
+	if err := ioutil.WriteFile(lockFileName, []byte("1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	lock, err := TryLock(lockFileName)
+
+	if err != nil {
+		t.Fatalf("TryLock of a left behind lock = %v", err)
+	}
+
+	if err := Unlock(lock); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,59 @@
+//go:build !windows
+// +build !windows
+
+package utils
+
+import (
+	"os"
+	"syscall"
+)
+
+func tryLock(fileName string) (*os.File, error) {
+	for {
+		file, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE, 0644)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+			file.Close()
+
+			if err == syscall.EWOULDBLOCK {
+				return nil, ErrLocked
+			}
+
+			return nil, err
+		}
+
+		// The previous holder deletes the file before it lets go, a lock taken on the
+		// deleted file would not keep anyone from locking the new one
+		locked, lockedErr := file.Stat()
+		current, currentErr := os.Stat(fileName)
+
+		if lockedErr == nil && currentErr == nil && os.SameFile(locked, current) {
+			return file, nil
+		}
+
+		file.Close()
+
+		if lockedErr != nil {
+			return nil, lockedErr
+		}
+
+		if currentErr != nil && !os.IsNotExist(currentErr) {
+			return nil, currentErr
+		}
+	}
+}
+
+func unlock(file *os.File) error {
+	// Deleted while still locked, so nobody can lock the file in between
+	err := os.Remove(file.Name())
+
+	if closeErr := file.Close(); err == nil || os.IsNotExist(err) {
+		err = closeErr
+	}
+
+	return err
+}
@@ -0,0 +1,50 @@
+//go:build windows
+// +build windows
+
+package utils
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+const errorSharingViolation = syscall.Errno(32)
+
+// Windows has no flock, a handle without sharing keeps every other process from opening the file
+func tryLock(fileName string) (*os.File, error) {
+	name, err := syscall.UTF16PtrFromString(fileName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	handle, err := syscall.CreateFile(name, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil, syscall.OPEN_ALWAYS, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+
+	if err == errorSharingViolation {
+		return nil, ErrLocked
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return os.NewFile(uintptr(handle), fileName), nil
+}
+
+func unlock(file *os.File) error {
+	err := file.Close()
+	removeErr := os.Remove(file.Name())
+
+	// Open files can not be deleted, another process already holding it again is fine
+	var pathErr *os.PathError
+	if errors.As(removeErr, &pathErr) && pathErr.Err == errorSharingViolation {
+		removeErr = nil
+	}
+
+	if err == nil && removeErr != nil && !os.IsNotExist(removeErr) {
+		err = removeErr
+	}
+
+	return err
+}
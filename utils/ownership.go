@@ -0,0 +1,20 @@
+package utils
+
+import "os"
+
+// Applies the permissions and owner of the original file onto path
+func PreserveOwnership(path string, original os.FileInfo) error {
+	err := os.Chmod(path, original.Mode().Perm())
+
+	if err != nil {
+		return err
+	}
+
+	uid, gid, ok := fileOwner(original)
+
+	if !ok {
+		return nil
+	}
+
+	return os.Chown(path, uid, gid)
+}
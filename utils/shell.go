@@ -0,0 +1,24 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+)
+
+var shellSafeRegex = regexp.MustCompile(`^[A-Za-z0-9_./:=,+@%-]+$`)
+
+// Joins the command so it can be pasted into a POSIX shell and runs with the same arguments
+func ShellQuote(command []string) string {
+	quoted := make([]string, len(command))
+
+	for i, arg := range command {
+		if shellSafeRegex.MatchString(arg) {
+			quoted[i] = arg
+			continue
+		}
+
+		quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+	}
+
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package utils
+
+import "syscall"
+
+// Returns the bytes available to unprivileged users on the filesystem of the path
+func FreeSpace(path string) (int64, error) {
+	var stat syscall.Statfs_t
+
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
@@ -0,0 +1,10 @@
+//go:build windows
+// +build windows
+
+package utils
+
+import "errors"
+
+func FreeSpace(path string) (int64, error) {
+	return 0, errors.New("free space is not supported on windows")
+}
@@ -0,0 +1,13 @@
+package utils
+
+import "os"
+
+func IsTerminal(file *os.File) bool {
+	stat, err := file.Stat()
+
+	if err != nil {
+		return false
+	}
+
+	return stat.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,44 @@
+//go:build linux
+// +build linux
+
+package utils
+
+import "syscall"
+
+// Returns the extended attribute of the file, nil if it is not set
+func GetXattr(path string, name string) ([]byte, error) {
+	size, err := syscall.Getxattr(path, name, nil)
+
+	if err == syscall.ENODATA {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	value := make([]byte, size)
+
+	size, err = syscall.Getxattr(path, name, value)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return value[:size], nil
+}
+
+func SetXattr(path string, name string, value []byte) error {
+	return syscall.Setxattr(path, name, value, 0)
+}
+
+// Removes the extended attribute of the file, not being set is not an error
+func RemoveXattr(path string, name string) error {
+	err := syscall.Removexattr(path, name)
+
+	if err == syscall.ENODATA {
+		return nil
+	}
+
+	return err
+}
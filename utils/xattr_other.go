@@ -0,0 +1,20 @@
+//go:build !linux
+// +build !linux
+
+package utils
+
+import "errors"
+
+var errXattrUnsupported = errors.New("extended attributes are only supported on linux")
+
+func GetXattr(path string, name string) ([]byte, error) {
+	return nil, errXattrUnsupported
+}
+
+func SetXattr(path string, name string, value []byte) error {
+	return errXattrUnsupported
+}
+
+func RemoveXattr(path string, name string) error {
+	return errXattrUnsupported
+}